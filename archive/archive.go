@@ -0,0 +1,250 @@
+package archive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+	"golang.org/x/crypto/scrypt"
+	"google.golang.org/protobuf/proto"
+)
+
+// Version is the version of the archive format written by Export. Archives of a greater version are rejected
+const Version = 1
+
+const (
+	EncryptionScryptAESGCM = "scrypt-aes256-gcm"
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Archive is a snapshot of the registry content
+type Archive struct {
+	Version      int            `json:"version"`
+	CreatedAt    int64          `json:"created_at"`
+	Encryption   *Encryption    `json:"encryption,omitempty"`
+	Applications []*Application `json:"applications"`
+	Translations []*Translation `json:"translations,omitempty"`
+}
+
+// Encryption holds the parameters used to derive the key that encrypts the application secrets from a passphrase
+type Encryption struct {
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	N         int    `json:"n"`
+	R         int    `json:"r"`
+	P         int    `json:"p"`
+}
+
+type Application struct {
+	Application *ome.Application `json:"application"`
+	// Owner is the user the application belongs to
+	Owner string `json:"owner,omitempty"`
+	// EncryptedSecret replaces the application secret when the archive is encrypted
+	EncryptedSecret []byte               `json:"encrypted_secret,omitempty"`
+	ClientProfile   *oauth.ClientProfile `json:"client_profile,omitempty"`
+}
+
+type Translation struct {
+	FirstKey  string `json:"first_key"`
+	SecondKey string `json:"second_key"`
+	Value     string `json:"value"`
+}
+
+// Stores gives access to the registry content covered by archives
+type Stores struct {
+	Applications dao.ApplicationsDB
+	Clients      dao.ClientProfilesDB
+	Keys         dao.KeysDB
+	Translations *bome.DoubleMap
+}
+
+// Export creates an archive of the registry content. The application secrets are encrypted when passphrase is not empty
+func Export(stores *Stores, passphrase string) (*Archive, error) {
+	a := &Archive{
+		Version:   Version,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	var aead cipher.AEAD
+	if passphrase != "" {
+		a.Encryption = &Encryption{
+			Algorithm: EncryptionScryptAESGCM,
+			Salt:      make([]byte, 16),
+			N:         scryptN,
+			R:         scryptR,
+			P:         scryptP,
+		}
+		_, err := rand.Read(a.Encryption.Salt)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err = a.Encryption.cipher(passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cursor, err := stores.Applications.ListAllApplications()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	for cursor.HasNext() {
+		app, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &Application{Application: app}
+		if app.Info != nil {
+			entry.Owner = app.Info.CreatedBy
+		}
+
+		if aead != nil && app.Secret != "" {
+			entry.Application = proto.Clone(app).(*ome.Application)
+			entry.EncryptedSecret, err = seal(aead, app.Secret)
+			if err != nil {
+				return nil, err
+			}
+			entry.Application.Secret = ""
+		}
+
+		entry.ClientProfile, err = stores.Clients.GetClientProfile(app.Id)
+		if err != nil && !bome.IsNotFound(err) {
+			return nil, err
+		}
+		a.Applications = append(a.Applications, entry)
+	}
+
+	if stores.Translations != nil {
+		a.Translations, err = exportTranslations(stores.Translations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func exportTranslations(translations *bome.DoubleMap) ([]*Translation, error) {
+	cursor, err := translations.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var list []*Translation
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+		entry := o.(*bome.DoubleMapEntry)
+		list = append(list, &Translation{
+			FirstKey:  entry.FirstKey,
+			SecondKey: entry.SecondKey,
+			Value:     entry.Value,
+		})
+	}
+	return list, nil
+}
+
+// Write encodes the archive in w
+func Write(w io.Writer, a *Archive) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(a)
+}
+
+// Read decodes an archive from r and checks its version
+func Read(r io.Reader) (*Archive, error) {
+	a := new(Archive)
+	err := json.NewDecoder(r).Decode(a)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Version < 1 || a.Version > Version {
+		return nil, fmt.Errorf("archive: unsupported version %d", a.Version)
+	}
+	return a, nil
+}
+
+// Decrypt restores the application secrets of an encrypted archive
+func (a *Archive) Decrypt(passphrase string) error {
+	if a.Encryption == nil {
+		return nil
+	}
+
+	if a.Encryption.Algorithm != EncryptionScryptAESGCM {
+		return fmt.Errorf("archive: unsupported encryption %q", a.Encryption.Algorithm)
+	}
+
+	aead, err := a.Encryption.cipher(passphrase)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range a.Applications {
+		if len(entry.EncryptedSecret) == 0 {
+			continue
+		}
+
+		secret, err := open(aead, entry.EncryptedSecret)
+		if err != nil {
+			return fmt.Errorf("archive: wrong passphrase or corrupted secret of %s", entry.Application.Id)
+		}
+		entry.Application.Secret = secret
+		entry.EncryptedSecret = nil
+	}
+	a.Encryption = nil
+	return nil
+}
+
+func (e *Encryption) cipher(passphrase string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, secret string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+func open(aead cipher.AEAD, data []byte) (string, error) {
+	if len(data) < aead.NonceSize() {
+		return "", errors.BadInput
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	secret, err := aead.Open(nil, nonce, sealed, nil)
+	return string(secret), err
+}
@@ -0,0 +1,322 @@
+package archive
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/libome"
+	"google.golang.org/protobuf/proto"
+)
+
+// memApplicationsDB keeps the applications in memory
+type memApplicationsDB struct {
+	dao.ApplicationsDB
+	apps map[string]*ome.Application
+}
+
+func (m *memApplicationsDB) ListAllApplications(...dao.ApplicationFilter) (dao.AppCursor, error) {
+	var ids []string
+	for id := range m.apps {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	c := &sliceCursor{}
+	for _, id := range ids {
+		c.apps = append(c.apps, proto.Clone(m.apps[id]).(*ome.Application))
+	}
+	return c, nil
+}
+
+func (m *memApplicationsDB) CreateApplication(a *ome.Application) error {
+	return m.SaveApplication(a)
+}
+
+func (m *memApplicationsDB) SaveApplication(a *ome.Application) error {
+	m.apps[a.Id] = proto.Clone(a).(*ome.Application)
+	return nil
+}
+
+func (m *memApplicationsDB) DeleteApplication(id string) error {
+	delete(m.apps, id)
+	return nil
+}
+
+type sliceCursor struct {
+	apps []*ome.Application
+}
+
+func (c *sliceCursor) HasNext() bool {
+	return len(c.apps) > 0
+}
+
+func (c *sliceCursor) Next() (*ome.Application, error) {
+	a := c.apps[0]
+	c.apps = c.apps[1:]
+	return a, nil
+}
+
+func (c *sliceCursor) Close() error {
+	return nil
+}
+
+func newTestStores(t *testing.T, apps ...*ome.Application) *Stores {
+	db, err := sql.Open(bome.SQLite3, filepath.Join(t.TempDir(), "registry.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	clients, err := dao.NewSQLClientProfilesDB(db, bome.SQLite3, dao.ClientProfilesTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	translations, err := bome.NewDoubleMap(db, bome.SQLite3, "translations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &memApplicationsDB{apps: map[string]*ome.Application{}}
+	for _, a := range apps {
+		_ = m.SaveApplication(a)
+	}
+	return &Stores{Applications: m, Clients: clients, Translations: translations}
+}
+
+func testApplication(id string, label string) *ome.Application {
+	return &ome.Application{
+		Id:     id,
+		Secret: id + "-secret",
+		Info:   &ome.AppInfo{ApplicationId: id, Label: label, CreatedBy: "alice"},
+	}
+}
+
+func TestExportEncrypted(t *testing.T) {
+	stores := newTestStores(t, testApplication("a", "A"), testApplication("b", "B"))
+	err := stores.Clients.SaveClientProfile(oauth.DefaultClientProfile("a", "https://a.example.com/cb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stores.Translations.Save(&bome.DoubleMapEntry{FirstKey: "a", SecondKey: "fr", Value: "Application A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := Export(stores, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Applications) != 2 || len(exported.Translations) != 1 {
+		t.Fatalf("%d applications and %d translations exported", len(exported.Applications), len(exported.Translations))
+	}
+	if exported.Applications[0].ClientProfile == nil || exported.Applications[1].ClientProfile != nil {
+		t.Error("the client profiles were not exported with their application")
+	}
+	if exported.Applications[0].Owner != "alice" {
+		t.Errorf("owner is %q", exported.Applications[0].Owner)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := Write(buffer, exported); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buffer.String(), "-secret") {
+		t.Fatal("the encrypted archive holds the secrets in clear")
+	}
+	if stores.Applications.(*memApplicationsDB).apps["a"].Secret != "a-secret" {
+		t.Error("the export changed the stored secret")
+	}
+
+	read, err := Read(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := read.Decrypt("wrong"); err == nil {
+		t.Fatal("the archive was decrypted with a wrong passphrase")
+	}
+
+	read, _ = Read(bytes.NewReader(buffer.Bytes()))
+	if err := read.Decrypt("passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if read.Encryption != nil {
+		t.Error("the decrypted archive is still marked encrypted")
+	}
+	for _, entry := range read.Applications {
+		if entry.Application.Secret != entry.Application.Id+"-secret" || entry.EncryptedSecret != nil {
+			t.Errorf("secret of %s decrypted as %q", entry.Application.Id, entry.Application.Secret)
+		}
+	}
+}
+
+func TestExportClear(t *testing.T) {
+	stores := newTestStores(t, testApplication("a", "A"))
+	exported, err := Export(stores, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Encryption != nil || exported.Applications[0].Application.Secret != "a-secret" {
+		t.Error("the archive without passphrase was encrypted")
+	}
+	if err := exported.Decrypt("any"); err != nil {
+		t.Errorf("Decrypt() = %v on a clear archive", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "current version", content: `{"version":1,"applications":[]}`},
+		{name: "no version", content: `{"applications":[]}`, wantErr: true},
+		{name: "future version", content: `{"version":2,"applications":[]}`, wantErr: true},
+		{name: "malformed", content: `{"version":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptUnsupportedAlgorithm(t *testing.T) {
+	a := &Archive{Version: Version, Encryption: &Encryption{Algorithm: "rot13"}}
+	if err := a.Decrypt("passphrase"); err == nil {
+		t.Error("an unknown encryption was accepted")
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	archived := func() *Archive {
+		return &Archive{
+			Version: Version,
+			Applications: []*Application{
+				{Application: testApplication("a", "A renamed"), Owner: "bob"},
+				{Application: testApplication("b", "B")},
+				{Application: testApplication("d", "D")},
+			},
+			Translations: []*Translation{{FirstKey: "a", SecondKey: "fr", Value: "A"}},
+		}
+	}
+
+	tests := []struct {
+		mode      string
+		want      []string
+		wantClear bool
+	}{
+		{mode: ModeMerge, want: []string{"update a [info]", "create d"}},
+		{mode: ModeReplace, want: []string{"update a [info]", "create d", "delete c"}, wantClear: true},
+		{mode: ModeSkipExisting, want: []string{"skip a", "skip b", "create d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			stores := newTestStores(t, testApplication("a", "A"), testApplication("b", "B"), testApplication("c", "C"))
+
+			plan, err := NewPlan(stores, archived(), tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var changes []string
+			for _, c := range plan.Changes {
+				description := c.Action + " " + c.ApplicationID
+				if len(c.Fields) > 0 {
+					description += " [" + strings.Join(c.Fields, ",") + "]"
+				}
+				changes = append(changes, description)
+			}
+			if strings.Join(changes, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("changes are %v, want %v", changes, tt.want)
+			}
+			if plan.ClearTranslations != tt.wantClear || len(plan.Translations) != 1 {
+				t.Errorf("%d translations planned, cleared: %v", len(plan.Translations), plan.ClearTranslations)
+			}
+		})
+	}
+}
+
+func TestNewPlanErrors(t *testing.T) {
+	stores := newTestStores(t)
+
+	tests := []struct {
+		name    string
+		archive *Archive
+		mode    string
+	}{
+		{name: "unknown mode", archive: &Archive{Version: Version}, mode: "overwrite"},
+		{name: "encrypted", archive: &Archive{Version: Version, Encryption: &Encryption{}}, mode: ModeMerge},
+		{name: "application without id", archive: &Archive{Version: Version, Applications: []*Application{{Application: &ome.Application{}}}}, mode: ModeMerge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPlan(stores, tt.archive, tt.mode); err == nil {
+				t.Error("NewPlan() succeeded")
+			}
+		})
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	stores := newTestStores(t, testApplication("a", "A"), testApplication("c", "C"))
+	err := stores.Translations.Save(&bome.DoubleMapEntry{FirstKey: "c", SecondKey: "fr", Value: "C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := oauth.DefaultClientProfile("", "https://d.example.com/cb")
+	a := &Archive{
+		Version: Version,
+		Applications: []*Application{
+			{Application: testApplication("a", "A renamed")},
+			{Application: testApplication("d", "D"), ClientProfile: profile},
+		},
+		Translations: []*Translation{{FirstKey: "d", SecondKey: "fr", Value: "D"}},
+	}
+
+	plan, err := NewPlan(stores, a, ModeReplace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Apply(stores); err != nil {
+		t.Fatal(err)
+	}
+
+	apps := stores.Applications.(*memApplicationsDB).apps
+	if len(apps) != 2 || apps["a"].Info.Label != "A renamed" || apps["d"] == nil {
+		t.Errorf("applications after import: %v", apps)
+	}
+	saved, err := stores.Clients.GetClientProfile("d")
+	if err != nil || !saved.MatchRedirectURI("https://d.example.com/cb") {
+		t.Errorf("profile of d is %+v, %v", saved, err)
+	}
+	if _, err := stores.Translations.Get("c", "fr"); !bome.IsNotFound(err) {
+		t.Error("the replaced translations were kept")
+	}
+	if value, err := stores.Translations.Get("d", "fr"); err != nil || value != "D" {
+		t.Errorf("translation of d is %q, %v", value, err)
+	}
+
+	buffer := &bytes.Buffer{}
+	plan.Print(buffer)
+	if !strings.Contains(buffer.String(), "1 to create, 1 to update, 1 to delete, 0 skipped") {
+		t.Errorf("printed plan:\n%s", buffer.String())
+	}
+}
@@ -0,0 +1,275 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/libome"
+)
+
+// Import modes
+const (
+	// ModeMerge creates the missing applications and updates the existing ones
+	ModeMerge = "merge"
+	// ModeReplace makes the registry content identical to the archive: applications absent from the archive are deleted
+	ModeReplace = "replace"
+	// ModeSkipExisting only creates the missing applications
+	ModeSkipExisting = "skip-existing"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionSkip   = "skip"
+)
+
+// Change is a planned modification of an application
+type Change struct {
+	Action        string
+	ApplicationID string
+	// Fields lists the modified fields of an update
+	Fields []string
+
+	entry *Application
+}
+
+// Plan lists the changes an import applies to the registry
+type Plan struct {
+	Changes      []*Change
+	Translations []*Translation
+	// ClearTranslations is set when the existing translations are replaced by the archive ones
+	ClearTranslations bool
+}
+
+// NewPlan compares the decrypted archive with the registry content and computes the changes required by mode
+func NewPlan(stores *Stores, a *Archive, mode string) (*Plan, error) {
+	if mode != ModeMerge && mode != ModeReplace && mode != ModeSkipExisting {
+		return nil, fmt.Errorf("archive: unsupported import mode %q", mode)
+	}
+
+	if a.Encryption != nil {
+		return nil, fmt.Errorf("archive: secrets must be decrypted before import")
+	}
+
+	current := map[string]*ome.Application{}
+	cursor, err := stores.Applications.ListAllApplications()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+	for cursor.HasNext() {
+		app, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+		current[app.Id] = app
+	}
+
+	plan := &Plan{}
+	archived := map[string]bool{}
+	for _, entry := range a.Applications {
+		if entry.Application == nil || entry.Application.Id == "" {
+			return nil, fmt.Errorf("archive: application without id")
+		}
+		archived[entry.Application.Id] = true
+
+		if entry.Owner != "" {
+			if entry.Application.Info == nil {
+				entry.Application.Info = &ome.AppInfo{ApplicationId: entry.Application.Id}
+			}
+			entry.Application.Info.CreatedBy = entry.Owner
+		}
+
+		change := &Change{ApplicationID: entry.Application.Id, entry: entry}
+		existing, found := current[entry.Application.Id]
+		switch {
+		case !found:
+			change.Action = ActionCreate
+		case mode == ModeSkipExisting:
+			change.Action = ActionSkip
+		default:
+			change.Fields, err = diff(stores, existing, entry)
+			if err != nil {
+				return nil, err
+			}
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.Action = ActionUpdate
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	if mode == ModeReplace {
+		var deleted []string
+		for id := range current {
+			if !archived[id] {
+				deleted = append(deleted, id)
+			}
+		}
+		sort.Strings(deleted)
+		for _, id := range deleted {
+			plan.Changes = append(plan.Changes, &Change{Action: ActionDelete, ApplicationID: id})
+		}
+	}
+
+	for _, t := range a.Translations {
+		if mode == ModeSkipExisting && stores.Translations != nil {
+			_, err := stores.Translations.Get(t.FirstKey, t.SecondKey)
+			if err == nil {
+				continue
+			}
+			if !bome.IsNotFound(err) {
+				return nil, err
+			}
+		}
+		plan.Translations = append(plan.Translations, t)
+	}
+	plan.ClearTranslations = mode == ModeReplace
+	return plan, nil
+}
+
+// diff returns the names of the fields that differ between the registry and the archive versions of an application
+func diff(stores *Stores, existing *ome.Application, entry *Application) ([]string, error) {
+	var fields []string
+
+	before, err := jsonFields(existing)
+	if err != nil {
+		return nil, err
+	}
+	after, err := jsonFields(entry.Application)
+	if err != nil {
+		return nil, err
+	}
+	for name := range before {
+		if _, found := after[name]; !found {
+			after[name] = nil
+		}
+	}
+	for name, value := range after {
+		if !reflect.DeepEqual(before[name], value) {
+			fields = append(fields, name)
+		}
+	}
+
+	if entry.ClientProfile != nil {
+		profile, err := stores.Clients.GetClientProfile(existing.Id)
+		if err != nil && !bome.IsNotFound(err) {
+			return nil, err
+		}
+		if profile == nil || !sameProfile(profile, entry.ClientProfile) {
+			fields = append(fields, "client_profile")
+		}
+	}
+
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func jsonFields(o interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(encoded, &fields)
+	return fields, err
+}
+
+func sameProfile(a, b *oauth.ClientProfile) bool {
+	ea, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	eb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(ea) == string(eb)
+}
+
+// Print writes a human readable description of the plan in w
+func (p *Plan) Print(w io.Writer) {
+	symbols := map[string]string{
+		ActionCreate: "+",
+		ActionUpdate: "~",
+		ActionDelete: "-",
+		ActionSkip:   "=",
+	}
+
+	counts := map[string]int{}
+	for _, c := range p.Changes {
+		counts[c.Action]++
+		if len(c.Fields) > 0 {
+			_, _ = fmt.Fprintf(w, "%s %s (%v)\n", symbols[c.Action], c.ApplicationID, c.Fields)
+		} else {
+			_, _ = fmt.Fprintf(w, "%s %s\n", symbols[c.Action], c.ApplicationID)
+		}
+	}
+
+	translations := fmt.Sprintf("%d translations to save", len(p.Translations))
+	if p.ClearTranslations {
+		translations += ", existing translations cleared"
+	}
+	_, _ = fmt.Fprintf(w, "\n%d to create, %d to update, %d to delete, %d skipped. %s\n",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete], counts[ActionSkip], translations)
+}
+
+// Apply runs the plan changes against the registry. It stops at the first error
+func (p *Plan) Apply(stores *Stores) error {
+	for _, c := range p.Changes {
+		var err error
+		switch c.Action {
+		case ActionCreate:
+			err = stores.Applications.CreateApplication(c.entry.Application)
+		case ActionUpdate:
+			err = stores.Applications.SaveApplication(c.entry.Application)
+		case ActionDelete:
+			err = stores.Applications.DeleteApplication(c.ApplicationID)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("archive: could not %s application %s: %s", c.Action, c.ApplicationID, err)
+		}
+
+		if c.entry != nil && c.entry.ClientProfile != nil {
+			c.entry.ClientProfile.ApplicationID = c.ApplicationID
+			err = stores.Clients.SaveClientProfile(c.entry.ClientProfile)
+			if err != nil {
+				return fmt.Errorf("archive: could not save OAuth profile of application %s: %s", c.ApplicationID, err)
+			}
+		}
+	}
+
+	if stores.Translations == nil {
+		return nil
+	}
+
+	if p.ClearTranslations {
+		err := stores.Translations.Clear()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, t := range p.Translations {
+		err := stores.Translations.Save(&bome.DoubleMapEntry{
+			FirstKey:  t.FirstKey,
+			SecondKey: t.SecondKey,
+			Value:     t.Value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package bootstrap
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/validation"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+)
+
+const (
+	DefaultApplicationID = "ome"
+	// SecretSize is the number of random bytes of the generated secrets
+	SecretSize = 32
+)
+
+// Settings describe the master application the registry creates on its first start
+type Settings struct {
+	ApplicationID string
+	Label         string
+	Description   string
+	CallbackURL   string
+	Website       string
+	LogoURL       string
+}
+
+// SecretFilename returns the default file the secret of the application is written to in dir
+func (s *Settings) SecretFilename(dir string) string {
+	return filepath.Join(dir, s.ApplicationID+"-app.secret")
+}
+
+// Application returns the master application described by the settings, without secret
+func (s *Settings) Application() (*ome.Application, error) {
+	id := s.ApplicationID
+	if id == "" {
+		id = DefaultApplicationID
+	}
+
+	a := &ome.Application{
+		Id:               id,
+		Activated:        true,
+		Level:            ome.ApplicationLevel_Master,
+		OauthCallbackUrl: s.CallbackURL,
+		Info: &ome.AppInfo{
+			ApplicationId: id,
+			CreatedBy:     "ome",
+			Label:         s.Label,
+			Description:   s.Description,
+			LogoUrl:       s.LogoURL,
+			Website:       s.Website,
+		},
+	}
+	return a, validation.Application(a, validation.Options{AllowReserved: true})
+}
+
+// NewSecret returns a random secret of SecretSize bytes
+func NewSecret() (string, error) {
+	b := make([]byte, SecretSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Ensure creates the master application if missing and returns its secret to the instance that created it
+func Ensure(apps dao.ApplicationsDB, settings *Settings) (string, error) {
+	a, err := settings.Application()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = apps.GetApplication(a.Id)
+	if err == nil || !errors.IsNotFound(err) {
+		return "", err
+	}
+
+	a.Secret, err = NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = apps.CreateApplication(a)
+	if err == errors.Duplicate {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return a.Secret, nil
+}
+
+// Reset gives a new secret to the master application, and creates it when it does not exist
+func Reset(apps dao.ApplicationsDB, settings *Settings) (string, error) {
+	a, err := settings.Application()
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := apps.GetApplication(a.Id)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	if existing != nil {
+		a = existing
+	}
+
+	a.Secret, err = NewSecret()
+	if err != nil {
+		return "", err
+	}
+	return a.Secret, apps.SaveApplication(a)
+}
+
+// WriteSecret writes secret in a file only readable by its owner. An existing file is replaced
+func WriteSecret(filename string, secret string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	// TempFile creates the file with mode 0600
+	_, err = tmp.WriteString(secret)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// CheckPermissions fails if one of the existing files or directories can be accessed by other users than its owner
+func CheckPermissions(filenames ...string) error {
+	for _, filename := range filenames {
+		if filename == "" {
+			continue
+		}
+
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().Perm()&0077 != 0 {
+			mode := "600"
+			if info.IsDir() {
+				mode = "700"
+			}
+			return fmt.Errorf("bootstrap: %s has unsafe permissions %04o, run chmod %s %s", filename, info.Mode().Perm(), mode, filename)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/server"
+	"github.com/omecodes/app-registry/validation"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	remoteAddress   string
+	remoteCAFile    string
+	remoteCertFile  string
+	remoteKeyFile   string
+	remoteAppKey    string
+	remoteAppSecret string
+	remoteUserToken string
+	remoteInsecure  bool
+	remoteTimeout   time.Duration
+)
+
+// appsBackend is the applications store of the apps commands: the database or a running registry
+type appsBackend interface {
+	List(owner string, filters ...dao.ApplicationFilter) ([]*ome.Application, error)
+	Get(id string) (*ome.Application, error)
+	Save(a *ome.Application) error
+	Delete(id string) error
+}
+
+func openAppsBackend() (appsBackend, error) {
+	if dsn != "" {
+		db, err := openDB()
+		if err != nil {
+			return nil, err
+		}
+
+		b := new(localBackend)
+		b.apps, err = openApplicationsDB(db)
+		if err != nil {
+			return nil, err
+		}
+
+		b.clients, err = openClientProfilesDB(db)
+		if err != nil {
+			return nil, err
+		}
+
+		b.keys, err = dao.NewSQLKeysDB(db, bome.MySQL, dao.KeysTable)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	if remoteAddress == "" {
+		return nil, errors.New("either --dsn or --remote is required")
+	}
+
+	conn, err := dialRegistry()
+	if err != nil {
+		return nil, err
+	}
+	return &remoteBackend{client: ome.NewApplicationsClient(conn)}, nil
+}
+
+// requireDSN fails for the commands that need a direct access to the database
+func requireDSN(command string) error {
+	if dsn == "" {
+		return fmt.Errorf("%s is not exposed by the registry gRPC API and requires --dsn", command)
+	}
+	return nil
+}
+
+// dialRegistry connects to the registry gRPC node, over TLS outside of dev mode
+func dialRegistry() (*grpc.ClientConn, error) {
+	tlsConfig := &tls.Config{}
+
+	if remoteCAFile != "" {
+		caBytes, err := ioutil.ReadFile(remoteCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificate found in %s", remoteCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if remoteCertFile != "" || remoteKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(remoteCertFile, remoteKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var (
+		opts  []grpc.DialOption
+		creds []credentials.PerRPCCredentials
+	)
+	if remoteAppKey != "" {
+		creds = append(creds, ome.NewGRPCProxy(remoteAppKey, remoteAppSecret))
+	}
+	if remoteUserToken != "" {
+		creds = append(creds, ome.NewGRPCClientJwt(remoteUserToken))
+	}
+
+	if remoteInsecure {
+		// credentials are sent in clear without TLS, which is only acceptable to a registry on the same host
+		host, _, err := net.SplitHostPort(remoteAddress)
+		if err != nil {
+			host = remoteAddress
+		}
+		if !validation.IsLoopback(host) {
+			return nil, fmt.Errorf("--insecure is only allowed with a loopback --remote address, %s is not", host)
+		}
+
+		opts = append(opts, grpc.WithInsecure())
+		for _, c := range creds {
+			opts = append(opts, grpc.WithPerRPCCredentials(plaintextCredentials{c}))
+		}
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		for _, c := range creds {
+			opts = append(opts, grpc.WithPerRPCCredentials(c))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+	return grpc.DialContext(ctx, remoteAddress, append(opts, grpc.WithBlock())...)
+}
+
+// plaintextCredentials lets credentials be sent to a registry started in dev mode, which does not use TLS
+type plaintextCredentials struct {
+	credentials.PerRPCCredentials
+}
+
+func (plaintextCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+type localBackend struct {
+	apps    dao.ApplicationsDB
+	clients dao.ClientProfilesDB
+	keys    dao.KeysDB
+}
+
+func (b *localBackend) List(owner string, filters ...dao.ApplicationFilter) ([]*ome.Application, error) {
+	var (
+		cursor dao.AppCursor
+		err    error
+	)
+	if owner != "" {
+		cursor, err = b.apps.ListApplicationForUser(owner, filters...)
+	} else {
+		cursor, err = b.apps.ListAllApplications(filters...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var apps []*ome.Application
+	for cursor.HasNext() {
+		a, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, a)
+	}
+	return apps, nil
+}
+
+func (b *localBackend) Get(id string) (*ome.Application, error) {
+	a, err := b.apps.GetApplication(id)
+	if bome.IsNotFound(err) {
+		return nil, fmt.Errorf("application %s not found", id)
+	}
+	return a, err
+}
+
+func (b *localBackend) Save(a *ome.Application) error {
+	return b.apps.SaveApplication(a)
+}
+
+func (b *localBackend) Delete(id string) error {
+	return b.apps.DeleteApplication(id)
+}
+
+// remoteBackend calls the registry Applications gRPC API
+type remoteBackend struct {
+	client ome.ApplicationsClient
+}
+
+func (b *remoteBackend) List(owner string, filters ...dao.ApplicationFilter) ([]*ome.Application, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	stream, err := b.client.ListApplications(ctx, &ome.ListApplicationsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	var apps []*ome.Application
+	for {
+		a, err := stream.Recv()
+		if err == io.EOF {
+			return apps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if owner != "" && (a.Info == nil || a.Info.CreatedBy != owner) {
+			continue
+		}
+		if acceptedBy(a, filters) {
+			apps = append(apps, a)
+		}
+	}
+}
+
+func acceptedBy(a *ome.Application, filters []dao.ApplicationFilter) bool {
+	for _, filter := range filters {
+		if !filter(a) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *remoteBackend) Get(id string) (*ome.Application, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	rsp, err := b.client.GetApplication(ctx, &ome.GetApplicationRequest{ApplicationId: id})
+	if err != nil {
+		return nil, err
+	}
+	rsp.Application.Secret = ""
+	return rsp.Application, nil
+}
+
+func (b *remoteBackend) Save(a *ome.Application) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	var header metadata.MD
+	rsp, err := b.client.RegisterApplication(ctx, &ome.RegisterApplicationRequest{Application: a}, grpc.Header(&header))
+	if err != nil {
+		return err
+	}
+
+	if rsp.IdExists {
+		return fmt.Errorf("application id %s is already taken", a.Id)
+	}
+
+	// the generated credentials are only returned once
+	if ids := header.Get(server.ApplicationIDMetadata); len(ids) > 0 {
+		a.Id = ids[0]
+		fmt.Printf("application %s created\n", a.Id)
+	}
+	if secrets := header.Get(server.ApplicationSecretMetadata); len(secrets) > 0 {
+		fmt.Printf("secret of %s, printed only once: %s\n", a.Id, secrets[0])
+	}
+	return nil
+}
+
+func (b *remoteBackend) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	_, err := b.client.DeRegister(ctx, &ome.DeRegisterApplicationRequest{ApplicationId: id})
+	return err
+}
@@ -3,18 +3,59 @@ package cmd
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"github.com/omecodes/app-registry/archive"
+	"github.com/omecodes/app-registry/bootstrap"
 	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/reconcile"
+	"github.com/omecodes/app-registry/validation"
 	"github.com/omecodes/bome"
+	"github.com/omecodes/common/utils/prompt"
 	"github.com/omecodes/libome"
 	"github.com/spf13/cobra"
 	"io/ioutil"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var input string
 
+var (
+	archiveFilename string
+	passphraseFile  string
+	encryptArchive  bool
+	importMode      string
+	dryRun          bool
+	adoptPrivileged bool
+	definitionPaths []string
+)
+
+var rotatedSecretFile string
+
+var (
+	levelFilter     string
+	categoryFilters []string
+	activatedFilter string
+	ownerFilter     string
+)
+
+var (
+	appLabel       string
+	appDescription string
+	appWebsite     string
+	appLogoURL     string
+	appCallbackURL string
+	appActivated   bool
+	appCategories  []string
+)
+
 var appIDList []string
 
+var bootstrapReset bool
+
 var appCMD = &cobra.Command{
 	Use:   "apps",
 	Short: "Manage applications store",
@@ -26,76 +67,596 @@ var appCMD = &cobra.Command{
 var addAppCMD = &cobra.Command{
 	Use:   "add",
 	Short: "Add or update applications info from json file",
-	Run: func(cmd *cobra.Command, args []string) {
-		err := application.InitDirs()
-		if err != nil {
-			log.Fatalln(err)
-		}
-
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var list []*ome.Application
 
 		inputBytes, err := ioutil.ReadFile(input)
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 
 		err = json.Unmarshal(inputBytes, &list)
 		if err != nil {
-			log.Fatalln(err)
-		}
-
-		db, err := sql.Open("mysql", dsn)
-		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 
-		appDB, err := dao.NewSQLApplicationsDB(db, bome.MySQL, "applications")
+		backend, err := openAppsBackend()
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 
+		failures := 0
 		for _, a := range list {
-			err = appDB.SaveApplication(a)
+			err = validation.Application(a, validation.Options{AllowReserved: true})
+			if err == nil {
+				err = backend.Save(a)
+			}
 			if err != nil {
+				failures++
 				log.Printf("could not save %s app: %s\n", a.Id, err)
 			}
 		}
+		return failuresError(failures, len(list), "saved")
 	},
 }
 
 var delAppCMD = &cobra.Command{
 	Use:   "del",
 	Short: "Delete applications by ID",
-	Run: func(cmd *cobra.Command, args []string) {
-		err := application.InitDirs()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backend, err := openAppsBackend()
 		if err != nil {
-			log.Fatalln("could not initialize application dirs:", err)
+			return err
+		}
+
+		failures := 0
+		for _, id := range appIDList {
+			err = backend.Delete(id)
+			if err != nil {
+				failures++
+				log.Printf("could not delete application %s: %s\n", id, err)
+			}
 		}
+		return failuresError(failures, len(appIDList), "deleted")
+	},
+}
 
-		db, err := sql.Open("mysql", dsn)
+var listAppCMD = &cobra.Command{
+	Use:   "list",
+	Short: "List applications",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filters, err := applicationFilters()
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 
-		appDB, err := dao.NewSQLApplicationsDB(db, bome.MySQL, "applications")
+		backend, err := openAppsBackend()
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
 
-		for _, id := range appIDList {
-			err = appDB.DeleteApplication(id)
+		apps, err := backend.List(ownerFilter, filters...)
+		if err != nil {
+			return err
+		}
+		return printApplications(os.Stdout, apps)
+	},
+}
+
+var getAppCMD = &cobra.Command{
+	Use:   "get <id>...",
+	Short: "Print applications by ID",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backend, err := openAppsBackend()
+		if err != nil {
+			return err
+		}
+
+		var apps []*ome.Application
+		for _, id := range args {
+			a, err := backend.Get(id)
 			if err != nil {
-				log.Printf("could not delete application %s: %s\n", id, err)
+				return err
+			}
+			apps = append(apps, a)
+		}
+		return printApplications(os.Stdout, apps)
+	},
+}
+
+var updateAppCMD = &cobra.Command{
+	Use:   "update <id>",
+	Short: "Update the info, callback URL, categories or activation state of an application",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backend, err := openAppsBackend()
+		if err != nil {
+			return err
+		}
+
+		a, err := backend.Get(args[0])
+		if err != nil {
+			return err
+		}
+
+		if a.Info == nil {
+			a.Info = &ome.AppInfo{ApplicationId: a.Id}
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("label") {
+			a.Info.Label = appLabel
+		}
+		if flags.Changed("description") {
+			a.Info.Description = appDescription
+		}
+		if flags.Changed("website") {
+			a.Info.Website = appWebsite
+		}
+		if flags.Changed("logo-url") {
+			a.Info.LogoUrl = appLogoURL
+		}
+		if flags.Changed("callback-url") {
+			a.OauthCallbackUrl = appCallbackURL
+		}
+		if flags.Changed("activated") {
+			a.Activated = appActivated
+		}
+		if flags.Changed("categories") {
+			a.Categories = nil
+			for _, name := range appCategories {
+				category, err := parseCategory(name)
+				if err != nil {
+					return err
+				}
+				a.Categories = append(a.Categories, category)
+			}
+		}
+
+		err = validation.Application(a, validation.Options{AllowReserved: true})
+		if err != nil {
+			return err
+		}
+
+		err = backend.Save(a)
+		if err != nil {
+			return err
+		}
+		return printApplications(os.Stdout, []*ome.Application{a})
+	},
+}
+
+var setLevelAppCMD = &cobra.Command{
+	Use:   "set-level <id> <root|master|internal|external>",
+	Short: "Change the level of an application",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		level, err := parseLevel(args[1])
+		if err != nil {
+			return err
+		}
+
+		backend, err := openAppsBackend()
+		if err != nil {
+			return err
+		}
+
+		a, err := backend.Get(args[0])
+		if err != nil {
+			return err
+		}
+
+		a.Level = level
+		err = validation.Application(a, validation.Options{AllowReserved: true})
+		if err != nil {
+			return err
+		}
+
+		err = backend.Save(a)
+		if err != nil {
+			return err
+		}
+		return printApplications(os.Stdout, []*ome.Application{a})
+	},
+}
+
+var rotateSecretAppCMD = &cobra.Command{
+	Use:   "rotate-secret <id>",
+	Short: "Give a new secret to an application and print it once",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := requireDSN("rotate-secret")
+		if err != nil {
+			return err
+		}
+
+		db, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		apps, err := openApplicationsDB(db)
+		if err != nil {
+			return err
+		}
+
+		a, err := apps.GetApplication(args[0])
+		if err != nil {
+			return err
+		}
+
+		secret, err := bootstrap.NewSecret()
+		if err != nil {
+			return err
+		}
+
+		// the new secret is written first, so that it is never lost once saved
+		if rotatedSecretFile != "" {
+			err = bootstrap.WriteSecret(rotatedSecretFile, secret)
+			if err != nil {
+				return err
 			}
 		}
+
+		a.Secret = secret
+		err = apps.SaveApplication(a)
+		if err != nil {
+			return err
+		}
+
+		if rotatedSecretFile != "" {
+			fmt.Printf("new secret of %s written to %s\n", a.Id, rotatedSecretFile)
+			return nil
+		}
+		fmt.Println(secret)
+		return nil
 	},
 }
 
+var bootstrapAppCMD = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Create the master application, or give it a new secret with --reset",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := requireDSN("bootstrap")
+		if err != nil {
+			return err
+		}
+
+		db, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		apps, err := openApplicationsDB(db)
+		if err != nil {
+			return err
+		}
+
+		var secret string
+		if bootstrapReset {
+			secret, err = bootstrap.Reset(apps, &bootstrapSettings)
+		} else {
+			secret, err = bootstrap.Ensure(apps, &bootstrapSettings)
+		}
+		if err != nil {
+			return err
+		}
+		if secret == "" {
+			return fmt.Errorf("application %s already exists, use --reset to give it a new secret", bootstrapSettings.ApplicationID)
+		}
+
+		if bootstrapSecretFile != "" {
+			err = bootstrap.WriteSecret(bootstrapSecretFile, secret)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("secret of %s written to %s\n", bootstrapSettings.ApplicationID, bootstrapSecretFile)
+			return nil
+		}
+		fmt.Println(secret)
+		return nil
+	},
+}
+
+var exportAppCMD = &cobra.Command{
+	Use:   "export",
+	Short: "Export applications, OAuth profiles and translations to a versioned archive",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := requireDSN("export")
+		if err != nil {
+			return err
+		}
+
+		stores, err := archiveStores()
+		if err != nil {
+			return err
+		}
+
+		var passphrase string
+		if encryptArchive || passphraseFile != "" {
+			passphrase, err = archivePassphrase()
+			if err != nil {
+				return err
+			}
+		} else {
+			log.Println("warning: application secrets are exported in clear. Use --encrypt to protect them")
+		}
+
+		a, err := archive.Export(stores, passphrase)
+		if err != nil {
+			return err
+		}
+
+		out := os.Stdout
+		if archiveFilename != "" && archiveFilename != "-" {
+			out, err = os.OpenFile(archiveFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = out.Close()
+			}()
+		}
+		return archive.Write(out, a)
+	},
+}
+
+var importAppCMD = &cobra.Command{
+	Use:   "import",
+	Short: "Import applications, OAuth profiles and translations from an archive",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := requireDSN("import")
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(archiveFilename)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		a, err := archive.Read(file)
+		if err != nil {
+			return err
+		}
+
+		if a.Encryption != nil {
+			passphrase, err := archivePassphrase()
+			if err != nil {
+				return err
+			}
+			err = a.Decrypt(passphrase)
+			if err != nil {
+				return err
+			}
+		}
+
+		stores, err := archiveStores()
+		if err != nil {
+			return err
+		}
+
+		plan, err := archive.NewPlan(stores, a, importMode)
+		if err != nil {
+			return err
+		}
+		plan.Print(os.Stdout)
+
+		if dryRun {
+			return nil
+		}
+		return plan.Apply(stores)
+	},
+}
+
+var applyAppCMD = &cobra.Command{
+	Use:   "apply",
+	Short: "Reconcile the applications with declarative definition files",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stores, plan, err := reconcilePlan()
+		if err != nil {
+			return err
+		}
+		plan.Print(os.Stdout)
+
+		if dryRun || plan.Empty() {
+			return nil
+		}
+		return plan.Apply(stores)
+	},
+}
+
+var diffAppCMD = &cobra.Command{
+	Use:   "diff",
+	Short: "Report the drift between the applications and declarative definition files. Exits with status 2 on drift",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, plan, err := reconcilePlan()
+		if err != nil {
+			return err
+		}
+
+		plan.Print(os.Stdout)
+		if !plan.Empty() {
+			os.Exit(2)
+		}
+		return nil
+	},
+}
+
+func reconcilePlan() (*reconcile.Stores, *reconcile.Plan, error) {
+	err := requireDSN("reconciliation")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	definitions, err := reconcile.Load(definitionPaths...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stores := new(reconcile.Stores)
+	stores.Applications, err = openApplicationsDB(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stores.Managed, err = dao.NewSQLManagedApplicationsDB(db, bome.MySQL, dao.ManagedApplicationsTable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stores.Clients, err = openClientProfilesDB(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stores.Keys, err = dao.NewSQLKeysDB(db, bome.MySQL, dao.KeysTable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := reconcile.NewPlan(stores, definitions, reconcile.Options{AdoptPrivileged: adoptPrivileged})
+	if err != nil {
+		return nil, nil, err
+	}
+	return stores, plan, nil
+}
+
+func openDB() (*sql.DB, error) {
+	err := application.InitDirs()
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open("mysql", dsn)
+}
+
+func applicationFilters() ([]dao.ApplicationFilter, error) {
+	var filters []dao.ApplicationFilter
+	if levelFilter != "" {
+		level, err := parseLevel(levelFilter)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, dao.WithLevel(level))
+	}
+
+	for _, name := range categoryFilters {
+		category, err := parseCategory(name)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, dao.WithCategory(category))
+	}
+
+	if activatedFilter != "" {
+		activated, err := strconv.ParseBool(activatedFilter)
+		if err != nil {
+			return nil, fmt.Errorf("bad activated filter %q", activatedFilter)
+		}
+		filters = append(filters, dao.WithActivated(activated))
+	}
+	return filters, nil
+}
+
+func parseLevel(name string) (ome.ApplicationLevel, error) {
+	for value, n := range ome.ApplicationLevel_name {
+		if strings.EqualFold(n, name) {
+			return ome.ApplicationLevel(value), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown application level %q", name)
+}
+
+func parseCategory(name string) (ome.Category, error) {
+	for value, n := range ome.Category_name {
+		if strings.EqualFold(n, name) {
+			return ome.Category(value), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown application category %q", name)
+}
+
+// failuresError returns an error when some of the total items could not be processed
+func failuresError(failures int, total int, action string) error {
+	if failures == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d of %d applications could not be %s", failures, total, action)
+}
+
+func archiveStores() (*archive.Stores, error) {
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+
+	stores := new(archive.Stores)
+	stores.Applications, err = openApplicationsDB(db)
+	if err != nil {
+		return nil, err
+	}
+
+	stores.Clients, err = openClientProfilesDB(db)
+	if err != nil {
+		return nil, err
+	}
+
+	stores.Keys, err = dao.NewSQLKeysDB(db, bome.MySQL, dao.KeysTable)
+	if err != nil {
+		return nil, err
+	}
+
+	stores.Translations, err = bome.NewDoubleMap(db, bome.MySQL, "attr_translations")
+	if err != nil {
+		return nil, err
+	}
+	return stores, nil
+}
+
+func archivePassphrase() (string, error) {
+	if passphraseFile != "" {
+		data, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return prompt.Password("Archive passphrase")
+}
+
 func init() {
-	appCMD.AddCommand(addAppCMD, delAppCMD)
+	appCMD.AddCommand(
+		addAppCMD, delAppCMD,
+		listAppCMD, getAppCMD, updateAppCMD, setLevelAppCMD, rotateSecretAppCMD, bootstrapAppCMD,
+		exportAppCMD, importAppCMD, applyAppCMD, diffAppCMD,
+	)
+	for _, c := range appCMD.Commands() {
+		// failures are reported through the returned error, the usage is only printed for bad invocations
+		c.SilenceUsage = true
+	}
+
 	flags := appCMD.PersistentFlags()
-	flags.StringVar(&dsn, "dsn", "", "DSN for the MySQL database (required)")
-	_ = cobra.MarkFlagRequired(flags, "dsn")
+	flags.StringVar(&dsn, "dsn", "", "DSN for the MySQL database. Without DSN, commands are sent to the registry at --remote")
+	flags.StringVar(&remoteAddress, "remote", "", "Address of the registry gRPC node")
+	flags.BoolVar(&remoteInsecure, "insecure", false, "Call the registry without TLS. Only for registries started with --dev on a loopback address")
+	flags.StringVar(&remoteCAFile, "ca", "", "CA certificate file used to verify the registry")
+	flags.StringVar(&remoteCertFile, "cert", "", "Client certificate file bound to the operator application")
+	flags.StringVar(&remoteKeyFile, "key", "", "Client certificate key file")
+	flags.StringVar(&remoteAppKey, "app-key", "", "Operator application ID, when not authenticated by certificate")
+	flags.StringVar(&remoteAppSecret, "app-secret", "", "Operator application secret")
+	flags.StringVar(&remoteUserToken, "user-token", "", "User JWT, required by master applications")
+	flags.DurationVar(&remoteTimeout, "timeout", 30*time.Second, "Timeout of the calls to the registry")
+	flags.StringVar(&masterKeyFile, "master-key-file", "", "Master keys file the application secrets are encrypted with, with --dsn")
+	flags.StringVar(&kmsDir, "kms-dir", "", "Directory of the local KMS master keys the application secrets are encrypted with, with --dsn")
 
 	flags = addAppCMD.PersistentFlags()
 	flags.StringVar(&input, "input", "", "Path to json file that contains application definitions")
@@ -104,4 +665,54 @@ func init() {
 	flags = delAppCMD.PersistentFlags()
 	flags.StringArrayVar(&appIDList, "ids", nil, "State of application id to delete")
 	_ = cobra.MarkFlagRequired(flags, "ids")
+
+	for _, c := range []*cobra.Command{listAppCMD, getAppCMD, updateAppCMD, setLevelAppCMD} {
+		c.PersistentFlags().StringVar(&outputFormat, "format", formatTable, "Output format: table, json or yaml")
+	}
+
+	flags = listAppCMD.PersistentFlags()
+	flags.StringVar(&levelFilter, "level", "", "Only list the applications of this level")
+	flags.StringArrayVar(&categoryFilters, "category", nil, "Only list the applications of this category")
+	flags.StringVar(&activatedFilter, "activated", "", "Only list the activated (true) or deactivated (false) applications")
+	flags.StringVar(&ownerFilter, "created-by", "", "Only list the applications created by this user")
+
+	flags = updateAppCMD.PersistentFlags()
+	flags.StringVar(&appLabel, "label", "", "Application label")
+	flags.StringVar(&appDescription, "description", "", "Application description")
+	flags.StringVar(&appWebsite, "website", "", "Application website")
+	flags.StringVar(&appLogoURL, "logo-url", "", "Application logo URL")
+	flags.StringVar(&appCallbackURL, "callback-url", "", "Application OAuth callback URL")
+	flags.BoolVar(&appActivated, "activated", false, "Application activation state")
+	flags.StringArrayVar(&appCategories, "categories", nil, "Application categories")
+
+	flags = rotateSecretAppCMD.PersistentFlags()
+	flags.StringVar(&rotatedSecretFile, "secret-file", "", "File the new secret is written to. The secret is printed otherwise")
+
+	flags = bootstrapAppCMD.PersistentFlags()
+	registerBootstrapFlags(flags, "")
+	flags.BoolVar(&bootstrapReset, "reset", false, "Give a new secret to the master application")
+	flags.StringVar(&bootstrapSecretFile, "secret-file", "", "File the secret is written to. The secret is printed otherwise")
+
+	flags = exportAppCMD.PersistentFlags()
+	flags.StringVar(&archiveFilename, "output", "", "Path of the archive file. Defaults to standard output")
+	flags.BoolVar(&encryptArchive, "encrypt", false, "Encrypt the application secrets with a passphrase")
+	flags.StringVar(&passphraseFile, "passphrase-file", "", "File containing the passphrase used to encrypt the application secrets")
+
+	flags = importAppCMD.PersistentFlags()
+	flags.StringVar(&archiveFilename, "input", "", "Path of the archive file")
+	flags.StringVar(&importMode, "mode", archive.ModeMerge, "Import mode: merge, replace or skip-existing")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the changes without applying them")
+	flags.StringVar(&passphraseFile, "passphrase-file", "", "File containing the passphrase used to decrypt the application secrets")
+	_ = cobra.MarkFlagRequired(flags, "input")
+
+	flags = applyAppCMD.PersistentFlags()
+	flags.StringArrayVarP(&definitionPaths, "file", "f", nil, "Application definitions file or directory")
+	flags.BoolVar(&adoptPrivileged, "adopt-privileged", false, "Allow adopting existing Root, Master and reserved applications")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the changes without applying them")
+	_ = cobra.MarkFlagRequired(flags, "file")
+
+	flags = diffAppCMD.PersistentFlags()
+	flags.StringArrayVarP(&definitionPaths, "file", "f", nil, "Application definitions file or directory")
+	flags.BoolVar(&adoptPrivileged, "adopt-privileged", false, "Allow adopting existing Root, Master and reserved applications")
+	_ = cobra.MarkFlagRequired(flags, "file")
 }
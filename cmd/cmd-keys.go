@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/app-registry/server"
+	"github.com/omecodes/bome"
+	"github.com/spf13/cobra"
+)
+
+var (
+	masterKeyFile    string
+	kmsDir           string
+	reencryptBatch   int
+	skipReencryption bool
+)
+
+var keysCMD = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the master keys the registry secrets are encrypted with",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var initKeysCMD = &cobra.Command{
+	Use:          "init",
+	Short:        "Create the master keys file or the local KMS directory the registry secrets are encrypted with",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		switch {
+		case masterKeyFile != "" && kmsDir != "":
+			return errors.New("--master-key-file and --kms-dir are mutually exclusive")
+		case masterKeyFile != "":
+			_, err = envelope.InitFileKeyring(masterKeyFile)
+		case kmsDir != "":
+			_, err = envelope.InitLocalKMS(kmsDir)
+		default:
+			return errors.New("either --master-key-file or --kms-dir is required")
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("master keys created")
+		return nil
+	},
+}
+
+var rotateKeysCMD = &cobra.Command{
+	Use:          "rotate",
+	Short:        "Create a new master key version and re-encrypt the registry secrets with it",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var (
+			keys envelope.KeyProvider
+			err  error
+		)
+		switch {
+		case masterKeyFile != "" && kmsDir != "":
+			return errors.New("--master-key-file and --kms-dir are mutually exclusive")
+		case masterKeyFile != "":
+			keys, err = envelope.NewFileKeyring(masterKeyFile)
+		case kmsDir != "":
+			keys, err = envelope.NewLocalKMS(kmsDir)
+		default:
+			return errors.New("either --master-key-file or --kms-dir is required")
+		}
+		if err != nil {
+			return err
+		}
+
+		version, err := keys.Rotate()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("master key version %s created\n", version)
+
+		if skipReencryption {
+			fmt.Println("the registry re-encrypts the stored secrets in the background")
+			return nil
+		}
+
+		if dsn == "" {
+			return errors.New("--dsn is required to re-encrypt the stored secrets")
+		}
+
+		db, err := sql.Open(bome.MySQL, dsn)
+		if err != nil {
+			return err
+		}
+
+		stores, err := openSealedStores(db, envelope.NewSealer(keys))
+		if err != nil {
+			return err
+		}
+
+		// the secrets stay readable during the re-encryption, as every master key version can decrypt
+		for _, store := range stores {
+			total := 0
+			for {
+				count, err := store.reencrypt(reencryptBatch)
+				if err != nil {
+					return fmt.Errorf("re-encryption of %s stopped after %d entries: %s", store.name, total, err)
+				}
+				if count == 0 {
+					break
+				}
+				total += count
+				fmt.Printf("%d %s re-encrypted\n", total, store.name)
+			}
+			fmt.Printf("done, %d %s re-encrypted with version %s\n", total, store.name, version)
+		}
+		return nil
+	},
+}
+
+// sealedStore is a store holding values sealed with the master keys
+type sealedStore struct {
+	name      string
+	reencrypt func(limit int) (int, error)
+}
+
+// openSealedStores returns the stores holding sealed values, in the order they are re-encrypted
+func openSealedStores(db *sql.DB, sealer *envelope.Sealer) ([]sealedStore, error) {
+	apps, err := dao.NewSealedSQLApplicationsDB(db, bome.MySQL, "applications", sealer)
+	if err != nil {
+		return nil, err
+	}
+
+	clients, err := dao.NewSealedSQLClientProfilesDB(db, bome.MySQL, dao.ClientProfilesTable, sealer)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeys, err := dao.NewSealedSQLSigningKeysDB(db, bome.MySQL, "signing_keys", sealer)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := dao.NewSealedSQLSettingsDB(db, bome.MySQL, "registry_settings", sealer)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := dao.NewSealedSQLWebhooksDB(db, bome.MySQL, "webhooks", sealer)
+	if err != nil {
+		return nil, err
+	}
+
+	return []sealedStore{
+		{name: "applications", reencrypt: apps.Reencrypt},
+		{name: "client profiles", reencrypt: clients.Reencrypt},
+		{name: "signing keys", reencrypt: signingKeys.Reencrypt},
+		{name: "settings", reencrypt: settings.Reencrypt},
+		{name: "webhook secrets", reencrypt: webhooks.Reencrypt},
+	}, nil
+}
+
+// commandSealer returns the sealer of the master keys given on the command line
+func commandSealer(db *sql.DB) (*envelope.Sealer, error) {
+	sealer, err := server.NewSealer(masterKeyFile, kmsDir)
+	if err != nil || sealer != nil {
+		return sealer, err
+	}
+
+	sealed, err := dao.HasSealedValues(db, bome.MySQL, "applications", dao.ClientProfilesTable, "webhooks")
+	if err != nil {
+		return nil, err
+	}
+	if sealed {
+		return nil, errors.New("the database holds encrypted values, --master-key-file or --kms-dir is required")
+	}
+	return nil, nil
+}
+
+// openApplicationsDB returns the applications store, sealing the secrets with the master keys given on the command line
+func openApplicationsDB(db *sql.DB) (dao.ApplicationsDB, error) {
+	sealer, err := commandSealer(db)
+	if err != nil {
+		return nil, err
+	}
+	return dao.NewSealedSQLApplicationsDB(db, bome.MySQL, "applications", sealer)
+}
+
+// openClientProfilesDB returns the client profiles store, sealed with the master keys given on the command line
+func openClientProfilesDB(db *sql.DB) (dao.ClientProfilesDB, error) {
+	sealer, err := commandSealer(db)
+	if err != nil {
+		return nil, err
+	}
+	return dao.NewSealedSQLClientProfilesDB(db, bome.MySQL, dao.ClientProfilesTable, sealer)
+}
+
+func init() {
+	keysCMD.AddCommand(initKeysCMD)
+	keysCMD.AddCommand(rotateKeysCMD)
+
+	flags := initKeysCMD.Flags()
+	flags.StringVar(&masterKeyFile, "master-key-file", "", "Master keys file to create")
+	flags.StringVar(&kmsDir, "kms-dir", "", "Directory of the local KMS master keys to create")
+
+	flags = rotateKeysCMD.PersistentFlags()
+	flags.StringVar(&dsn, "dsn", "", "DSN for the MySQL database. Required unless --no-reencrypt is set")
+	flags.StringVar(&masterKeyFile, "master-key-file", "", "Master keys file")
+	flags.StringVar(&kmsDir, "kms-dir", "", "Directory of the local KMS master keys")
+	flags.IntVar(&reencryptBatch, "batch-size", 100, "Number of entries re-encrypted per transaction batch")
+	flags.BoolVar(&skipReencryption, "no-reencrypt", false, "Only create the new version and let the running registry re-encrypt the secrets in the background")
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/server"
+	"github.com/omecodes/bome"
+	"github.com/spf13/cobra"
+)
+
+var (
+	webhookURL      string
+	webhookEvents   []string
+	webhookSecret   string
+	webhookIDList   []string
+	receiverAddress string
+)
+
+var webhooksCMD = &cobra.Command{
+	Use:   "webhooks",
+	Short: "Manage application lifecycle webhooks",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+func openWebhooksDB() dao.WebhooksDB {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	sealer, err := commandSealer(db)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	webhooksDB, err := dao.NewSealedSQLWebhooksDB(db, bome.MySQL, "webhooks", sealer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return webhooksDB
+}
+
+var addWebhookCMD = &cobra.Command{
+	Use:   "add",
+	Short: "Subscribe a URL to application events",
+	Run: func(cmd *cobra.Command, args []string) {
+		subscription, err := server.NewWebhookSubscription(webhookURL, webhookEvents, webhookSecret, "cli")
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		err = openWebhooksDB().SaveSubscription(subscription)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		encoded, _ := json.MarshalIndent(subscription, "", "  ")
+		fmt.Println(string(encoded))
+	},
+}
+
+var listWebhooksCMD = &cobra.Command{
+	Use:   "list",
+	Short: "List webhook subscriptions and dead letters",
+	Run: func(cmd *cobra.Command, args []string) {
+		webhooksDB := openWebhooksDB()
+
+		subscriptions, err := webhooksDB.GetSubscriptions()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, subscription := range subscriptions {
+			fmt.Printf("%s\t%s\t%v\n", subscription.ID, subscription.URL, subscription.EventTypes)
+		}
+
+		deadLetters, err := webhooksDB.GetDeadLetters()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(deadLetters) > 0 {
+			fmt.Println("\ndead letters:")
+		}
+		for _, delivery := range deadLetters {
+			fmt.Printf("%s\t%d attempts\t%s\n", delivery.ID, delivery.Attempts, delivery.LastError)
+		}
+	},
+}
+
+var delWebhookCMD = &cobra.Command{
+	Use:   "del",
+	Short: "Delete webhook subscriptions by ID",
+	Run: func(cmd *cobra.Command, args []string) {
+		webhooksDB := openWebhooksDB()
+		for _, id := range webhookIDList {
+			err := webhooksDB.DeleteSubscription(id)
+			if err != nil {
+				log.Printf("could not delete webhook %s: %s\n", id, err)
+			}
+		}
+	},
+}
+
+var redeliverWebhookCMD = &cobra.Command{
+	Use:   "redeliver",
+	Short: "Queue dead letters again by delivery ID",
+	Run: func(cmd *cobra.Command, args []string) {
+		webhooksDB := openWebhooksDB()
+		for _, id := range webhookIDList {
+			err := server.Redeliver(webhooksDB, id)
+			if err != nil {
+				log.Printf("could not redeliver %s: %s\n", id, err)
+			}
+		}
+	},
+}
+
+var receiveWebhooksCMD = &cobra.Command{
+	Use:   "receive",
+	Short: "Run a local HTTP receiver that prints the webhook payloads and checks their signature",
+	Run: func(cmd *cobra.Command, args []string) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			verified := server.VerifyWebhookSignature(
+				webhookSecret,
+				r.Header.Get(server.WebhookTimestampHeader),
+				body,
+				r.Header.Get(server.WebhookSignatureHeader),
+			)
+			fmt.Fprintf(os.Stdout, "%s %s verified=%t\n%s\n", r.Header.Get(server.WebhookDeliveryHeader), r.Header.Get(server.WebhookEventHeader), verified, body)
+
+			if !verified {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		log.Printf("receiving webhooks on %s\n", receiverAddress)
+		log.Fatalln(http.ListenAndServe(receiverAddress, handler))
+	},
+}
+
+func init() {
+	webhooksCMD.AddCommand(addWebhookCMD, listWebhooksCMD, delWebhookCMD, redeliverWebhookCMD, receiveWebhooksCMD)
+	for _, c := range []*cobra.Command{addWebhookCMD, listWebhooksCMD, delWebhookCMD, redeliverWebhookCMD} {
+		flags := c.PersistentFlags()
+		flags.StringVar(&dsn, "dsn", "", "DSN for the MySQL database (required)")
+		flags.StringVar(&masterKeyFile, "master-key-file", "", "Master keys file the webhook secrets are encrypted with")
+		flags.StringVar(&kmsDir, "kms-dir", "", "Directory of the local KMS master keys the webhook secrets are encrypted with")
+		_ = cobra.MarkFlagRequired(flags, "dsn")
+	}
+
+	flags := addWebhookCMD.PersistentFlags()
+	flags.StringVar(&webhookURL, "url", "", "URL the events are posted to (required)")
+	flags.StringArrayVar(&webhookEvents, "events", nil, "Event types to subscribe to. All types when empty")
+	flags.StringVar(&webhookSecret, "secret", "", "Payload signing secret. Generated when empty")
+	_ = cobra.MarkFlagRequired(flags, "url")
+
+	for _, c := range []*cobra.Command{delWebhookCMD, redeliverWebhookCMD} {
+		flags = c.PersistentFlags()
+		flags.StringArrayVar(&webhookIDList, "ids", nil, "IDs to process")
+		_ = cobra.MarkFlagRequired(flags, "ids")
+	}
+
+	flags = receiveWebhooksCMD.PersistentFlags()
+	flags.StringVar(&receiverAddress, "address", "localhost:8089", "Address to listen on")
+	flags.StringVar(&webhookSecret, "secret", "", "Subscription secret used to verify the payloads (required)")
+	_ = cobra.MarkFlagRequired(flags, "secret")
+}
@@ -2,14 +2,18 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/omecodes/service"
+	"io/ioutil"
 	"path/filepath"
+	"time"
 
 	"github.com/omecodes/app-registry/server"
 	"github.com/omecodes/common/env/app"
 	"github.com/omecodes/common/utils/log"
 	"github.com/omecodes/common/utils/prompt"
+	"github.com/omecodes/libome"
 	"github.com/omecodes/libome/ports"
 	"github.com/spf13/cobra"
 )
@@ -20,16 +24,32 @@ const (
 )
 
 var (
-	domain       string
-	ip, eip      string
-	hPort        int
-	gPort        int
-	acm          bool
-	dsn          string
-	regAddr      string
-	certFilename string
-	keyFilename  string
-	cmd          *cobra.Command
+	domain              string
+	ip, eip             string
+	hPort               int
+	gPort               int
+	acm                 bool
+	devMode             bool
+	standalone          bool
+	infoFilename        string
+	dsn                 string
+	regAddr             string
+	certFilename        string
+	keyFilename         string
+	clientCA            string
+	clientAuth          string
+	registration        string
+	iaTokens            []string
+	admins              []string
+	issuer              string
+	maxAppsPerUser      int
+	identityTTL         time.Duration
+	keyRotation         time.Duration
+	shutdownWait        time.Duration
+	appsDir             string
+	appsAdoptPrivileged bool
+	appsSync            time.Duration
+	cmd                 *cobra.Command
 )
 
 var application *app.App
@@ -40,34 +60,78 @@ func init() {
 		app.WithRunCommandFunc(start),
 	)
 	cmd = application.GetCommand()
-	cmd.AddCommand(appCMD)
-
-	flags := application.StartCommand().PersistentFlags()
-	flags.StringVar(&domain, "dn", "", "Domain name (required)")
-	flags.StringVar(&ip, "ip", "", "IP address to bind server to (required)")
-	flags.StringVar(&eip, "eip", "", "External IP address")
-	flags.BoolVar(&acm, "acme", false, "Use acme auto-cert loading")
-	flags.IntVar(&hPort, "http", ports.OmeHTTP, "HTTP server port")
-	flags.IntVar(&gPort, "grpc", ports.Ome, "gRPC server port")
-	flags.StringVar(&dsn, "dsn", "", "DSN for the MySQL database (required)")
-	flags.StringVar(&regAddr, "registry", "", "Address to start registry server on")
-	flags.StringVar(&certFilename, "cert", "", "Certificate file path")
-	flags.StringVar(&keyFilename, "key", "", "Key file path")
-
-	_ = cobra.MarkFlagRequired(flags, "domain")
-	_ = cobra.MarkFlagRequired(flags, "ip")
-	_ = cobra.MarkFlagRequired(flags, "dsn")
+	cmd.AddCommand(appCMD, webhooksCMD, configCMD, keysCMD)
+
+	registerStartFlags(application.StartCommand().PersistentFlags())
 }
 
 func start() {
-	if dsn == "" || ip == "" || domain == "" {
-		sc := application.StartCommand()
-		_ = sc.Help()
-		return
+	err := loadConfig(application.StartCommand().PersistentFlags())
+	if err != nil {
+		log.Fatal("could not load configuration", log.Err(err))
 	}
 
-	var boxParams service.Params
 	log.File = filepath.Join(application.DataDir(), "run.log")
+
+	var box *service.Box
+	if !standalone && !devMode {
+		box, err = createBox()
+		if err != nil {
+			log.Fatal("could not create box", log.Err(err))
+		}
+	}
+
+	var info *ome.Info
+	if infoFilename != "" {
+		info, err = loadInfo(infoFilename)
+		if err != nil {
+			log.Fatal("could not load info file", log.Err(err))
+		}
+	}
+
+	s := server.New(&server.Config{
+		Bootstrap:              &bootstrapSettings,
+		BootstrapSecretFile:    bootstrapSecretFile,
+		PrintBootstrapSecret:   printBootstrapSecret,
+		MasterKeyFile:          masterKeyFile,
+		KMSDir:                 kmsDir,
+		DevMode:                devMode,
+		Standalone:             standalone,
+		Domain:                 domain,
+		BindIP:                 ip,
+		Info:                   info,
+		TLSCertFilename:        certFilename,
+		TLSKeyFilename:         keyFilename,
+		TLSClientCAFilename:    clientCA,
+		TLSClientAuth:          clientAuth,
+		Application:            application,
+		DSN:                    dsn,
+		Box:                    box,
+		WebPort:                hPort,
+		GRPCPort:               gPort,
+		RegistrationPolicy:     registration,
+		InitialAccessTokens:    iaTokens,
+		Admins:                 admins,
+		MaxApplicationsPerUser: maxAppsPerUser,
+		Issuer:                 issuer,
+		IdentityTokenTTL:       identityTTL,
+		KeyRotationPeriod:      keyRotation,
+		ShutdownTimeout:        shutdownWait,
+		AppsDir:                appsDir,
+		AppsAdoptPrivileged:    appsAdoptPrivileged,
+		AppsSyncInterval:       appsSync,
+	})
+	err = s.Start()
+	if err != nil {
+		log.Fatal("could not start server", log.Err(err))
+	}
+
+	defer s.Stop()
+	<-prompt.QuitSignal()
+}
+
+func createBox() (*service.Box, error) {
+	var boxParams service.Params
 	boxParams.Dir = application.DataDir()
 	boxParams.Name = "Ome"
 	boxParams.Acme = acm
@@ -81,28 +145,17 @@ func start() {
 		regAddr = fmt.Sprintf("%s:%d", boxParams.Domain, ports.Discover)
 	}
 	boxParams.RegistryAddress = regAddr
+	return service.CreateBox(context.Background(), &boxParams)
+}
 
-	box, err := service.CreateBox(context.Background(), &boxParams)
+// loadInfo reads the JSON document served by /info in standalone mode
+func loadInfo(filename string) (*ome.Info, error) {
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Fatal("could not create box", log.Err(err))
+		return nil, err
 	}
-
-	s := server.New(&server.Config{
-		TLSCertFilename: certFilename,
-		TLSKeyFilename:  keyFilename,
-		Application:     application,
-		DSN:             dsn,
-		Box:             box,
-		WebPort:         hPort,
-		GRPCPort:        gPort,
-	})
-	err = s.Start()
-	if err != nil {
-		log.Fatal("could not start server", log.Err(err))
-	}
-
-	defer s.Stop()
-	<-prompt.QuitSignal()
+	info := new(ome.Info)
+	return info, json.Unmarshal(data, info)
 }
 
 func Execute() error {
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/omecodes/app-registry/bootstrap"
+	"github.com/omecodes/app-registry/server"
+	"github.com/omecodes/libome/ports"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix prefixes the environment variables of the start settings
+const EnvPrefix = "APP_REGISTRY_"
+
+var (
+	bootstrapSettings    bootstrap.Settings
+	bootstrapSecretFile  string
+	printBootstrapSecret bool
+)
+
+var (
+	configFilename    string
+	dsnFilename       string
+	iaTokensFilename  string
+	redactedSettings  = map[string]bool{"dsn": true, "initial-access-token": true}
+	dsnPasswordFormat = regexp.MustCompile(`^([^:@/]*):[^@]*@`)
+)
+
+var configCMD = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the start configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		_ = cmd.Help()
+	},
+}
+
+var printConfigCMD = &cobra.Command{
+	Use:          "print",
+	Short:        "Print the effective start configuration, secrets redacted",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.LocalFlags()
+		err := loadConfig(flags)
+		if err != nil {
+			return err
+		}
+
+		var settings yaml.MapSlice
+		flags.VisitAll(func(f *pflag.Flag) {
+			if f.Name == "config" || f.Name == "help" {
+				return
+			}
+
+			var value interface{} = f.Value.String()
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				value = sv.GetSlice()
+			}
+			if redactedSettings[f.Name] {
+				value = redact(f.Name, value)
+			}
+			settings = append(settings, yaml.MapItem{Key: f.Name, Value: value})
+		})
+
+		encoded, err := yaml.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(encoded)
+		return err
+	},
+}
+
+func redact(name string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case []string:
+		redacted := make([]string, len(v))
+		for i := range v {
+			redacted[i] = "[redacted]"
+		}
+		return redacted
+
+	case string:
+		if name == "dsn" {
+			return dsnPasswordFormat.ReplaceAllString(v, "$1:[redacted]@")
+		}
+		if v == "" {
+			return v
+		}
+		return "[redacted]"
+
+	default:
+		return value
+	}
+}
+
+// registerStartFlags defines the start settings in flags
+func registerStartFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&configFilename, "config", "", "YAML configuration file. Its keys are the flag names")
+	flags.StringVar(&domain, "dn", "", "Domain name (required)")
+	flags.StringVar(&ip, "ip", "", "IP address to bind server to (required)")
+	flags.StringVar(&eip, "eip", "", "External IP address")
+	flags.BoolVar(&acm, "acme", false, "Use acme auto-cert loading")
+	flags.BoolVar(&standalone, "standalone", false, "Run without service box and discovery registry, serving the gRPC API and the gateway with cert and key")
+	flags.StringVar(&infoFilename, "info-file", "", "JSON document served by /info in standalone mode")
+	flags.BoolVar(&devMode, "dev", false, "Development mode: plain HTTP and insecure gRPC on loopback, no CA, well-known root credentials. Never use in production")
+	flags.IntVar(&hPort, "http", ports.OmeHTTP, "HTTP server port")
+	flags.IntVar(&gPort, "grpc", ports.Ome, "gRPC server port")
+	flags.StringVar(&dsn, "dsn", "", "DSN for the MySQL database (required)")
+	flags.StringVar(&dsnFilename, "dsn-file", "", "File containing the DSN for the MySQL database")
+	flags.StringVar(&regAddr, "registry", "", "Address to start registry server on")
+	flags.StringVar(&certFilename, "cert", "", "Certificate file path")
+	flags.StringVar(&keyFilename, "key", "", "Key file path")
+	flags.StringVar(&clientCA, "client-ca", "", "CA certificates file the HTTPS gateway verifies client certificates with")
+	flags.StringVar(&clientAuth, "client-auth", server.ClientAuthNone, "HTTPS gateway client certificate policy: none, optional or require")
+	flags.StringVar(&registration, "registration", server.RegistrationPolicyClosed, "Dynamic client registration policy: closed, token or open")
+	flags.StringArrayVar(&iaTokens, "initial-access-token", nil, "Initial access token accepted by the dynamic client registration endpoint")
+	flags.StringVar(&iaTokensFilename, "initial-access-tokens-file", "", "File containing initial access tokens, one per line")
+	flags.StringArrayVar(&admins, "admin", nil, "User allowed to review the application promotion requests")
+	flags.IntVar(&maxAppsPerUser, "max-apps-per-user", server.DefaultMaxApplicationsPerUser, "Number of applications a user can register. A negative value removes the limit")
+	flags.StringVar(&issuer, "issuer", "", "Issuer of the application identity tokens. Defaults to https://<dn>")
+	flags.DurationVar(&identityTTL, "identity-token-ttl", server.DefaultIdentityTokenTTL, "Lifetime of the application identity tokens")
+	flags.DurationVar(&keyRotation, "signing-key-rotation", server.DefaultKeyRotationPeriod, "Rotation period of the identity tokens signing key")
+	flags.DurationVar(&shutdownWait, "shutdown-timeout", server.DefaultShutdownTimeout, "Time given to in-flight requests to complete on shutdown")
+	flags.StringVar(&masterKeyFile, "master-key-file", "", "Master keys file the stored secrets are encrypted with. Created by the keys init command")
+	flags.StringVar(&kmsDir, "kms-dir", "", "Directory of the local KMS master keys the stored secrets are encrypted with. Created by the keys init command")
+	registerBootstrapFlags(flags, "bootstrap-")
+	flags.StringVar(&bootstrapSecretFile, "bootstrap-secret-file", "", "File the bootstrap application secret is written to. Defaults to <data dir>/<id>-app.secret")
+	flags.BoolVar(&printBootstrapSecret, "bootstrap-print-secret", false, "Print the bootstrap application secret once instead of writing it to a file")
+	flags.StringVar(&appsDir, "apps-dir", "", "Directory of application definitions the registry is kept in sync with")
+	flags.BoolVar(&appsAdoptPrivileged, "apps-adopt-privileged", false, "Allow the applications directory to adopt existing Root, Master and reserved applications")
+	flags.DurationVar(&appsSync, "apps-sync-interval", server.DefaultAppsSyncInterval, "Interval between two synchronizations with the applications directory")
+}
+
+// registerBootstrapFlags defines the settings of the master application created on the first start
+func registerBootstrapFlags(flags *pflag.FlagSet, prefix string) {
+	flags.StringVar(&bootstrapSettings.ApplicationID, prefix+"app-id", bootstrap.DefaultApplicationID, "ID of the master application")
+	flags.StringVar(&bootstrapSettings.Label, prefix+"label", "Accounts Application", "Label of the master application")
+	flags.StringVar(&bootstrapSettings.Description, prefix+"description", "Accounts management application", "Description of the master application")
+	flags.StringVar(&bootstrapSettings.CallbackURL, prefix+"callback-url", "", "OAuth callback URL of the master application")
+	flags.StringVar(&bootstrapSettings.Website, prefix+"website", "", "Website of the master application")
+	flags.StringVar(&bootstrapSettings.LogoURL, prefix+"logo-url", "", "Logo URL of the master application")
+}
+
+// loadConfig completes the unset flags from the environment then the configuration file, and validates the result
+func loadConfig(flags *pflag.FlagSet) error {
+	if !flags.Changed("config") {
+		configFilename = os.Getenv(envName("config"))
+	}
+
+	fileSettings := map[string]interface{}{}
+	if configFilename != "" {
+		data, err := ioutil.ReadFile(configFilename)
+		if err != nil {
+			return fmt.Errorf("could not read configuration file: %s", err)
+		}
+
+		err = yaml.Unmarshal(data, &fileSettings)
+		if err != nil {
+			return fmt.Errorf("could not parse configuration file %s: %s", configFilename, err)
+		}
+
+		for name := range fileSettings {
+			if name == "config" || flags.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %q", configFilename, name)
+			}
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "config" {
+			return
+		}
+
+		if value, found := os.LookupEnv(envName(f.Name)); found {
+			var values []string
+			if _, isSlice := f.Value.(pflag.SliceValue); isSlice {
+				values = strings.Split(value, ",")
+			} else {
+				values = []string{value}
+			}
+			err = setFlag(flags, f.Name, values, "environment variable "+envName(f.Name))
+			return
+		}
+
+		if value, found := fileSettings[f.Name]; found {
+			var values []string
+			if list, isList := value.([]interface{}); isList {
+				for _, item := range list {
+					values = append(values, fmt.Sprint(item))
+				}
+			} else {
+				values = []string{fmt.Sprint(value)}
+			}
+			err = setFlag(flags, f.Name, values, configFilename)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if dsnFilename != "" {
+		if dsn != "" {
+			return fmt.Errorf("dsn and dsn-file are mutually exclusive")
+		}
+		data, err := ioutil.ReadFile(dsnFilename)
+		if err != nil {
+			return fmt.Errorf("could not read dsn-file: %s", err)
+		}
+		dsn = strings.TrimSpace(string(data))
+	}
+
+	if iaTokensFilename != "" {
+		data, err := ioutil.ReadFile(iaTokensFilename)
+		if err != nil {
+			return fmt.Errorf("could not read initial-access-tokens-file: %s", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if token := strings.TrimSpace(line); token != "" {
+				iaTokens = append(iaTokens, token)
+			}
+		}
+	}
+	return validateConfig()
+}
+
+func envName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+func setFlag(flags *pflag.FlagSet, name string, values []string, source string) error {
+	for _, value := range values {
+		err := flags.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("%s: bad %s value %q: %s", source, name, value, err)
+		}
+	}
+	return nil
+}
+
+// validateConfig checks the start settings and reports all the problems at once
+func validateConfig() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	switch {
+	case devMode:
+		check(!acm && certFilename == "", "dev mode serves plain HTTP and does not accept acme, cert or key")
+		check(clientAuth == server.ClientAuthNone, "dev mode does not verify client certificates")
+	case standalone:
+		check(domain != "", "dn is required")
+		check(ip != "", "ip is required")
+		check(!acm, "standalone mode does not support acme")
+		check(certFilename != "", "standalone mode requires cert and key")
+	default:
+		check(domain != "", "dn is required")
+		check(ip != "", "ip is required")
+		check(infoFilename == "", "info-file is only used in standalone mode")
+	}
+	check(dsn != "", "dsn or dsn-file is required")
+	check(ip == "" || net.ParseIP(ip) != nil, "ip %q is not an IP address", ip)
+	check(eip == "" || net.ParseIP(eip) != nil, "eip %q is not an IP address", eip)
+	check(hPort > 0 && hPort < 65536, "http port %d is out of range", hPort)
+	check(gPort > 0 && gPort < 65536, "grpc port %d is out of range", gPort)
+	check(hPort != gPort, "http and grpc ports must differ")
+
+	check((certFilename == "") == (keyFilename == ""), "cert and key must be set together")
+	check(!acm || certFilename == "", "cert and acme are mutually exclusive")
+	switch clientAuth {
+	case server.ClientAuthNone:
+	case server.ClientAuthOptional, server.ClientAuthRequire:
+		check(certFilename != "", "client-auth %s requires cert and key", clientAuth)
+		check(clientCA != "", "client-auth %s requires client-ca", clientAuth)
+	default:
+		check(false, "client-auth %q is not none, optional or require", clientAuth)
+	}
+	for _, filename := range []string{certFilename, keyFilename, clientCA, appsDir, infoFilename} {
+		if filename != "" {
+			_, err := os.Stat(filename)
+			check(err == nil, "%s", err)
+		}
+	}
+
+	switch registration {
+	case server.RegistrationPolicyClosed, server.RegistrationPolicyOpen:
+	case server.RegistrationPolicyToken:
+		check(len(iaTokens) > 0, "registration policy token requires at least one initial access token")
+	default:
+		check(false, "registration policy %q is not closed, token or open", registration)
+	}
+
+	if _, err := bootstrapSettings.Application(); err != nil {
+		check(false, "bootstrap %s", err)
+	}
+	check(masterKeyFile == "" || kmsDir == "", "master-key-file and kms-dir are mutually exclusive")
+	check(bootstrapSecretFile == "" || !printBootstrapSecret, "bootstrap-secret-file and bootstrap-print-secret are mutually exclusive")
+
+	check(identityTTL > 0, "identity-token-ttl must be positive")
+	check(keyRotation > 0, "signing-key-rotation must be positive")
+	check(shutdownWait > 0, "shutdown-timeout must be positive")
+	check(appsSync > 0, "apps-sync-interval must be positive")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func init() {
+	registerStartFlags(printConfigCMD.Flags())
+	configCMD.AddCommand(printConfigCMD)
+}
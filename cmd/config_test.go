@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// newStartFlags defines the start flags on a new set, which resets the settings to their defaults, and parses args
+func newStartFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("start", pflag.ContinueOnError)
+	registerStartFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	if err := ioutil.WriteFile(certFile, []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	valid := []string{"--dn=registry.example.com", "--ip=10.0.0.1", "--dsn=user:pass@tcp(db)/registry"}
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "valid", args: valid},
+		{name: "dev mode", args: []string{"--dev", "--dsn=user:pass@tcp(db)/registry"}},
+		{name: "missing required", args: nil, want: []string{"dn is required", "ip is required", "dsn or dsn-file is required"}},
+		{name: "bad ip", args: append(valid, "--ip=registry", "--eip=10.0.0"), want: []string{`ip "registry"`, `eip "10.0.0"`}},
+		{name: "same ports", args: append(valid, "--http=8080", "--grpc=8080"), want: []string{"ports must differ"}},
+		{name: "port out of range", args: append(valid, "--http=70000"), want: []string{"http port 70000 is out of range"}},
+		{name: "cert without key", args: append(valid, "--cert="+certFile), want: []string{"cert and key must be set together"}},
+		{name: "missing cert file", args: append(valid, "--cert="+filepath.Join(dir, "none.pem"), "--key="+certFile), want: []string{"none.pem"}},
+		{name: "cert and acme", args: append(valid, "--acme", "--cert="+certFile, "--key="+certFile), want: []string{"mutually exclusive"}},
+		{name: "dev mode with cert", args: []string{"--dev", "--dsn=x", "--cert=" + certFile, "--key=" + certFile}, want: []string{"dev mode serves plain HTTP"}},
+		{name: "standalone without cert", args: append(valid, "--standalone"), want: []string{"standalone mode requires cert and key"}},
+		{name: "info file outside standalone", args: append(valid, "--info-file="+certFile), want: []string{"info-file is only used in standalone mode"}},
+		{name: "client auth without ca", args: append(valid, "--client-auth=require", "--cert="+certFile, "--key="+certFile), want: []string{"requires client-ca"}},
+		{name: "unknown client auth", args: append(valid, "--client-auth=always"), want: []string{`client-auth "always"`}},
+		{name: "token registration without token", args: append(valid, "--registration=token"), want: []string{"at least one initial access token"}},
+		{name: "token registration", args: append(valid, "--registration=token", "--initial-access-token=secret")},
+		{name: "unknown registration", args: append(valid, "--registration=invite"), want: []string{`registration policy "invite"`}},
+		{name: "master key file and kms dir", args: append(valid, "--master-key-file=keys", "--kms-dir=kms"), want: []string{"master-key-file and kms-dir"}},
+		{name: "secret file and print", args: append(valid, "--bootstrap-secret-file=s", "--bootstrap-print-secret"), want: []string{"bootstrap-secret-file and bootstrap-print-secret"}},
+		{name: "zero durations", args: append(valid, "--identity-token-ttl=0", "--shutdown-timeout=0"), want: []string{"identity-token-ttl", "shutdown-timeout"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newStartFlags(t, tt.args...)
+
+			err := validateConfig()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("validateConfig() = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("validateConfig() succeeded")
+			}
+			for _, problem := range tt.want {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("%q is not reported in:\n%s", problem, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	configFile := writeFile("config.yml", "dn: file.example.com\nip: 10.0.0.1\ndsn: file-dsn\nhttp: 8081\nadmin:\n  - alice\n  - bob\n")
+	dsnFile := writeFile("dsn", "secret-dsn\n")
+	tokensFile := writeFile("tokens", "token1\n\n  token2  \n")
+	unknownFile := writeFile("unknown.yml", "dn: file.example.com\nport: 80\n")
+	badFile := writeFile("bad.yml", "dn: [\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+		check   func(t *testing.T)
+	}{
+		{
+			name: "configuration file",
+			args: []string{"--config=" + configFile},
+			check: func(t *testing.T) {
+				if domain != "file.example.com" || hPort != 8081 || dsn != "file-dsn" {
+					t.Errorf("loaded dn=%s http=%d dsn=%s", domain, hPort, dsn)
+				}
+				if strings.Join(admins, ",") != "alice,bob" {
+					t.Errorf("admins are %v", admins)
+				}
+			},
+		},
+		{
+			name: "flags before environment before file",
+			args: []string{"--dn=flag.example.com"},
+			env:  map[string]string{"CONFIG": configFile, "DN": "env.example.com", "HTTP": "8082", "ADMIN": "carol,dave"},
+			check: func(t *testing.T) {
+				if domain != "flag.example.com" || hPort != 8082 || ip != "10.0.0.1" {
+					t.Errorf("loaded dn=%s http=%d ip=%s", domain, hPort, ip)
+				}
+				if strings.Join(admins, ",") != "carol,dave" {
+					t.Errorf("admins are %v", admins)
+				}
+			},
+		},
+		{
+			name: "secrets files",
+			args: []string{"--dn=registry.example.com", "--ip=10.0.0.1", "--dsn-file=" + dsnFile, "--initial-access-tokens-file=" + tokensFile, "--initial-access-token=token0"},
+			check: func(t *testing.T) {
+				if dsn != "secret-dsn" {
+					t.Errorf("dsn is %q", dsn)
+				}
+				if strings.Join(iaTokens, ",") != "token0,token1,token2" {
+					t.Errorf("initial access tokens are %v", iaTokens)
+				}
+			},
+		},
+		{name: "dsn and dsn file", args: []string{"--config=" + configFile, "--dsn-file=" + dsnFile}, wantErr: "mutually exclusive"},
+		{name: "missing configuration file", args: []string{"--config=" + filepath.Join(dir, "none.yml")}, wantErr: "could not read configuration file"},
+		{name: "malformed configuration file", args: []string{"--config=" + badFile}, wantErr: "could not parse configuration file"},
+		{name: "unknown setting", args: []string{"--config=" + unknownFile}, wantErr: `unknown setting "port"`},
+		{name: "bad environment value", args: []string{"--config=" + configFile}, env: map[string]string{"GRPC": "many"}, wantErr: "environment variable APP_REGISTRY_GRPC: bad grpc value"},
+		{name: "invalid result", args: []string{"--config=" + configFile, "--ip=localhost"}, wantErr: "invalid configuration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				setEnv(t, EnvPrefix+name, value)
+			}
+			flags := newStartFlags(t, tt.args...)
+
+			err := loadConfig(flags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() = %v", err)
+			}
+			tt.check(t)
+		})
+	}
+}
+
+// setEnv sets an environment variable for the duration of the test
+func setEnv(t *testing.T, name, value string) {
+	previous, found := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if found {
+			_ = os.Setenv(name, previous)
+		} else {
+			_ = os.Unsetenv(name)
+		}
+	})
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "dsn", value: "user:pass@tcp(db)/registry", want: "user:[redacted]@tcp(db)/registry"},
+		{name: "master-key-file", value: "", want: ""},
+		{name: "initial-access-token", value: "token", want: "[redacted]"},
+		{name: "http", value: 80, want: 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.name, tt.value); got != tt.want {
+				t.Errorf("redact() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
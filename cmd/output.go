@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/omecodes/libome"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+)
+
+var outputFormat string
+
+// printApplications writes the applications in w in the requested format. Secrets are never printed
+func printApplications(w io.Writer, apps []*ome.Application) error {
+	var list []*ome.Application
+	for _, a := range apps {
+		a = proto.Clone(a).(*ome.Application)
+		a.Secret = ""
+		list = append(list, a)
+	}
+
+	switch outputFormat {
+	case formatTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "ID\tLEVEL\tACTIVATED\tLABEL\tCREATED BY\tCATEGORIES")
+		for _, a := range list {
+			var label, createdBy string
+			if a.Info != nil {
+				label = a.Info.Label
+				createdBy = a.Info.CreatedBy
+			}
+
+			var categories []string
+			for _, c := range a.Categories {
+				categories = append(categories, c.String())
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%s\t%s\n", a.Id, a.Level, a.Activated, label, createdBy, strings.Join(categories, ","))
+		}
+		return tw.Flush()
+
+	case formatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+
+	case formatYAML:
+		encoded, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+
+		var o interface{}
+		err = yaml.Unmarshal(encoded, &o)
+		if err != nil {
+			return err
+		}
+
+		encoded, err = yaml.Marshal(o)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(encoded)
+		return err
+
+	default:
+		return fmt.Errorf("unsupported output format %q", outputFormat)
+	}
+}
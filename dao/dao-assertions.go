@@ -0,0 +1,59 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/omecodes/bome"
+)
+
+// usedAssertion is the record of a client assertion identifier. ExpiresAt is a unix time in milliseconds
+type usedAssertion struct {
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// UsedAssertionsDB records the identifiers of the client assertions already used
+type UsedAssertionsDB interface {
+	// Use records id until expiry. It returns false if id is already recorded and has not expired
+	Use(id string, expiry time.Time) (bool, error)
+}
+
+type sqlUsedAssertionsDB struct {
+	assertions *bome.JSONMap
+}
+
+func (s *sqlUsedAssertionsDB) Use(id string, expiry time.Time) (bool, error) {
+	err := s.assertions.Client().SQLExec(
+		"delete from $table$ where json_extract(value, '$.expires_at')<?;",
+		time.Now().UnixNano()/int64(time.Millisecond),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	encoded, err := json.Marshal(&usedAssertion{ExpiresAt: expiry.UnixNano() / int64(time.Millisecond)})
+	if err != nil {
+		return false, err
+	}
+
+	// the insert fails when the identifier is already recorded
+	err = s.assertions.Client().SQLExec("insert into $table$ values (?, ?);", id, string(encoded))
+	if err == nil {
+		return true, nil
+	}
+
+	used, containsErr := s.assertions.Contains(id)
+	if containsErr != nil || !used {
+		return false, err
+	}
+	return false, nil
+}
+
+func NewSQLUsedAssertionsDB(db *sql.DB, dialect string, tableName string) (UsedAssertionsDB, error) {
+	assertions, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlUsedAssertionsDB{assertions: assertions}, nil
+}
@@ -0,0 +1,150 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/libome"
+)
+
+type ClientProfilesDB interface {
+	SaveClientProfile(profile *oauth.ClientProfile) error
+	GetClientProfile(applicationID string) (*oauth.ClientProfile, error)
+	DeleteClientProfile(applicationID string) error
+	// Reencrypt seals again at most limit profiles stored in plaintext or sealed with an old master key version
+	Reencrypt(limit int) (int, error)
+}
+
+type sqlClientProfilesDB struct {
+	profiles *bome.JSONMap
+	sealer   *envelope.Sealer
+}
+
+func (s *sqlClientProfilesDB) encode(profile *oauth.ClientProfile) (string, error) {
+	return encodeClientProfile(s.sealer, profile)
+}
+
+// encodeClientProfile returns the stored form of profile. With a sealer, the whole profile is sealed and stored as a JSON string
+func encodeClientProfile(sealer *envelope.Sealer, profile *oauth.ClientProfile) (string, error) {
+	encoded, err := json.Marshal(profile)
+	if err != nil || sealer == nil {
+		return string(encoded), err
+	}
+
+	sealed, err := sealer.Seal(string(encoded), profile.ApplicationID)
+	if err != nil {
+		return "", err
+	}
+	encoded, err = json.Marshal(sealed)
+	return string(encoded), err
+}
+
+func (s *sqlClientProfilesDB) decode(applicationID string, value string) (*oauth.ClientProfile, error) {
+	var sealed string
+	if json.Unmarshal([]byte(value), &sealed) == nil {
+		if s.sealer == nil {
+			return nil, fmt.Errorf("dao: the client profile of %s is encrypted and no master key is configured", applicationID)
+		}
+
+		opened, err := s.sealer.Open(sealed, applicationID)
+		if err != nil {
+			return nil, fmt.Errorf("dao: client profile of %s: %s", applicationID, err)
+		}
+		value = opened
+	}
+
+	profile := &oauth.ClientProfile{}
+	err := json.Unmarshal([]byte(value), profile)
+	return profile, err
+}
+
+func (s *sqlClientProfilesDB) SaveClientProfile(profile *oauth.ClientProfile) error {
+	encoded, err := s.encode(profile)
+	if err != nil {
+		return err
+	}
+	return s.profiles.Save(&bome.MapEntry{
+		Key:   profile.ApplicationID,
+		Value: encoded,
+	})
+}
+
+func (s *sqlClientProfilesDB) GetClientProfile(applicationID string) (*oauth.ClientProfile, error) {
+	value, err := s.profiles.Get(applicationID)
+	if err != nil {
+		return nil, err
+	}
+	return s.decode(applicationID, value)
+}
+
+func (s *sqlClientProfilesDB) DeleteClientProfile(applicationID string) error {
+	return s.profiles.Delete(applicationID)
+}
+
+func (s *sqlApplicationsDB) SaveClientApplication(application *ome.Application, profile *oauth.ClientProfile) error {
+	encoded, err := s.encode(application)
+	if err != nil {
+		return err
+	}
+	encodedProfile, err := encodeClientProfile(s.sealer, profile)
+	if err != nil {
+		return err
+	}
+
+	return s.change(application.Id, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		err := tx.Save(&bome.MapEntry{
+			Key:   application.Id,
+			Value: string(encoded),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.clients.ContinueTransaction(tx.TX()).Save(&bome.MapEntry{
+			Key:   profile.ApplicationID,
+			Value: encodedProfile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return changeEvent(previous, application), nil
+	})
+}
+
+func (s *sqlClientProfilesDB) Reencrypt(limit int) (int, error) {
+	if s.sealer == nil {
+		return 0, nil
+	}
+
+	stale := func(value string) (bool, error) {
+		var sealed string
+		if json.Unmarshal([]byte(value), &sealed) != nil {
+			return true, nil
+		}
+		return s.sealer.Stale(sealed)
+	}
+	return resealEntries(s.profiles, limit, stale, func(applicationID string, value string) (string, error) {
+		profile, err := s.decode(applicationID, value)
+		if err != nil {
+			return "", err
+		}
+		return s.encode(profile)
+	})
+}
+
+func NewSQLClientProfilesDB(db *sql.DB, dialect string, tableName string) (ClientProfilesDB, error) {
+	return NewSealedSQLClientProfilesDB(db, dialect, tableName, nil)
+}
+
+// NewSealedSQLClientProfilesDB stores the client profiles sealed by sealer
+func NewSealedSQLClientProfilesDB(db *sql.DB, dialect string, tableName string, sealer *envelope.Sealer) (ClientProfilesDB, error) {
+	profiles, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlClientProfilesDB{profiles: profiles, sealer: sealer}, nil
+}
@@ -0,0 +1,108 @@
+package dao
+
+import (
+	"encoding/json"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/libome"
+)
+
+// Application event types
+const (
+	EventCreate     = "create"
+	EventUpdate     = "update"
+	EventDelete     = "delete"
+	EventActivate   = "activate"
+	EventDeactivate = "deactivate"
+
+	EventPromotionRequested = "promotion_requested"
+	EventPromotionApproved  = "promotion_approved"
+	EventPromotionRejected  = "promotion_rejected"
+)
+
+// ApplicationEvent is a change of the applications store. Revisions follow the commit order
+type ApplicationEvent struct {
+	Revision      int64            `json:"revision"`
+	Type          string           `json:"type"`
+	ApplicationID string           `json:"application_id"`
+	Application   *ome.Application `json:"application,omitempty"`
+	Promotion     *Promotion       `json:"promotion,omitempty"`
+	Time          int64            `json:"time"`
+}
+
+// CreatedBy returns the user that created the application the event relates to
+func (e *ApplicationEvent) CreatedBy() string {
+	if e.Application == nil || e.Application.Info == nil {
+		return ""
+	}
+	return e.Application.Info.CreatedBy
+}
+
+// changeEvent returns the event describing the replacement of previous by current. Application secrets are never recorded
+func changeEvent(previous *ome.Application, current *ome.Application) *ApplicationEvent {
+	e := &ApplicationEvent{Type: EventUpdate}
+	if current == nil {
+		e.Type = EventDelete
+		e.ApplicationID = previous.Id
+		current = previous
+	} else {
+		e.ApplicationID = current.Id
+		if previous == nil {
+			e.Type = EventCreate
+		} else if previous.Activated != current.Activated {
+			e.Type = EventDeactivate
+			if current.Activated {
+				e.Type = EventActivate
+			}
+		}
+	}
+
+	e.Application = proto.Clone(current).(*ome.Application)
+	e.Application.Secret = ""
+	return e
+}
+
+// lockEvents prevents concurrent transactions from appending events until tx ends
+func lockEvents(tx *bome.JSONListTx) error {
+	_, err := tx.Client().SQLQueryFirst("select ind from $table$ order by ind desc limit 1 for update;", bome.IntScanner)
+	if err != nil && !bome.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func appendEvent(tx *bome.JSONListTx, e *ApplicationEvent) error {
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return tx.Append(&bome.ListEntry{Value: string(encoded)})
+}
+
+func (s *sqlApplicationsDB) GetEvents(afterRevision int64, limit int) ([]*ApplicationEvent, error) {
+	entries, err := s.events.RangeFromIndex(afterRevision, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*ApplicationEvent
+	for _, entry := range entries {
+		e := new(ApplicationEvent)
+		err = json.Unmarshal([]byte(entry.Value), e)
+		if err != nil {
+			return nil, err
+		}
+		e.Revision = entry.Index
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *sqlApplicationsDB) LatestRevision() (int64, error) {
+	o, err := s.events.Client().SQLQueryFirst("select coalesce(max(ind), 0) from $table$;", bome.IntScanner)
+	if err != nil {
+		return 0, err
+	}
+	return o.(int64), nil
+}
@@ -0,0 +1,29 @@
+package dao
+
+import "github.com/omecodes/libome"
+
+// WithLevel accepts the applications of the given level
+func WithLevel(level ome.ApplicationLevel) ApplicationFilter {
+	return func(a *ome.Application) bool {
+		return a.Level == level
+	}
+}
+
+// WithCategory accepts the applications listed in the given category
+func WithCategory(category ome.Category) ApplicationFilter {
+	return func(a *ome.Application) bool {
+		for _, c := range a.Categories {
+			if c == category {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// WithActivated accepts the applications whose activation state is activated
+func WithActivated(activated bool) ApplicationFilter {
+	return func(a *ome.Application) bool {
+		return a.Activated == activated
+	}
+}
@@ -0,0 +1,167 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/common/errors"
+)
+
+// Application key types
+const (
+	KeyTypeJWK  = "jwk"
+	KeyTypeX509 = "x509"
+)
+
+// ApplicationKey is a JWK or a client certificate fingerprint of an application
+type ApplicationKey struct {
+	ID            string     `json:"id"`
+	ApplicationID string     `json:"application_id"`
+	Type          string     `json:"type"`
+	JWK           *oauth.JWK `json:"jwk,omitempty"`
+	Fingerprint   string     `json:"fingerprint,omitempty"`
+	CreatedAt     int64      `json:"created_at"`
+}
+
+type KeysDB interface {
+	// SaveKey saves key. It returns errors.Duplicate when the certificate fingerprint of key is already bound
+	SaveKey(key *ApplicationKey) error
+	GetKeys(applicationID string) ([]*ApplicationKey, error)
+	GetKey(applicationID string, keyID string) (*ApplicationKey, error)
+	DeleteKey(applicationID string, keyID string) error
+	DeleteApplicationKeys(applicationID string) error
+	FindByFingerprint(fingerprint string) (*ApplicationKey, error)
+}
+
+type sqlKeysDB struct {
+	keys     *bome.DoubleMap
+	bindings *bome.JSONMap
+}
+
+func (s *sqlKeysDB) SaveKey(key *ApplicationKey) error {
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.bindings.BeginTransaction()
+	if err != nil {
+		return err
+	}
+
+	if key.Type == KeyTypeX509 {
+		// the primary key of the bindings table makes the insertion fail when the fingerprint is already bound
+		err = tx.Client().SQLExec("insert into $table$ values (?, ?);", key.Fingerprint, string(encoded))
+		if err != nil {
+			_ = tx.Rollback()
+			if bound, findErr := s.bindings.Contains(key.Fingerprint); findErr == nil && bound {
+				return errors.Duplicate
+			}
+			return err
+		}
+	}
+
+	err = s.keys.ContinueTransaction(tx.TX()).Save(&bome.DoubleMapEntry{
+		FirstKey:  key.ApplicationID,
+		SecondKey: key.ID,
+		Value:     string(encoded),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlKeysDB) GetKeys(applicationID string) ([]*ApplicationKey, error) {
+	cursor, err := s.keys.GetForFirst(applicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var keys []*ApplicationKey
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		key := new(ApplicationKey)
+		err = json.Unmarshal([]byte(o.(*bome.MapEntry).Value), key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *sqlKeysDB) GetKey(applicationID string, keyID string) (*ApplicationKey, error) {
+	value, err := s.keys.Get(applicationID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	key := new(ApplicationKey)
+	err = json.Unmarshal([]byte(value), key)
+	return key, err
+}
+
+func (s *sqlKeysDB) DeleteKey(applicationID string, keyID string) error {
+	key, err := s.GetKey(applicationID, keyID)
+	if err != nil {
+		return err
+	}
+
+	if key.Type == KeyTypeX509 {
+		err = s.bindings.Delete(key.Fingerprint)
+		if err != nil {
+			return err
+		}
+	}
+	return s.keys.Delete(applicationID, keyID)
+}
+
+func (s *sqlKeysDB) DeleteApplicationKeys(applicationID string) error {
+	keys, err := s.GetKeys(applicationID)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if key.Type == KeyTypeX509 {
+			err = s.bindings.Delete(key.Fingerprint)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return s.keys.DeleteAllMatchingFirstKey(applicationID)
+}
+
+func (s *sqlKeysDB) FindByFingerprint(fingerprint string) (*ApplicationKey, error) {
+	value, err := s.bindings.Get(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	key := new(ApplicationKey)
+	err = json.Unmarshal([]byte(value), key)
+	return key, err
+}
+
+func NewSQLKeysDB(db *sql.DB, dialect string, tableName string) (KeysDB, error) {
+	keys, err := bome.NewDoubleMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := bome.NewJSONMap(db, dialect, tableName+"_bindings")
+	if err != nil {
+		return nil, err
+	}
+	return &sqlKeysDB{keys: keys, bindings: bindings}, nil
+}
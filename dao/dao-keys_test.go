@@ -0,0 +1,40 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/omecodes/bome"
+	"github.com/omecodes/common/errors"
+)
+
+func TestSaveKeyFingerprintBinding(t *testing.T) {
+	keys, err := NewSQLKeysDB(openTestDB(t), bome.SQLite3, "application_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := &ApplicationKey{ID: "k1", ApplicationID: "a", Type: KeyTypeX509, Fingerprint: "f"}
+	if err := keys.SaveKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &ApplicationKey{ID: "k2", ApplicationID: "b", Type: KeyTypeX509, Fingerprint: "f"}
+	if err := keys.SaveKey(other); err != errors.Duplicate {
+		t.Fatalf("SaveKey() = %v, want errors.Duplicate", err)
+	}
+
+	bound, err := keys.FindByFingerprint("f")
+	if err != nil || bound.ApplicationID != "a" {
+		t.Errorf("fingerprint bound to %+v, %v", bound, err)
+	}
+	if _, err := keys.GetKey("b", "k2"); !errors.IsNotFound(err) {
+		t.Errorf("the rejected key was saved: %v", err)
+	}
+
+	if err := keys.DeleteKey("a", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.SaveKey(other); err != nil {
+		t.Errorf("SaveKey() = %v once the fingerprint is released", err)
+	}
+}
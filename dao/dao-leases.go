@@ -0,0 +1,78 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/omecodes/bome"
+)
+
+// Lease is a named lock held by a registry instance until it expires
+type Lease struct {
+	Name   string `json:"-"`
+	Holder string `json:"holder"`
+	// ExpiresAt is a unix time in milliseconds
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+type LeasesDB interface {
+	// Acquire takes or renews the lease for holder. It returns false if another holder has a lease that has not expired
+	Acquire(name string, holder string, ttl time.Duration) (bool, error)
+	Release(name string, holder string) error
+	GetLease(name string) (*Lease, error)
+}
+
+type sqlLeasesDB struct {
+	leases *bome.JSONMap
+}
+
+func (s *sqlLeasesDB) Acquire(name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl).UnixNano() / int64(time.Millisecond)
+	encoded, err := json.Marshal(&Lease{Holder: holder, ExpiresAt: expiresAt})
+	if err != nil {
+		return false, err
+	}
+
+	// the insert fails when the lease row already exists, the conditional update then takes it over if it is ours or expired
+	_ = s.leases.Client().SQLExec("insert into $table$ values (?, ?);", name, string(encoded))
+	err = s.leases.Client().SQLExec(
+		"update $table$ set value=? where name=? and (json_unquote(json_extract(value, '$.holder'))=? or json_extract(value, '$.expires_at')<?);",
+		string(encoded), name, holder, now.UnixNano()/int64(time.Millisecond),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	lease, err := s.GetLease(name)
+	if err != nil {
+		return false, err
+	}
+	return lease.Holder == holder && lease.ExpiresAt == expiresAt, nil
+}
+
+func (s *sqlLeasesDB) Release(name string, holder string) error {
+	return s.leases.Client().SQLExec(
+		"delete from $table$ where name=? and json_unquote(json_extract(value, '$.holder'))=?;",
+		name, holder,
+	)
+}
+
+func (s *sqlLeasesDB) GetLease(name string) (*Lease, error) {
+	value, err := s.leases.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	lease := &Lease{Name: name}
+	err = json.Unmarshal([]byte(value), lease)
+	return lease, err
+}
+
+func NewSQLLeasesDB(db *sql.DB, dialect string, tableName string) (LeasesDB, error) {
+	leases, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlLeasesDB{leases: leases}, nil
+}
@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/omecodes/bome"
+)
+
+// ManagedApplication records that an application is defined in declarative files and owned by the reconciliation
+type ManagedApplication struct {
+	ID string `json:"id"`
+	// Source is the path of the file the application is defined in
+	Source    string `json:"source"`
+	AppliedAt int64  `json:"applied_at"`
+}
+
+type ManagedApplicationsDB interface {
+	SaveManaged(m *ManagedApplication) error
+	GetManaged() ([]*ManagedApplication, error)
+	DeleteManaged(id string) error
+}
+
+type sqlManagedApplicationsDB struct {
+	managed *bome.JSONMap
+}
+
+func (s *sqlManagedApplicationsDB) SaveManaged(m *ManagedApplication) error {
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.managed.Save(&bome.MapEntry{
+		Key:   m.ID,
+		Value: string(encoded),
+	})
+}
+
+func (s *sqlManagedApplicationsDB) GetManaged() ([]*ManagedApplication, error) {
+	cursor, err := s.managed.List()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var list []*ManagedApplication
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		m := new(ManagedApplication)
+		err = json.Unmarshal([]byte(o.(*bome.MapEntry).Value), m)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+func (s *sqlManagedApplicationsDB) DeleteManaged(id string) error {
+	return s.managed.Delete(id)
+}
+
+func NewSQLManagedApplicationsDB(db *sql.DB, dialect string, tableName string) (ManagedApplicationsDB, error) {
+	managed, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlManagedApplicationsDB{managed: managed}, nil
+}
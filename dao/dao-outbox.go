@@ -0,0 +1,66 @@
+package dao
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/omecodes/bome"
+)
+
+// OutboxMessage is an application event waiting to be published, written in the transaction of its change
+type OutboxMessage struct {
+	ID          int64             `json:"-"`
+	Event       *ApplicationEvent `json:"event"`
+	Delivered   bool              `json:"delivered"`
+	DeliveredAt int64             `json:"delivered_at,omitempty"`
+}
+
+func appendOutbox(tx *bome.JSONListTx, e *ApplicationEvent) error {
+	encoded, err := json.Marshal(&OutboxMessage{Event: e})
+	if err != nil {
+		return err
+	}
+	return tx.Append(&bome.ListEntry{Value: string(encoded)})
+}
+
+func (s *sqlApplicationsDB) PendingOutbox(limit int) ([]*OutboxMessage, error) {
+	cursor, err := s.outbox.Client().SQLQuery("select * from $table$ where json_extract(value, '$.delivered')=false order by ind limit ?;", bome.ListEntryScanner, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var messages []*OutboxMessage
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := o.(*bome.ListEntry)
+		message := new(OutboxMessage)
+		err = json.Unmarshal([]byte(entry.Value), message)
+		if err != nil {
+			return nil, err
+		}
+		message.ID = entry.Index
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func (s *sqlApplicationsDB) MarkDelivered(id int64) error {
+	return s.outbox.Client().SQLExec(
+		"update $table$ set value=json_set(value, '$.delivered', true, '$.delivered_at', ?) where ind=?;",
+		time.Now().Unix(), id,
+	)
+}
+
+func (s *sqlApplicationsDB) PruneOutbox(deliveredBefore int64) error {
+	return s.outbox.Client().SQLExec(
+		"delete from $table$ where json_extract(value, '$.delivered')=true and json_extract(value, '$.delivered_at')<?;",
+		deliveredBefore,
+	)
+}
@@ -0,0 +1,199 @@
+package dao
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+)
+
+// Promotion request statuses
+const (
+	PromotionPending  = "pending"
+	PromotionApproved = "approved"
+	PromotionRejected = "rejected"
+)
+
+// Promotion is the request of an application owner to raise the application level. It is recorded with its review
+type Promotion struct {
+	ID            string               `json:"id"`
+	ApplicationID string               `json:"application_id"`
+	FromLevel     ome.ApplicationLevel `json:"from_level"`
+	Level         ome.ApplicationLevel `json:"level"`
+	Justification string               `json:"justification"`
+	RequestedBy   string               `json:"requested_by"`
+	RequestedAt   int64                `json:"requested_at"`
+	Status        string               `json:"status"`
+	ReviewedBy    string               `json:"reviewed_by,omitempty"`
+	ReviewComment string               `json:"review_comment,omitempty"`
+	ReviewedAt    int64                `json:"reviewed_at,omitempty"`
+}
+
+// promotionEvent returns the event notifying a promotion change of application. Application secrets are never recorded
+func promotionEvent(eventType string, application *ome.Application, promotion *Promotion) *ApplicationEvent {
+	e := &ApplicationEvent{
+		Type:          eventType,
+		ApplicationID: application.Id,
+		Application:   proto.Clone(application).(*ome.Application),
+		Promotion:     promotion,
+	}
+	e.Application.Secret = ""
+	return e
+}
+
+func (s *sqlApplicationsDB) RequestPromotion(promotion *Promotion) error {
+	return s.change(promotion.ApplicationID, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		if previous == nil {
+			return nil, errors.NotFound
+		}
+
+		promotionsTx := s.promotions.ContinueTransaction(tx.TX())
+		cursor, err := promotionsTx.Search(bome.And(
+			bome.JsonAtEq("$.application_id", bome.StringExpr(promotion.ApplicationID)),
+			bome.JsonAtEq("$.status", bome.StringExpr(PromotionPending)),
+		), bome.MapEntryScanner)
+		if err != nil {
+			return nil, err
+		}
+		pending := cursor.HasNext()
+		_ = cursor.Close()
+		if pending {
+			return nil, errors.Duplicate
+		}
+
+		promotion.FromLevel = previous.Level
+		promotion.Status = PromotionPending
+		promotion.RequestedAt = time.Now().Unix()
+		err = savePromotion(promotionsTx, promotion)
+		if err != nil {
+			return nil, err
+		}
+		return promotionEvent(EventPromotionRequested, previous, promotion), nil
+	})
+}
+
+func (s *sqlApplicationsDB) GetPromotion(id string) (*Promotion, error) {
+	value, err := s.promotions.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	promotion := new(Promotion)
+	err = json.Unmarshal([]byte(value), promotion)
+	return promotion, err
+}
+
+func (s *sqlApplicationsDB) ListPromotions(status string) ([]*Promotion, error) {
+	var (
+		cursor bome.Cursor
+		err    error
+	)
+	if status == "" {
+		cursor, err = s.promotions.List()
+	} else {
+		cursor, err = s.promotions.Search(bome.JsonAtEq("$.status", bome.StringExpr(status)), bome.MapEntryScanner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var promotions []*Promotion
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		promotion := new(Promotion)
+		err = json.Unmarshal([]byte(o.(*bome.MapEntry).Value), promotion)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, nil
+}
+
+func (s *sqlApplicationsDB) ReviewPromotion(id string, approved bool, reviewer string, comment string) (*Promotion, error) {
+	promotion, err := s.GetPromotion(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.change(promotion.ApplicationID, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		promotionsTx := s.promotions.ContinueTransaction(tx.TX())
+		value, err := promotionsTx.Get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		// the status is read again in the transaction, so that a request is only reviewed once
+		err = json.Unmarshal([]byte(value), promotion)
+		if err != nil {
+			return nil, err
+		}
+		if promotion.Status != PromotionPending {
+			return nil, errors.Duplicate
+		}
+
+		if previous == nil {
+			return nil, errors.NotFound
+		}
+
+		// the application level changed since the request, it can only be rejected
+		if approved && previous.Level != promotion.FromLevel {
+			return nil, errors.Duplicate
+		}
+
+		promotion.Status = PromotionRejected
+		if approved {
+			promotion.Status = PromotionApproved
+		}
+		promotion.ReviewedBy = reviewer
+		promotion.ReviewComment = comment
+		promotion.ReviewedAt = time.Now().Unix()
+		err = savePromotion(promotionsTx, promotion)
+		if err != nil {
+			return nil, err
+		}
+
+		if !approved {
+			return promotionEvent(EventPromotionRejected, previous, promotion), nil
+		}
+
+		previous.Level = promotion.Level
+		encoded, err := s.encode(previous)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Save(&bome.MapEntry{
+			Key:   previous.Id,
+			Value: string(encoded),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return promotionEvent(EventPromotionApproved, previous, promotion), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+func savePromotion(tx *bome.JSONMapTx, promotion *Promotion) error {
+	encoded, err := json.Marshal(promotion)
+	if err != nil {
+		return err
+	}
+	return tx.Save(&bome.MapEntry{
+		Key:   promotion.ID,
+		Value: string(encoded),
+	})
+}
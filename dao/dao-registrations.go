@@ -0,0 +1,107 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+)
+
+// ClientRegistration keeps track of an application registered through the dynamic registration endpoint
+type ClientRegistration struct {
+	ApplicationID string `json:"application_id"`
+	TokenHash     string `json:"token_hash"`
+	IssuedAt      int64  `json:"issued_at"`
+}
+
+type RegistrationsDB interface {
+	SaveRegistration(registration *ClientRegistration) error
+	GetRegistration(applicationID string) (*ClientRegistration, error)
+	DeleteRegistration(applicationID string) error
+}
+
+type sqlRegistrationsDB struct {
+	registrations *bome.JSONMap
+}
+
+func (s *sqlRegistrationsDB) SaveRegistration(registration *ClientRegistration) error {
+	encoded, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	return s.registrations.Save(&bome.MapEntry{
+		Key:   registration.ApplicationID,
+		Value: string(encoded),
+	})
+}
+
+func (s *sqlApplicationsDB) CreateRegisteredClient(application *ome.Application, profile *oauth.ClientProfile, registration *ClientRegistration) error {
+	encoded, err := s.encode(application)
+	if err != nil {
+		return err
+	}
+	encodedProfile, err := encodeClientProfile(s.sealer, profile)
+	if err != nil {
+		return err
+	}
+	encodedRegistration, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+
+	return s.change(application.Id, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		if previous != nil {
+			return nil, errors.Duplicate
+		}
+
+		err := tx.Save(&bome.MapEntry{
+			Key:   application.Id,
+			Value: string(encoded),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.clients.ContinueTransaction(tx.TX()).Save(&bome.MapEntry{
+			Key:   profile.ApplicationID,
+			Value: encodedProfile,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.registrations.ContinueTransaction(tx.TX()).Save(&bome.MapEntry{
+			Key:   registration.ApplicationID,
+			Value: string(encodedRegistration),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return changeEvent(nil, application), nil
+	})
+}
+
+func (s *sqlRegistrationsDB) GetRegistration(applicationID string) (*ClientRegistration, error) {
+	value, err := s.registrations.Get(applicationID)
+	if err != nil {
+		return nil, err
+	}
+	registration := &ClientRegistration{}
+	err = json.Unmarshal([]byte(value), registration)
+	return registration, err
+}
+
+func (s *sqlRegistrationsDB) DeleteRegistration(applicationID string) error {
+	return s.registrations.Delete(applicationID)
+}
+
+func NewSQLRegistrationsDB(db *sql.DB, dialect string, tableName string) (RegistrationsDB, error) {
+	registrations, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlRegistrationsDB{registrations: registrations}, nil
+}
@@ -0,0 +1,110 @@
+package dao
+
+import (
+	"database/sql"
+
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/bome"
+)
+
+// HasSealedValues tells whether one of the tables holds values sealed with a master key
+func HasSealedValues(db *sql.DB, dialect string, tables ...string) (bool, error) {
+	for _, table := range tables {
+		m, err := bome.NewJSONMap(db, dialect, table)
+		if err != nil {
+			return false, err
+		}
+
+		_, err = m.Client().SQLQueryFirst("select name from $table$ where value like ? limit 1;", bome.StringScanner, `%"`+envelope.SealedPrefix+`%`)
+		if err == nil {
+			return true, nil
+		}
+		if !bome.IsNotFound(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// resealEntries replaces at most limit stale values of m with the value returned by reseal, each under its row lock
+func resealEntries(m *bome.JSONMap, limit int, stale func(value string) (bool, error), reseal func(name string, value string) (string, error)) (int, error) {
+	cursor, err := m.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var names []string
+	for cursor.HasNext() && len(names) < limit {
+		o, err := cursor.Next()
+		if err != nil {
+			_ = cursor.Close()
+			return 0, err
+		}
+
+		entry := o.(*bome.MapEntry)
+		isStale, err := stale(entry.Value)
+		if err != nil {
+			_ = cursor.Close()
+			return 0, err
+		}
+		if isStale {
+			names = append(names, entry.Key)
+		}
+	}
+	_ = cursor.Close()
+
+	count := 0
+	for _, name := range names {
+		resealed, err := resealEntry(m, name, stale, reseal)
+		if err != nil {
+			return count, err
+		}
+		if resealed {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func resealEntry(m *bome.JSONMap, name string, stale func(value string) (bool, error), reseal func(name string, value string) (string, error)) (bool, error) {
+	tx, err := m.BeginTransaction()
+	if err != nil {
+		return false, err
+	}
+
+	// the no-op update takes the row lock on MySQL and the write lock on SQLite, that have no "select ... for update"
+	err = tx.Client().SQLExec("update $table$ set value=value where name=?;", name)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	o, err := tx.Client().SQLQueryFirst("select value from $table$ where name=?;", bome.StringScanner, name)
+	if err != nil {
+		_ = tx.Rollback()
+		if bome.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	value := o.(string)
+	isStale, err := stale(value)
+	if err != nil || !isStale {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	value, err = reseal(name, value)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	err = tx.Save(&bome.MapEntry{Key: name, Value: value})
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,105 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/bome"
+)
+
+// SettingsDB holds the values shared by all the registry instances
+type SettingsDB interface {
+	Get(name string) (string, error)
+	// SaveIfAbsent stores value unless a value is already stored under name, and returns the stored value
+	SaveIfAbsent(name string, value string) (string, error)
+	// Reencrypt seals again at most limit values stored in plaintext or sealed with an old master key version
+	Reencrypt(limit int) (int, error)
+}
+
+type sqlSettingsDB struct {
+	settings *bome.JSONMap
+	sealer   *envelope.Sealer
+}
+
+// encode returns the stored form of value, sealed when a sealer is configured
+func (s *sqlSettingsDB) encode(name string, value string) (string, error) {
+	if s.sealer != nil {
+		sealed, err := s.sealer.Seal(value, name)
+		if err != nil {
+			return "", err
+		}
+		value = sealed
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+func (s *sqlSettingsDB) decode(name string, encoded string) (string, error) {
+	var value string
+	err := json.Unmarshal([]byte(encoded), &value)
+	if err != nil || !envelope.IsSealed(value) {
+		return value, err
+	}
+
+	if s.sealer == nil {
+		return "", fmt.Errorf("dao: setting %s is encrypted and no master key is configured", name)
+	}
+	return s.sealer.Open(value, name)
+}
+
+func (s *sqlSettingsDB) Get(name string) (string, error) {
+	encoded, err := s.settings.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return s.decode(name, encoded)
+}
+
+func (s *sqlSettingsDB) SaveIfAbsent(name string, value string) (string, error) {
+	encoded, err := s.encode(name, value)
+	if err != nil {
+		return "", err
+	}
+
+	// a concurrent instance may have inserted the value first, the stored one is returned in that case
+	_ = s.settings.Client().SQLExec("insert into $table$ values (?, ?);", name, encoded)
+	return s.Get(name)
+}
+
+func (s *sqlSettingsDB) Reencrypt(limit int) (int, error) {
+	if s.sealer == nil {
+		return 0, nil
+	}
+
+	return resealEntries(s.settings, limit, s.stale, func(name string, encoded string) (string, error) {
+		value, err := s.decode(name, encoded)
+		if err != nil {
+			return "", err
+		}
+		return s.encode(name, value)
+	})
+}
+
+func (s *sqlSettingsDB) stale(encoded string) (bool, error) {
+	var value string
+	err := json.Unmarshal([]byte(encoded), &value)
+	if err != nil {
+		return false, err
+	}
+	return s.sealer.Stale(value)
+}
+
+func NewSQLSettingsDB(db *sql.DB, dialect string, tableName string) (SettingsDB, error) {
+	return NewSealedSQLSettingsDB(db, dialect, tableName, nil)
+}
+
+// NewSealedSQLSettingsDB stores the settings sealed by sealer
+func NewSealedSQLSettingsDB(db *sql.DB, dialect string, tableName string, sealer *envelope.Sealer) (SettingsDB, error) {
+	settings, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlSettingsDB{settings: settings, sealer: sealer}, nil
+}
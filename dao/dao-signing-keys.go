@@ -0,0 +1,138 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/bome"
+)
+
+// SigningKey is a registry key pair used to sign application identity tokens
+type SigningKey struct {
+	ID string `json:"id"`
+	// PrivateKey is the PEM encoded PKCS #8 private key
+	PrivateKey string `json:"private_key"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+type SigningKeysDB interface {
+	SaveSigningKey(key *SigningKey) error
+	GetSigningKeys() ([]*SigningKey, error)
+	DeleteSigningKey(id string) error
+	// Reencrypt seals again at most limit private keys stored in plaintext or sealed with an old master key version
+	Reencrypt(limit int) (int, error)
+}
+
+type sqlSigningKeysDB struct {
+	keys   *bome.JSONMap
+	sealer *envelope.Sealer
+}
+
+// encode returns the stored form of key, the private key is sealed when a sealer is configured
+func (s *sqlSigningKeysDB) encode(key *SigningKey) (string, error) {
+	if s.sealer != nil && !envelope.IsSealed(key.PrivateKey) {
+		sealed, err := s.sealer.Seal(key.PrivateKey, key.ID)
+		if err != nil {
+			return "", err
+		}
+		copied := *key
+		copied.PrivateKey = sealed
+		key = &copied
+	}
+	encoded, err := json.Marshal(key)
+	return string(encoded), err
+}
+
+func (s *sqlSigningKeysDB) decode(value string) (*SigningKey, error) {
+	key := new(SigningKey)
+	err := json.Unmarshal([]byte(value), key)
+	if err != nil || !envelope.IsSealed(key.PrivateKey) {
+		return key, err
+	}
+
+	if s.sealer == nil {
+		return nil, fmt.Errorf("dao: signing key %s is encrypted and no master key is configured", key.ID)
+	}
+	key.PrivateKey, err = s.sealer.Open(key.PrivateKey, key.ID)
+	if err != nil {
+		return nil, fmt.Errorf("dao: signing key %s: %s", key.ID, err)
+	}
+	return key, nil
+}
+
+func (s *sqlSigningKeysDB) SaveSigningKey(key *SigningKey) error {
+	encoded, err := s.encode(key)
+	if err != nil {
+		return err
+	}
+	return s.keys.Save(&bome.MapEntry{
+		Key:   key.ID,
+		Value: encoded,
+	})
+}
+
+func (s *sqlSigningKeysDB) GetSigningKeys() ([]*SigningKey, error) {
+	cursor, err := s.keys.List()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var keys []*SigningKey
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := s.decode(o.(*bome.MapEntry).Value)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *sqlSigningKeysDB) DeleteSigningKey(id string) error {
+	return s.keys.Delete(id)
+}
+
+func (s *sqlSigningKeysDB) Reencrypt(limit int) (int, error) {
+	if s.sealer == nil {
+		return 0, nil
+	}
+
+	stale := func(value string) (bool, error) {
+		key := new(SigningKey)
+		err := json.Unmarshal([]byte(value), key)
+		if err != nil {
+			return false, err
+		}
+		return s.sealer.Stale(key.PrivateKey)
+	}
+	return resealEntries(s.keys, limit, stale, func(_ string, value string) (string, error) {
+		key, err := s.decode(value)
+		if err != nil {
+			return "", err
+		}
+		return s.encode(key)
+	})
+}
+
+func NewSQLSigningKeysDB(db *sql.DB, dialect string, tableName string) (SigningKeysDB, error) {
+	return NewSealedSQLSigningKeysDB(db, dialect, tableName, nil)
+}
+
+// NewSealedSQLSigningKeysDB stores the signing private keys sealed by sealer
+func NewSealedSQLSigningKeysDB(db *sql.DB, dialect string, tableName string, sealer *envelope.Sealer) (SigningKeysDB, error) {
+	keys, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlSigningKeysDB{keys: keys, sealer: sealer}, nil
+}
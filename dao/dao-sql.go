@@ -3,12 +3,29 @@ package dao
 import (
 	"database/sql"
 	"encoding/json"
-	"github.com/golang/protobuf/jsonpb"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/app-registry/oauth"
 	"github.com/omecodes/bome"
+	"github.com/omecodes/common/errors"
 	"github.com/omecodes/libome"
 	"sync"
+	"time"
 )
 
+// Tables of the application records deleted with the application
+const (
+	ClientProfilesTable      = "oauth_clients"
+	RegistrationsTable       = "client_registrations"
+	KeysTable                = "application_keys"
+	VerificationsTable       = "application_verifications"
+	ManagedApplicationsTable = "managed_applications"
+)
+
+// ErrApplicationsLimit is returned by CreateUserApplication when the creator registered the maximum number of applications
+var ErrApplicationsLimit = errors.New("dao: applications limit reached")
+
 type appsMapCursor struct {
 	sync.Mutex
 	bome.Cursor
@@ -16,6 +33,7 @@ type appsMapCursor struct {
 	err             error
 	filters         []ApplicationFilter
 	parsingRequired bool
+	decode          func(string) (*ome.Application, error)
 }
 
 func (a *appsMapCursor) HasNext() bool {
@@ -71,7 +89,12 @@ func (a *appsMapCursor) parseNext() {
 		if a.err != nil {
 			return
 		}
-		a.next = o.(*ome.Application)
+
+		a.next, a.err = a.decode(o.(string))
+		if a.err != nil {
+			a.next = nil
+			return
+		}
 
 		for _, filter := range a.filters {
 			passed = filter(a.next)
@@ -95,6 +118,7 @@ type appsDictCursor struct {
 	err             error
 	filters         []ApplicationFilter
 	parsingRequired bool
+	decode          func(string) (*ome.Application, error)
 }
 
 func (a *appsDictCursor) HasNext() bool {
@@ -152,13 +176,11 @@ func (a *appsDictCursor) parseNext() {
 		}
 
 		entry := o.(*bome.MapEntry)
-		app := new(ome.Application)
-
-		a.err = jsonpb.UnmarshalString(entry.Value, app)
+		a.next, a.err = a.decode(entry.Value)
 		if a.err != nil {
+			a.next = nil
 			return
 		}
-		a.next = app
 
 		for _, filter := range a.filters {
 			passed = filter(a.next)
@@ -177,10 +199,46 @@ func (a *appsDictCursor) parseNext() {
 
 type ApplicationsDB interface {
 	SaveApplication(application *ome.Application) error
+	// CreateApplication saves application if no application has the same ID. Otherwise it returns errors.Duplicate
+	CreateApplication(application *ome.Application) error
+	// CreateUserApplication creates application like CreateApplication, unless its creator already registered limit
+	// applications, in which case it returns ErrApplicationsLimit. A limit lower than 1 disables the check
+	CreateUserApplication(application *ome.Application, limit int) error
+	// CreateRegisteredClient creates application like CreateApplication, together with its client profile and the record
+	// of its dynamic registration
+	CreateRegisteredClient(application *ome.Application, profile *oauth.ClientProfile, registration *ClientRegistration) error
+	// SaveClientApplication saves application and its client profile in the same transaction
+	SaveClientApplication(application *ome.Application, profile *oauth.ClientProfile) error
 	GetApplication(applicationID string) (*ome.Application, error)
 	ListApplicationForUser(user string, filters ...ApplicationFilter) (AppCursor, error)
 	ListAllApplications(filters ...ApplicationFilter) (AppCursor, error)
+	// DeleteApplication deletes the application together with its promotions, client profile, registration, keys,
+	// domain verification and management record
 	DeleteApplication(applicationID string) error
+
+	// GetEvents returns at most limit events with a revision greater than afterRevision, ordered by revision
+	GetEvents(afterRevision int64, limit int) ([]*ApplicationEvent, error)
+	LatestRevision() (int64, error)
+
+	// PendingOutbox returns at most limit undelivered outbox messages, in the order the changes were committed
+	PendingOutbox(limit int) ([]*OutboxMessage, error)
+	MarkDelivered(id int64) error
+	PruneOutbox(deliveredBefore int64) error
+
+	// Reencrypt seals again at most limit applications whose secret is in plaintext or sealed with an old master key
+	// version. It returns the number of re-encrypted applications. Re-encryptions do not produce events
+	Reencrypt(limit int) (int, error)
+
+	// RequestPromotion records a pending promotion request of an application. It fails with errors.Duplicate when
+	// a request of the application is already pending
+	RequestPromotion(promotion *Promotion) error
+	GetPromotion(id string) (*Promotion, error)
+	// ListPromotions returns the promotion requests with the given status, or all of them when status is empty
+	ListPromotions(status string) ([]*Promotion, error)
+	// ReviewPromotion records the review of a pending request. An approval sets the application level in the same transaction.
+	// It fails with errors.Duplicate when the request is no longer pending, or when approving a request made from a level
+	// the application no longer has
+	ReviewPromotion(id string, approved bool, reviewer string, comment string) (*Promotion, error)
 }
 
 type AppCursor interface {
@@ -190,7 +248,51 @@ type AppCursor interface {
 }
 
 type sqlApplicationsDB struct {
-	userApps *bome.JSONMap
+	userApps   *bome.JSONMap
+	events     *bome.JSONList
+	outbox     *bome.JSONList
+	promotions *bome.JSONMap
+	sealer     *envelope.Sealer
+
+	// tables of the records deleted with the applications
+	clients       *bome.JSONMap
+	registrations *bome.JSONMap
+	keys          *bome.DoubleMap
+	keyBindings   *bome.JSONMap
+	verifications *bome.JSONMap
+	managed       *bome.JSONMap
+}
+
+// encode returns the stored form of application, the secret is sealed when a sealer is configured
+func (s *sqlApplicationsDB) encode(application *ome.Application) ([]byte, error) {
+	if s.sealer != nil && application.Secret != "" && !envelope.IsSealed(application.Secret) {
+		sealed, err := s.sealer.Seal(application.Secret, application.Id)
+		if err != nil {
+			return nil, err
+		}
+		application = proto.Clone(application).(*ome.Application)
+		application.Secret = sealed
+	}
+	return json.Marshal(application)
+}
+
+func (s *sqlApplicationsDB) decode(value string) (*ome.Application, error) {
+	application := new(ome.Application)
+	err := json.Unmarshal([]byte(value), application)
+	if err != nil {
+		return nil, err
+	}
+
+	if envelope.IsSealed(application.Secret) {
+		if s.sealer == nil {
+			return nil, fmt.Errorf("dao: the secret of application %s is encrypted and no master key is configured", application.Id)
+		}
+		application.Secret, err = s.sealer.Open(application.Secret, application.Id)
+		if err != nil {
+			return nil, fmt.Errorf("dao: application %s: %s", application.Id, err)
+		}
+	}
+	return application, nil
 }
 
 func (s *sqlApplicationsDB) ListAllApplications(filters ...ApplicationFilter) (AppCursor, error) {
@@ -198,11 +300,11 @@ func (s *sqlApplicationsDB) ListAllApplications(filters ...ApplicationFilter) (A
 	if err != nil {
 		return nil, err
 	}
-	return &appsDictCursor{Cursor: cursor, filters: filters}, nil
+	return &appsDictCursor{Cursor: cursor, filters: filters, decode: s.decode}, nil
 }
 
 func (s *sqlApplicationsDB) SaveApplication(application *ome.Application) error {
-	encoded, err := json.Marshal(application)
+	encoded, err := s.encode(application)
 	if err != nil {
 		return err
 	}
@@ -210,7 +312,148 @@ func (s *sqlApplicationsDB) SaveApplication(application *ome.Application) error
 		Key:   application.Id,
 		Value: string(encoded),
 	}
-	return s.userApps.Save(entry)
+
+	return s.change(application.Id, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		err := tx.Save(entry)
+		if err != nil {
+			return nil, err
+		}
+		return changeEvent(previous, application), nil
+	})
+}
+
+func (s *sqlApplicationsDB) CreateApplication(application *ome.Application) error {
+	encoded, err := s.encode(application)
+	if err != nil {
+		return err
+	}
+
+	return s.change(application.Id, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		if previous != nil {
+			return nil, errors.Duplicate
+		}
+
+		err := tx.Save(&bome.MapEntry{
+			Key:   application.Id,
+			Value: string(encoded),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return changeEvent(nil, application), nil
+	})
+}
+
+func (s *sqlApplicationsDB) CreateUserApplication(application *ome.Application, limit int) error {
+	encoded, err := s.encode(application)
+	if err != nil {
+		return err
+	}
+
+	return s.change(application.Id, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		if previous != nil {
+			return nil, errors.Duplicate
+		}
+
+		// the events lock held by the transaction serializes the creations, so the count cannot be outdated
+		if limit > 0 {
+			count, err := countUserApplications(tx, application.GetInfo().GetCreatedBy())
+			if err != nil {
+				return nil, err
+			}
+			if count >= limit {
+				return nil, ErrApplicationsLimit
+			}
+		}
+
+		err := tx.Save(&bome.MapEntry{
+			Key:   application.Id,
+			Value: string(encoded),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return changeEvent(nil, application), nil
+	})
+}
+
+func countUserApplications(tx *bome.JSONMapTx, user string) (int, error) {
+	cursor, err := tx.Search(bome.JsonAtEq("$.info.created_by", bome.StringExpr(user)), bome.StringScanner)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	count := 0
+	for cursor.HasNext() {
+		_, err = cursor.Next()
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// change runs apply in a transaction together with the recording of the event it returns in the events log and the outbox
+func (s *sqlApplicationsDB) change(applicationID string, apply func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error)) error {
+	tx, err := s.userApps.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	eventsTx := s.events.ContinueTransaction(tx.TX())
+
+	err = lockEvents(eventsTx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	var previous *ome.Application
+	value, err := tx.Get(applicationID)
+	if err != nil && !bome.IsNotFound(err) {
+		_ = tx.Rollback()
+		return err
+	}
+	if err == nil {
+		previous = new(ome.Application)
+		err = json.Unmarshal([]byte(value), previous)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	e, err := apply(tx, previous)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if e != nil {
+		e.Time = time.Now().Unix()
+		err = appendEvent(eventsTx, e)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		revision, err := eventsTx.Client().SQLQueryFirst("select last_insert_id();", bome.IntScanner)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		e.Revision = revision.(int64)
+
+		err = appendOutbox(s.outbox.ContinueTransaction(tx.TX()), e)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (s *sqlApplicationsDB) GetApplication(applicationID string) (*ome.Application, error) {
@@ -218,9 +461,7 @@ func (s *sqlApplicationsDB) GetApplication(applicationID string) (*ome.Applicati
 	if err != nil {
 		return nil, err
 	}
-	a := &ome.Application{}
-	err = json.Unmarshal([]byte(value), a)
-	return a, err
+	return s.decode(value)
 }
 
 func (s *sqlApplicationsDB) ListApplicationForUser(user string, filters ...ApplicationFilter) (AppCursor, error) {
@@ -232,20 +473,226 @@ func (s *sqlApplicationsDB) ListApplicationForUser(user string, filters ...Appli
 		Cursor:          cursor,
 		filters:         filters,
 		parsingRequired: false,
+		decode:          s.decode,
 	}, nil
 }
 
 func (s *sqlApplicationsDB) DeleteApplication(applicationID string) error {
-	return s.userApps.Delete(applicationID)
+	return s.change(applicationID, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+		if previous == nil {
+			return nil, nil
+		}
+
+		err := tx.Delete(applicationID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.deleteRecords(tx.TX(), applicationID)
+		if err != nil {
+			return nil, err
+		}
+		return changeEvent(previous, nil), nil
+	})
+}
+
+// deleteRecords deletes the records of the application stored in the other tables
+func (s *sqlApplicationsDB) deleteRecords(tx *bome.TX, applicationID string) error {
+	promotionsTx := s.promotions.ContinueTransaction(tx)
+	cursor, err := promotionsTx.Search(bome.JsonAtEq("$.application_id", bome.StringExpr(applicationID)), bome.MapEntryScanner)
+	if err != nil {
+		return err
+	}
+	var promotions []string
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			_ = cursor.Close()
+			return err
+		}
+		promotions = append(promotions, o.(*bome.MapEntry).Key)
+	}
+	_ = cursor.Close()
+
+	for _, id := range promotions {
+		err = promotionsTx.Delete(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	keysTx := s.keys.ContinueTransaction(tx)
+	cursor, err = keysTx.GetForFirst(applicationID)
+	if err != nil {
+		return err
+	}
+	var fingerprints []string
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			_ = cursor.Close()
+			return err
+		}
+
+		key := new(ApplicationKey)
+		err = json.Unmarshal([]byte(o.(*bome.MapEntry).Value), key)
+		if err != nil {
+			_ = cursor.Close()
+			return err
+		}
+		if key.Type == KeyTypeX509 {
+			fingerprints = append(fingerprints, key.Fingerprint)
+		}
+	}
+	_ = cursor.Close()
+
+	bindingsTx := s.keyBindings.ContinueTransaction(tx)
+	for _, fingerprint := range fingerprints {
+		err = bindingsTx.Delete(fingerprint)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = keysTx.DeleteAllMatchingFirstKey(applicationID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range []*bome.JSONMap{s.clients, s.registrations, s.verifications, s.managed} {
+		err = m.ContinueTransaction(tx).Delete(applicationID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlApplicationsDB) Reencrypt(limit int) (int, error) {
+	if s.sealer == nil {
+		return 0, nil
+	}
+
+	cursor, err := s.userApps.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var stale []string
+	for cursor.HasNext() && len(stale) < limit {
+		o, err := cursor.Next()
+		if err != nil {
+			_ = cursor.Close()
+			return 0, err
+		}
+
+		entry := o.(*bome.MapEntry)
+		a := new(ome.Application)
+		err = json.Unmarshal([]byte(entry.Value), a)
+		if err != nil {
+			_ = cursor.Close()
+			return 0, err
+		}
+
+		isStale, err := s.sealer.Stale(a.Secret)
+		if err != nil {
+			_ = cursor.Close()
+			return 0, err
+		}
+		if isStale {
+			stale = append(stale, a.Id)
+		}
+	}
+	_ = cursor.Close()
+
+	count := 0
+	for _, id := range stale {
+		err = s.change(id, func(tx *bome.JSONMapTx, previous *ome.Application) (*ApplicationEvent, error) {
+			if previous == nil {
+				return nil, nil
+			}
+
+			isStale, err := s.sealer.Stale(previous.Secret)
+			if err != nil || !isStale {
+				return nil, err
+			}
+
+			previous.Secret, err = s.sealer.Open(previous.Secret, previous.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			encoded, err := s.encode(previous)
+			if err != nil {
+				return nil, err
+			}
+			count++
+			return nil, tx.Save(&bome.MapEntry{Key: previous.Id, Value: string(encoded)})
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
 }
 
 func NewSQLApplicationsDB(db *sql.DB, dialect string, tableName string) (ApplicationsDB, error) {
-	dao := new(sqlApplicationsDB)
+	return NewSealedSQLApplicationsDB(db, dialect, tableName, nil)
+}
+
+// NewSealedSQLApplicationsDB stores the application secrets sealed by sealer
+func NewSealedSQLApplicationsDB(db *sql.DB, dialect string, tableName string, sealer *envelope.Sealer) (ApplicationsDB, error) {
+	dao := &sqlApplicationsDB{sealer: sealer}
 	apps, err := bome.NewJSONMap(db, dialect, "applications")
 	if err != nil {
 		return nil, err
 	}
 	dao.userApps = apps
+
+	dao.events, err = bome.NewJSONList(db, dialect, tableName+"_events")
+	if err != nil {
+		return nil, err
+	}
+
+	dao.outbox, err = bome.NewJSONList(db, dialect, tableName+"_outbox")
+	if err != nil {
+		return nil, err
+	}
+
+	dao.promotions, err = bome.NewJSONMap(db, dialect, tableName+"_promotions")
+	if err != nil {
+		return nil, err
+	}
+
+	dao.clients, err = bome.NewJSONMap(db, dialect, ClientProfilesTable)
+	if err != nil {
+		return nil, err
+	}
+
+	dao.registrations, err = bome.NewJSONMap(db, dialect, RegistrationsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	dao.keys, err = bome.NewDoubleMap(db, dialect, KeysTable)
+	if err != nil {
+		return nil, err
+	}
+
+	dao.keyBindings, err = bome.NewJSONMap(db, dialect, KeysTable+"_bindings")
+	if err != nil {
+		return nil, err
+	}
+
+	dao.verifications, err = bome.NewJSONMap(db, dialect, VerificationsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	dao.managed, err = bome.NewJSONMap(db, dialect, ManagedApplicationsTable)
+	if err != nil {
+		return nil, err
+	}
 	return dao, nil
 }
 
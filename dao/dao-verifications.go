@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/omecodes/bome"
+)
+
+// Domain verification statuses
+const (
+	VerificationPending  = "pending"
+	VerificationVerified = "verified"
+	VerificationRevoked  = "revoked"
+)
+
+// Verification records the proof that the publisher of an application owns the domain of its website and callback URL
+type Verification struct {
+	ApplicationID string `json:"application_id"`
+	// Domain is the verified publisher identity
+	Domain    string `json:"domain"`
+	Token     string `json:"token,omitempty"`
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"created_at"`
+	// VerifiedAt is the time the token was last found on the domain
+	VerifiedAt   int64  `json:"verified_at,omitempty"`
+	RevokedAt    int64  `json:"revoked_at,omitempty"`
+	RevokeReason string `json:"revoke_reason,omitempty"`
+}
+
+type VerificationsDB interface {
+	SaveVerification(v *Verification) error
+	GetVerification(applicationID string) (*Verification, error)
+	DeleteVerification(applicationID string) error
+}
+
+type sqlVerificationsDB struct {
+	verifications *bome.JSONMap
+}
+
+func (s *sqlVerificationsDB) SaveVerification(v *Verification) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.verifications.Save(&bome.MapEntry{
+		Key:   v.ApplicationID,
+		Value: string(encoded),
+	})
+}
+
+func (s *sqlVerificationsDB) GetVerification(applicationID string) (*Verification, error) {
+	value, err := s.verifications.Get(applicationID)
+	if err != nil {
+		return nil, err
+	}
+	v := new(Verification)
+	err = json.Unmarshal([]byte(value), v)
+	return v, err
+}
+
+func (s *sqlVerificationsDB) DeleteVerification(applicationID string) error {
+	return s.verifications.Delete(applicationID)
+}
+
+func NewSQLVerificationsDB(db *sql.DB, dialect string, tableName string) (VerificationsDB, error) {
+	verifications, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlVerificationsDB{verifications: verifications}, nil
+}
@@ -0,0 +1,322 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/bome"
+)
+
+// WebhookSubscription registers a URL notified of the application events of the listed types
+type WebhookSubscription struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret,omitempty"`
+	CreatedBy  string   `json:"created_by"`
+	CreatedAt  int64    `json:"created_at"`
+}
+
+// Accepts tells whether the subscription is interested in events of the given type. No type means every type
+func (s *WebhookSubscription) Accepts(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the notification of an event to a subscription
+type WebhookDelivery struct {
+	ID             string            `json:"id"`
+	SubscriptionID string            `json:"subscription_id"`
+	Event          *ApplicationEvent `json:"event"`
+	Attempts       int               `json:"attempts"`
+	NextAttempt    int64             `json:"next_attempt"`
+	LastError      string            `json:"last_error,omitempty"`
+	CreatedAt      int64             `json:"created_at"`
+}
+
+type WebhooksDB interface {
+	SaveSubscription(subscription *WebhookSubscription) error
+	GetSubscription(id string) (*WebhookSubscription, error)
+	GetSubscriptions() ([]*WebhookSubscription, error)
+	DeleteSubscription(id string) error
+
+	// Enqueue adds the delivery to the queue unless a delivery with the same ID is queued or dead
+	Enqueue(delivery *WebhookDelivery) error
+	UpdateDelivery(delivery *WebhookDelivery) error
+	DueDeliveries(now int64, limit int) ([]*WebhookDelivery, error)
+	DeleteDelivery(id string) error
+
+	// Kill moves the delivery from the queue to the dead letters
+	Kill(delivery *WebhookDelivery) error
+	GetDeadLetters() ([]*WebhookDelivery, error)
+	GetDeadLetter(id string) (*WebhookDelivery, error)
+	// Revive moves the dead letter back to the queue
+	Revive(delivery *WebhookDelivery) error
+
+	// Reencrypt seals again at most limit secrets stored in plaintext or sealed with an old master key version
+	Reencrypt(limit int) (int, error)
+}
+
+type sqlWebhooksDB struct {
+	subscriptions *bome.JSONMap
+	queue         *bome.JSONMap
+	deadLetters   *bome.JSONMap
+	sealer        *envelope.Sealer
+}
+
+// encode returns the stored form of subscription, the secret is sealed when a sealer is configured
+func (s *sqlWebhooksDB) encode(subscription *WebhookSubscription) (string, error) {
+	if s.sealer != nil && subscription.Secret != "" && !envelope.IsSealed(subscription.Secret) {
+		sealed, err := s.sealer.Seal(subscription.Secret, subscription.ID)
+		if err != nil {
+			return "", err
+		}
+		copied := *subscription
+		copied.Secret = sealed
+		subscription = &copied
+	}
+	encoded, err := json.Marshal(subscription)
+	return string(encoded), err
+}
+
+func (s *sqlWebhooksDB) decode(value string) (*WebhookSubscription, error) {
+	subscription := new(WebhookSubscription)
+	err := json.Unmarshal([]byte(value), subscription)
+	if err != nil || !envelope.IsSealed(subscription.Secret) {
+		return subscription, err
+	}
+
+	if s.sealer == nil {
+		return nil, fmt.Errorf("dao: webhook %s secret is encrypted and no master key is configured", subscription.ID)
+	}
+	subscription.Secret, err = s.sealer.Open(subscription.Secret, subscription.ID)
+	if err != nil {
+		return nil, fmt.Errorf("dao: webhook %s: %s", subscription.ID, err)
+	}
+	return subscription, nil
+}
+
+func (s *sqlWebhooksDB) SaveSubscription(subscription *WebhookSubscription) error {
+	encoded, err := s.encode(subscription)
+	if err != nil {
+		return err
+	}
+	return s.subscriptions.Save(&bome.MapEntry{
+		Key:   subscription.ID,
+		Value: encoded,
+	})
+}
+
+func (s *sqlWebhooksDB) GetSubscription(id string) (*WebhookSubscription, error) {
+	value, err := s.subscriptions.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.decode(value)
+}
+
+func (s *sqlWebhooksDB) GetSubscriptions() ([]*WebhookSubscription, error) {
+	cursor, err := s.subscriptions.List()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var subscriptions []*WebhookSubscription
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		subscription, err := s.decode(o.(*bome.MapEntry).Value)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (s *sqlWebhooksDB) DeleteSubscription(id string) error {
+	return s.subscriptions.Delete(id)
+}
+
+func (s *sqlWebhooksDB) Reencrypt(limit int) (int, error) {
+	if s.sealer == nil {
+		return 0, nil
+	}
+
+	stale := func(value string) (bool, error) {
+		subscription := new(WebhookSubscription)
+		err := json.Unmarshal([]byte(value), subscription)
+		if err != nil || subscription.Secret == "" {
+			return false, err
+		}
+		return s.sealer.Stale(subscription.Secret)
+	}
+	return resealEntries(s.subscriptions, limit, stale, func(_ string, value string) (string, error) {
+		subscription, err := s.decode(value)
+		if err != nil {
+			return "", err
+		}
+		return s.encode(subscription)
+	})
+}
+
+func (s *sqlWebhooksDB) Enqueue(delivery *WebhookDelivery) error {
+	for _, m := range []*bome.JSONMap{s.queue, s.deadLetters} {
+		_, err := m.Get(delivery.ID)
+		if err == nil {
+			return nil
+		}
+		if !bome.IsNotFound(err) {
+			return err
+		}
+	}
+	return saveDelivery(s.queue, delivery)
+}
+
+func (s *sqlWebhooksDB) UpdateDelivery(delivery *WebhookDelivery) error {
+	return saveDelivery(s.queue, delivery)
+}
+
+func (s *sqlWebhooksDB) DueDeliveries(now int64, limit int) ([]*WebhookDelivery, error) {
+	cursor, err := s.queue.RangeOf(bome.JsonAtLe("$.next_attempt", bome.IntExpr(now)), bome.MapEntryScanner, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(cursor)
+}
+
+func (s *sqlWebhooksDB) DeleteDelivery(id string) error {
+	return s.queue.Delete(id)
+}
+
+func (s *sqlWebhooksDB) Kill(delivery *WebhookDelivery) error {
+	return moveDelivery(s.queue, s.deadLetters, delivery)
+}
+
+func (s *sqlWebhooksDB) GetDeadLetters() ([]*WebhookDelivery, error) {
+	cursor, err := s.deadLetters.List()
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(cursor)
+}
+
+func (s *sqlWebhooksDB) GetDeadLetter(id string) (*WebhookDelivery, error) {
+	value, err := s.deadLetters.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	delivery := new(WebhookDelivery)
+	err = json.Unmarshal([]byte(value), delivery)
+	return delivery, err
+}
+
+func (s *sqlWebhooksDB) Revive(delivery *WebhookDelivery) error {
+	return moveDelivery(s.deadLetters, s.queue, delivery)
+}
+
+func saveDelivery(m *bome.JSONMap, delivery *WebhookDelivery) error {
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return m.Save(&bome.MapEntry{
+		Key:   delivery.ID,
+		Value: string(encoded),
+	})
+}
+
+// moveDelivery atomically removes the delivery from one map and saves it in the other
+func moveDelivery(from *bome.JSONMap, to *bome.JSONMap, delivery *WebhookDelivery) error {
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	fromTx, err := from.BeginTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = fromTx.Delete(delivery.ID)
+	if err != nil {
+		_ = fromTx.Rollback()
+		return err
+	}
+
+	err = to.ContinueTransaction(fromTx.TX()).Save(&bome.MapEntry{
+		Key:   delivery.ID,
+		Value: string(encoded),
+	})
+	if err != nil {
+		_ = fromTx.Rollback()
+		return err
+	}
+	return fromTx.Commit()
+}
+
+func scanDeliveries(cursor bome.Cursor) ([]*WebhookDelivery, error) {
+	defer func() {
+		_ = cursor.Close()
+	}()
+
+	var deliveries []*WebhookDelivery
+	for cursor.HasNext() {
+		o, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		delivery := new(WebhookDelivery)
+		err = json.Unmarshal([]byte(o.(*bome.MapEntry).Value), delivery)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func NewSQLWebhooksDB(db *sql.DB, dialect string, tableName string) (WebhooksDB, error) {
+	return NewSealedSQLWebhooksDB(db, dialect, tableName, nil)
+}
+
+// NewSealedSQLWebhooksDB stores the subscription secrets sealed by sealer
+func NewSealedSQLWebhooksDB(db *sql.DB, dialect string, tableName string, sealer *envelope.Sealer) (WebhooksDB, error) {
+	subscriptions, err := bome.NewJSONMap(db, dialect, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := bome.NewJSONMap(db, dialect, tableName+"_queue")
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters, err := bome.NewJSONMap(db, dialect, tableName+"_dead_letters")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlWebhooksDB{
+		subscriptions: subscriptions,
+		queue:         queue,
+		deadLetters:   deadLetters,
+		sealer:        sealer,
+	}, nil
+}
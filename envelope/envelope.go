@@ -0,0 +1,168 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SealedPrefix starts the sealed values: enc:1:<master key version>:<wrapped data key>:<nonce and ciphertext>
+const SealedPrefix = "enc:1:"
+
+const dataKeySize = 32
+
+// Sealer encrypts every value with a data key of its own, wrapped by the current master key version
+type Sealer struct {
+	keys KeyProvider
+}
+
+func NewSealer(keys KeyProvider) *Sealer {
+	return &Sealer{keys: keys}
+}
+
+// IsSealed tells whether value was produced by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, SealedPrefix)
+}
+
+// Seal encrypts plaintext. The associated data must be given again to Open, it binds the value to its record
+func (s *Sealer) Seal(plaintext string, associatedData string) (string, error) {
+	version, err := s.keys.CurrentVersion()
+	if err != nil {
+		return "", err
+	}
+	masterKey, err := s.keys.Key(version)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := encrypt(masterKey, dataKey, []byte(version))
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := encrypt(dataKey, []byte(plaintext), []byte(associatedData))
+	if err != nil {
+		return "", err
+	}
+
+	return SealedPrefix + version + ":" +
+		base64.RawURLEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a value returned by Seal. Values that are not sealed are returned as they are
+func (s *Sealer) Open(value string, associatedData string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	version, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	masterKey, err := s.keys.Key(version)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := decrypt(masterKey, wrapped, []byte(version))
+	if err != nil {
+		return "", fmt.Errorf("envelope: could not unwrap data key: %s", err)
+	}
+
+	plaintext, err := decrypt(dataKey, ciphertext, []byte(associatedData))
+	if err != nil {
+		return "", fmt.Errorf("envelope: could not decrypt value: %s", err)
+	}
+	return string(plaintext), nil
+}
+
+// Stale tells whether value must be sealed again: it is either not sealed or sealed with an old master key version
+func (s *Sealer) Stale(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	if !IsSealed(value) {
+		return true, nil
+	}
+
+	current, err := s.keys.CurrentVersion()
+	if err != nil {
+		return false, err
+	}
+	return Version(value) != current, nil
+}
+
+// Version returns the master key version a sealed value is wrapped with
+func Version(value string) string {
+	version, _, _, err := parse(value)
+	if err != nil {
+		return ""
+	}
+	return version
+}
+
+func parse(value string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, SealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("envelope: malformed sealed value")
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("envelope: malformed data key: %s", err)
+	}
+
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("envelope: malformed ciphertext: %s", err)
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// encrypt seals data with AES-GCM, the random nonce is prepended to the ciphertext
+func encrypt(key []byte, data []byte, associatedData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, associatedData), nil
+}
+
+func decrypt(key []byte, data []byte, associatedData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, associatedData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
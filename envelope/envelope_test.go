@@ -0,0 +1,149 @@
+package envelope
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSealer(t *testing.T) (*Sealer, KeyProvider) {
+	keys, err := InitFileKeyring(filepath.Join(t.TempDir(), "master.keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSealer(keys), keys
+}
+
+func TestSealOpen(t *testing.T) {
+	sealer, _ := newTestSealer(t)
+
+	for _, plaintext := range []string{"", "secret", "é:ü:" + strings.Repeat("x", 4096)} {
+		sealed, err := sealer.Seal(plaintext, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealed(sealed) || strings.Contains(sealed, plaintext) && plaintext != "" {
+			t.Errorf("Seal(%q) returned %q", plaintext, sealed)
+		}
+
+		opened, err := sealer.Open(sealed, "app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opened != plaintext {
+			t.Errorf("Open returned %q, want %q", opened, plaintext)
+		}
+	}
+}
+
+func TestSealRandomized(t *testing.T) {
+	sealer, _ := newTestSealer(t)
+
+	first, err := sealer.Seal("secret", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sealer.Seal("secret", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("sealing twice the same value gave the same output")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	sealer, _ := newTestSealer(t)
+	sealed, err := sealer.Seal("secret", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(sealed, ":")
+
+	ciphertext := []byte(parts[4])
+	if ciphertext[5] == 'A' {
+		ciphertext[5] = 'B'
+	} else {
+		ciphertext[5] = 'A'
+	}
+	tampered := strings.Join([]string{parts[0], parts[1], parts[2], parts[3], string(ciphertext)}, ":")
+
+	tests := []struct {
+		name           string
+		value          string
+		associatedData string
+		want           string
+		wantErr        bool
+	}{
+		{name: "plaintext", value: "secret", associatedData: "app", want: "secret"},
+		{name: "sealed", value: sealed, associatedData: "app", want: "secret"},
+		{name: "other record", value: sealed, associatedData: "other", wantErr: true},
+		{name: "unknown version", value: strings.Join([]string{parts[0], parts[1], "9", parts[3], parts[4]}, ":"), associatedData: "app", wantErr: true},
+		{name: "swapped data key", value: strings.Join([]string{parts[0], parts[1], parts[2], parts[4], parts[3]}, ":"), associatedData: "app", wantErr: true},
+		{name: "truncated", value: strings.Join(parts[:4], ":"), associatedData: "app", wantErr: true},
+		{name: "bad encoding", value: sealed + "!", associatedData: "app", wantErr: true},
+		{name: "tampered", value: tampered, associatedData: "app", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sealer.Open(tt.value, tt.associatedData)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Open() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStale(t *testing.T) {
+	sealer, keys := newTestSealer(t)
+
+	old, err := sealer.Seal("secret", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := keys.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := sealer.Seal("secret", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if Version(old) != "1" || Version(current) != version || Version("secret") != "" {
+		t.Errorf("versions are %q, %q", Version(old), Version(current))
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "empty", value: "", want: false},
+		{name: "plaintext", value: "secret", want: true},
+		{name: "old version", value: old, want: true},
+		{name: "current version", value: current, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sealer.Stale(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Stale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	opened, err := sealer.Open(old, "app")
+	if err != nil || opened != "secret" {
+		t.Errorf("value sealed with the old version opened as %q, %v", opened, err)
+	}
+}
@@ -0,0 +1,364 @@
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MasterKeySize is the size of the master keys, used with AES-256
+const MasterKeySize = 32
+
+// ErrNoMasterKey is returned when the master keys have not been initialized
+var ErrNoMasterKey = errors.New("envelope: no master key found")
+
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// KeyProvider holds the versioned master keys that wrap the data keys
+type KeyProvider interface {
+	CurrentVersion() (string, error)
+	Key(version string) ([]byte, error)
+	// Rotate creates a new master key version and makes it current
+	Rotate() (string, error)
+}
+
+// keyring is a set of versioned keys reloaded when its storage changes
+type keyring struct {
+	mutex    sync.Mutex
+	modTime  time.Time
+	versions []string
+	keys     map[string][]byte
+	stat     func() (time.Time, error)
+	load     func() ([]string, map[string][]byte, error)
+}
+
+func (k *keyring) refresh() error {
+	modTime, err := k.stat()
+	if err != nil {
+		return err
+	}
+	if k.keys != nil && modTime.Equal(k.modTime) {
+		return nil
+	}
+
+	versions, keys, err := k.load()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return ErrNoMasterKey
+	}
+	k.modTime, k.versions, k.keys = modTime, versions, keys
+	return nil
+}
+
+func (k *keyring) CurrentVersion() (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	err := k.refresh()
+	if err != nil {
+		return "", err
+	}
+	return k.versions[len(k.versions)-1], nil
+}
+
+func (k *keyring) Key(version string) ([]byte, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	err := k.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	key, found := k.keys[version]
+	if !found {
+		return nil, fmt.Errorf("envelope: unknown master key version %q", version)
+	}
+	return key, nil
+}
+
+func newMasterKey() ([]byte, error) {
+	key := make([]byte, MasterKeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+func decodeMasterKey(version string, encoded string) ([]byte, error) {
+	if !versionPattern.MatchString(version) {
+		return nil, fmt.Errorf("envelope: invalid master key version %q", version)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: master key %s: %s", version, err)
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("envelope: master key %s must be %d bytes long", version, MasterKeySize)
+	}
+	return key, nil
+}
+
+// FileKeyring reads the master keys from a file of "<version>:<base64 key>" lines, the last one being current
+type FileKeyring struct {
+	keyring
+	filename string
+}
+
+// NewFileKeyring loads the master keys of filename. The file must have been created with InitFileKeyring
+func NewFileKeyring(filename string) (*FileKeyring, error) {
+	k := fileKeyring(filename)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, ErrNoMasterKey
+	}
+	_, err := k.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// InitFileKeyring creates filename with a first master key version. It fails if filename exists
+func InitFileKeyring(filename string) (*FileKeyring, error) {
+	if _, err := os.Stat(filename); err == nil {
+		return nil, fmt.Errorf("envelope: %s already exists", filename)
+	}
+
+	k := fileKeyring(filename)
+	_, err := k.Rotate()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func fileKeyring(filename string) *FileKeyring {
+	k := &FileKeyring{filename: filename}
+	k.stat = func() (time.Time, error) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+	k.load = k.read
+	return k
+}
+
+func (k *FileKeyring) read() ([]string, map[string][]byte, error) {
+	data, err := ioutil.ReadFile(k.filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var versions []string
+	keys := map[string][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("envelope: %s: lines must be <version>:<base64 key>", k.filename)
+		}
+		if _, found := keys[parts[0]]; found {
+			return nil, nil, fmt.Errorf("envelope: %s: duplicate version %s", k.filename, parts[0])
+		}
+
+		keys[parts[0]], err = decodeMasterKey(parts[0], parts[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		versions = append(versions, parts[0])
+	}
+	return versions, keys, scanner.Err()
+}
+
+// Rotate appends a new version to the file
+func (k *FileKeyring) Rotate() (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	version := "1"
+	if _, err := os.Stat(k.filename); err == nil {
+		versions, _, err := k.read()
+		if err != nil {
+			return "", err
+		}
+		version = nextVersion(versions)
+	}
+
+	key, err := newMasterKey()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(k.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(f, "%s:%s\n", version, base64.StdEncoding.EncodeToString(key))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	k.keys = nil
+	return version, nil
+}
+
+// LocalKMS stores each master key version in a "<version>.key" file of a private directory
+type LocalKMS struct {
+	keyring
+	dir string
+}
+
+// NewLocalKMS opens the keys directory. It must have been created with InitLocalKMS
+func NewLocalKMS(dir string) (*LocalKMS, error) {
+	k := localKMS(dir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, ErrNoMasterKey
+	}
+	_, err := k.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// InitLocalKMS creates the keys directory with a first master key version. It fails if the directory holds keys
+func InitLocalKMS(dir string) (*LocalKMS, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	k := localKMS(dir)
+	versions, _, err := k.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		return nil, fmt.Errorf("envelope: %s already holds master keys", dir)
+	}
+
+	_, err = k.Rotate()
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func localKMS(dir string) *LocalKMS {
+	k := &LocalKMS{dir: dir}
+	k.stat = func() (time.Time, error) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+	k.load = k.read
+	return k
+}
+
+func (k *LocalKMS) read() ([]string, map[string][]byte, error) {
+	filenames, err := filepath.Glob(filepath.Join(k.dir, "*.key"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var versions []string
+	keys := map[string][]byte{}
+	for _, filename := range filenames {
+		version := strings.TrimSuffix(filepath.Base(filename), ".key")
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[version], err = decodeMasterKey(version, strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, nil, err
+		}
+		versions = append(versions, version)
+	}
+	sortVersions(versions)
+	return versions, keys, nil
+}
+
+// Rotate writes a new version file
+func (k *LocalKMS) Rotate() (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	versions, _, err := k.read()
+	if err != nil {
+		return "", err
+	}
+	version := nextVersion(versions)
+
+	key, err := newMasterKey()
+	if err != nil {
+		return "", err
+	}
+
+	filename := filepath.Join(k.dir, version+".key")
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(key))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	k.keys = nil
+	return version, nil
+}
+
+// nextVersion returns the version following the greatest numeric version of versions
+func nextVersion(versions []string) string {
+	next := 1
+	for _, v := range versions {
+		if n, err := strconv.Atoi(v); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// sortVersions orders numeric versions by value, after the other ones
+func sortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		a, errA := strconv.Atoi(versions[i])
+		b, errB := strconv.Atoi(versions[j])
+		switch {
+		case errA == nil && errB == nil:
+			return a < b
+		case errA != nil && errB != nil:
+			return versions[i] < versions[j]
+		default:
+			return errA != nil
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package envelope
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// keyProviderFactory opens or initializes the key provider stored at path
+type keyProviderFactory struct {
+	name string
+	open func(path string) (KeyProvider, error)
+	init func(path string) (KeyProvider, error)
+}
+
+var keyProviderFactories = []keyProviderFactory{
+	{
+		name: "file",
+		open: func(path string) (KeyProvider, error) { return NewFileKeyring(path) },
+		init: func(path string) (KeyProvider, error) { return InitFileKeyring(path) },
+	},
+	{
+		name: "local kms",
+		open: func(path string) (KeyProvider, error) { return NewLocalKMS(path) },
+		init: func(path string) (KeyProvider, error) { return InitLocalKMS(path) },
+	},
+}
+
+func TestKeyProviderInit(t *testing.T) {
+	for _, f := range keyProviderFactories {
+		t.Run(f.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+
+			_, err := f.open(path)
+			if err != ErrNoMasterKey {
+				t.Fatalf("opening missing keys returned %v, want ErrNoMasterKey", err)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatal("opening missing keys created them")
+			}
+
+			keys, err := f.init(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			version, err := keys.CurrentVersion()
+			if err != nil || version != "1" {
+				t.Fatalf("initial version is %q, %v", version, err)
+			}
+
+			_, err = f.init(path)
+			if err == nil {
+				t.Error("initializing existing keys succeeded")
+			}
+
+			opened, err := f.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			initial, _ := keys.Key("1")
+			reopened, err := opened.Key("1")
+			if err != nil || string(reopened) != string(initial) {
+				t.Error("reopened keys differ from the initialized ones")
+			}
+		})
+	}
+}
+
+func TestKeyProviderRotate(t *testing.T) {
+	for _, f := range keyProviderFactories {
+		t.Run(f.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			keys, err := f.init(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := f.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			version, err := keys.Rotate()
+			if err != nil || version != "2" {
+				t.Fatalf("Rotate() = %q, %v", version, err)
+			}
+
+			// the new version is seen by the instances sharing the keys
+			current, err := other.CurrentVersion()
+			if err != nil || current != "2" {
+				t.Errorf("other instance current version is %q, %v", current, err)
+			}
+			for _, v := range []string{"1", "2"} {
+				if _, err := other.Key(v); err != nil {
+					t.Errorf("version %s: %v", v, err)
+				}
+			}
+			if _, err := other.Key("3"); err == nil {
+				t.Error("unknown version returned a key")
+			}
+		})
+	}
+}
+
+func TestEmptyLocalKMS(t *testing.T) {
+	_, err := NewLocalKMS(t.TempDir())
+	if err != ErrNoMasterKey {
+		t.Errorf("opening an empty directory returned %v, want ErrNoMasterKey", err)
+	}
+}
+
+func TestFileKeyringRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "versions", content: "1:" + testKey + "\n# comment\n\n2:" + testKey + "\n", want: "2"},
+		{name: "empty", content: "# no key\n", wantErr: true},
+		{name: "missing separator", content: testKey + "\n", wantErr: true},
+		{name: "duplicate version", content: "1:" + testKey + "\n1:" + testKey + "\n", wantErr: true},
+		{name: "invalid version", content: "a/b:" + testKey + "\n", wantErr: true},
+		{name: "short key", content: "1:c2hvcnQ=\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "master.keys")
+			err := ioutil.WriteFile(filename, []byte(tt.content), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := NewFileKeyring(filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFileKeyring() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			version, _ := keys.CurrentVersion()
+			if version != tt.want {
+				t.Errorf("current version is %q, want %q", version, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := []string{"10", "b", "2", "a", "1"}
+	sortVersions(versions)
+
+	want := []string{"a", "b", "1", "2", "10"}
+	for i := range want {
+		if versions[i] != want[i] {
+			t.Fatalf("sorted versions are %v, want %v", versions, want)
+		}
+	}
+	if next := nextVersion(versions); next != "11" {
+		t.Errorf("nextVersion() = %q", next)
+	}
+}
+
+const testKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
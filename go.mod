@@ -7,6 +7,7 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/sessions v1.2.1
 	github.com/grpc-ecosystem/grpc-gateway/v2 v2.0.1
+	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/omecodes/bome v0.0.0-20201212145154-cc0621995609
 	github.com/omecodes/common v0.0.0-20201205124409-0a391e4b4c08
 	github.com/omecodes/libome v0.0.0-20201219125050-603bd134339c
@@ -15,9 +16,12 @@ require (
 	github.com/omecodes/zebou v0.0.0-20201218212929-8dbed76eaa74 // indirect
 	github.com/prometheus/client_golang v1.9.0 // indirect
 	github.com/spf13/cobra v1.1.1
-	golang.org/x/crypto v0.0.0-20201217014255-9d1352758620 // indirect
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.0.0-20201217014255-9d1352758620
 	golang.org/x/net v0.0.0-20201216054612-986b41b23924 // indirect
 	golang.org/x/sys v0.0.0-20201218084310-7d0127a74742 // indirect
-	google.golang.org/genproto v0.0.0-20201214200347-8c77b98c765d // indirect
+	google.golang.org/genproto v0.0.0-20201214200347-8c77b98c765d
 	google.golang.org/grpc v1.34.0
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.4.0
 )
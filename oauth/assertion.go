@@ -0,0 +1,133 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ClientAssertionType is the client_assertion_type value of private_key_jwt authentication (RFC 7523 section 2.2)
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const (
+	// MaxAssertionLifetime is the longest validity period accepted for a client assertion
+	MaxAssertionLifetime = 10 * time.Minute
+
+	clockSkew = 30 * time.Second
+)
+
+// Audience is a JWT aud claim which can be either a string or an array of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a Audience) Contains(values ...string) bool {
+	for _, aud := range a {
+		for _, value := range values {
+			if aud == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ClientAssertionClaims are the claims of a private_key_jwt client assertion
+type ClientAssertionClaims struct {
+	Iss string   `json:"iss"`
+	Sub string   `json:"sub"`
+	Aud Audience `json:"aud"`
+	Exp int64    `json:"exp"`
+	Nbf int64    `json:"nbf,omitempty"`
+	Iat int64    `json:"iat,omitempty"`
+	Jti string   `json:"jti"`
+}
+
+// AssertionVerifier verifies client assertions as described in RFC 7523 section 3
+type AssertionVerifier struct {
+	// Audiences lists the values accepted in the aud claim
+	Audiences []string
+	Replay    ReplayStore
+}
+
+// Verify checks the assertion signature with keys and validates its claims for clientID
+func (v *AssertionVerifier) Verify(assertion string, clientID string, keys []*JWK) (*ClientAssertionClaims, error) {
+	jws, err := ParseJWS(assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = jws.Verify(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := new(ClientAssertionClaims)
+	err = json.Unmarshal(jws.Payload, claims)
+	if err != nil {
+		return nil, errors.New("client assertion: malformed claims")
+	}
+
+	if claims.Iss != clientID || claims.Sub != clientID {
+		return nil, errors.New("client assertion: iss and sub must be the client id")
+	}
+
+	if !claims.Aud.Contains(v.Audiences...) {
+		return nil, errors.New("client assertion: audience mismatch")
+	}
+
+	now := time.Now()
+	exp := time.Unix(claims.Exp, 0)
+	if claims.Exp == 0 || now.After(exp.Add(clockSkew)) {
+		return nil, errors.New("client assertion: expired")
+	}
+
+	if exp.Sub(now) > MaxAssertionLifetime {
+		return nil, errors.New("client assertion: expiration is too far in the future")
+	}
+
+	if claims.Nbf != 0 && now.Add(clockSkew).Before(time.Unix(claims.Nbf, 0)) {
+		return nil, errors.New("client assertion: not yet valid")
+	}
+
+	if claims.Jti == "" {
+		return nil, errors.New("client assertion: jti is required")
+	}
+
+	if v.Replay != nil {
+		fresh, err := v.Replay.Use(clientID+":"+claims.Jti, exp.Add(clockSkew))
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return nil, errors.New("client assertion: already used")
+		}
+	}
+	return claims, nil
+}
+
+// ReplayStore remembers the identifiers of the assertions already used
+type ReplayStore interface {
+	// Use records id until expiry. It returns false if id is already recorded and has not expired
+	Use(id string, expiry time.Time) (bool, error)
+}
@@ -0,0 +1,156 @@
+package oauth
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memReplayStore records the used assertions in memory
+type memReplayStore struct {
+	used map[string]time.Time
+	err  error
+}
+
+func (m *memReplayStore) Use(id string, expiry time.Time) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	if _, found := m.used[id]; found {
+		return false, nil
+	}
+	m.used[id] = expiry
+	return true, nil
+}
+
+func TestAssertionVerifier(t *testing.T) {
+	key := newECKey(t, elliptic.P256())
+	keys := []*JWK{newJWK(t, "k", &key.PublicKey)}
+	otherKeys := []*JWK{newJWK(t, "k", &newECKey(t, elliptic.P256()).PublicKey)}
+
+	now := time.Now().Unix()
+	valid := func() *ClientAssertionClaims {
+		return &ClientAssertionClaims{
+			Iss: "app",
+			Sub: "app",
+			Aud: Audience{"https://registry.example.com/oauth/token"},
+			Exp: now + 60,
+			Iat: now,
+			Jti: "jti",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(c *ClientAssertionClaims)
+		keys    []*JWK
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "issuer audience", change: func(c *ClientAssertionClaims) { c.Aud = Audience{"https://registry.example.com", "other"} }},
+		{name: "other signer", keys: otherKeys, wantErr: true},
+		{name: "other issuer", change: func(c *ClientAssertionClaims) { c.Iss = "other" }, wantErr: true},
+		{name: "other subject", change: func(c *ClientAssertionClaims) { c.Sub = "other" }, wantErr: true},
+		{name: "other audience", change: func(c *ClientAssertionClaims) { c.Aud = Audience{"https://other.example.com"} }, wantErr: true},
+		{name: "no expiration", change: func(c *ClientAssertionClaims) { c.Exp = 0 }, wantErr: true},
+		{name: "expired", change: func(c *ClientAssertionClaims) { c.Exp = now - 60 }, wantErr: true},
+		{name: "expired within clock skew", change: func(c *ClientAssertionClaims) { c.Exp = now - 10 }},
+		{name: "long lifetime", change: func(c *ClientAssertionClaims) { c.Exp = now + int64(MaxAssertionLifetime/time.Second) + 60 }, wantErr: true},
+		{name: "not yet valid", change: func(c *ClientAssertionClaims) { c.Nbf = now + 60 }, wantErr: true},
+		{name: "valid within clock skew", change: func(c *ClientAssertionClaims) { c.Nbf = now + 10 }},
+		{name: "no jti", change: func(c *ClientAssertionClaims) { c.Jti = "" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			if tt.change != nil {
+				tt.change(claims)
+			}
+			payload, err := json.Marshal(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertion, err := SignES256(key, &JWSHeader{Kid: "k"}, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			verifyKeys := keys
+			if tt.keys != nil {
+				verifyKeys = tt.keys
+			}
+
+			v := &AssertionVerifier{
+				Audiences: []string{"https://registry.example.com", "https://registry.example.com/oauth/token"},
+				Replay:    &memReplayStore{used: map[string]time.Time{}},
+			}
+			_, err = v.Verify(assertion, "app", verifyKeys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssertionReplay(t *testing.T) {
+	key := newECKey(t, elliptic.P256())
+	keys := []*JWK{newJWK(t, "k", &key.PublicKey)}
+
+	exp := time.Now().Add(time.Minute).Unix()
+	payload, _ := json.Marshal(&ClientAssertionClaims{Iss: "app", Sub: "app", Aud: Audience{"aud"}, Exp: exp, Jti: "jti"})
+	assertion, err := SignES256(key, &JWSHeader{}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &memReplayStore{used: map[string]time.Time{}}
+	v := &AssertionVerifier{Audiences: []string{"aud"}, Replay: store}
+	if _, err := v.Verify(assertion, "app", keys); err != nil {
+		t.Fatal(err)
+	}
+	if expiry := store.used["app:jti"]; !expiry.After(time.Unix(exp, 0)) {
+		t.Errorf("the assertion is remembered until %s only", expiry)
+	}
+	if _, err := v.Verify(assertion, "app", keys); err == nil {
+		t.Error("a replayed assertion was accepted")
+	}
+
+	storeErr := errors.New("database unavailable")
+	v.Replay = &memReplayStore{err: storeErr}
+	if _, err := v.Verify(assertion, "app", keys); err != storeErr {
+		t.Errorf("Verify() = %v with a failing replay store", err)
+	}
+}
+
+func TestAudienceJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want Audience
+	}{
+		{json: `"a"`, want: Audience{"a"}},
+		{json: `["a","b"]`, want: Audience{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		var aud Audience
+		if err := json.Unmarshal([]byte(tt.json), &aud); err != nil {
+			t.Fatal(err)
+		}
+		if len(aud) != len(tt.want) || !aud.Contains(tt.want...) {
+			t.Errorf("%s decoded as %v", tt.json, aud)
+		}
+
+		encoded, err := json.Marshal(aud)
+		if err != nil || string(encoded) != tt.json {
+			t.Errorf("%v encoded as %s, %v", aud, encoded, err)
+		}
+	}
+
+	var aud Audience
+	if err := json.Unmarshal([]byte(`42`), &aud); err == nil {
+		t.Error("a number was decoded as an audience")
+	}
+}
@@ -0,0 +1,264 @@
+package oauth
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Client types as defined in RFC 6749 section 2.1
+const (
+	ClientTypeConfidential = "confidential"
+	ClientTypePublic       = "public"
+)
+
+// Grant types an application can be allowed to use
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypePassword          = "password"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTypeJWTBearer         = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+const (
+	// MaxAccessTokenTTL is the longest access token lifetime in seconds an application can request
+	MaxAccessTokenTTL = 24 * 3600
+
+	// MaxRefreshTokenTTL is the longest refresh token lifetime in seconds an application can request
+	MaxRefreshTokenTTL = 90 * 24 * 3600
+)
+
+var knownGrantTypes = map[string]bool{
+	GrantTypeAuthorizationCode: true,
+	GrantTypeClientCredentials: true,
+	GrantTypeRefreshToken:      true,
+	GrantTypePassword:          true,
+	GrantTypeDeviceCode:        true,
+	GrantTypeJWTBearer:         true,
+}
+
+// InvalidFieldError is returned when a client profile field does not satisfy the registry policy
+type InvalidFieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *InvalidFieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// ClientProfile holds the OAuth2 policy of an application
+type ClientProfile struct {
+	ApplicationID           string   `json:"application_id"`
+	ClientType              string   `json:"client_type"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	Scopes                  []string `json:"scopes,omitempty"`
+	AccessTokenTTL          int64    `json:"access_token_ttl,omitempty"`
+	RefreshTokenTTL         int64    `json:"refresh_token_ttl,omitempty"`
+	RequirePKCE             bool     `json:"require_pkce,omitempty"`
+	JWKS                    *JWKSet  `json:"jwks,omitempty"`
+}
+
+// DefaultClientProfile returns the profile of applications that never had one saved
+func DefaultClientProfile(applicationID string, callbackURL string) *ClientProfile {
+	p := &ClientProfile{
+		ApplicationID: applicationID,
+		ClientType:    ClientTypeConfidential,
+		GrantTypes:    []string{GrantTypeAuthorizationCode},
+	}
+	if callbackURL != "" {
+		p.RedirectURIs = []string{callbackURL}
+	}
+	return p
+}
+
+// Validate checks the profile against the registry policy
+func (p *ClientProfile) Validate() error {
+	if p.ApplicationID == "" {
+		return &InvalidFieldError{Field: "application_id", Reason: "must not be empty"}
+	}
+
+	if p.ClientType != ClientTypeConfidential && p.ClientType != ClientTypePublic {
+		return &InvalidFieldError{Field: "client_type", Reason: fmt.Sprintf("must be %q or %q", ClientTypeConfidential, ClientTypePublic)}
+	}
+
+	if p.TokenEndpointAuthMethod != "" {
+		if !knownAuthMethods[p.TokenEndpointAuthMethod] {
+			return &InvalidFieldError{Field: "token_endpoint_auth_method", Reason: fmt.Sprintf("%s is not supported", p.TokenEndpointAuthMethod)}
+		}
+		if (p.TokenEndpointAuthMethod == AuthMethodNone) != (p.ClientType == ClientTypePublic) {
+			return &InvalidFieldError{Field: "token_endpoint_auth_method", Reason: "none must be used by public clients only"}
+		}
+	}
+
+	if p.JWKS != nil {
+		kids := map[string]bool{}
+		for _, key := range p.JWKS.Keys {
+			if err := key.Validate(); err != nil {
+				return &InvalidFieldError{Field: "jwks", Reason: err.Error()}
+			}
+			if key.Kid != "" && kids[key.Kid] {
+				return &InvalidFieldError{Field: "jwks", Reason: fmt.Sprintf("key id %s is duplicated", key.Kid)}
+			}
+			kids[key.Kid] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, uri := range p.RedirectURIs {
+		if seen[uri] {
+			return &InvalidFieldError{Field: "redirect_uris", Reason: fmt.Sprintf("%s is duplicated", uri)}
+		}
+		seen[uri] = true
+
+		if err := ValidateRedirectURI(uri, p.ClientType == ClientTypePublic); err != nil {
+			return err
+		}
+	}
+
+	seen = map[string]bool{}
+	for _, grantType := range p.GrantTypes {
+		if !knownGrantTypes[grantType] {
+			return &InvalidFieldError{Field: "grant_types", Reason: fmt.Sprintf("%s is not supported", grantType)}
+		}
+		if seen[grantType] {
+			return &InvalidFieldError{Field: "grant_types", Reason: fmt.Sprintf("%s is duplicated", grantType)}
+		}
+		seen[grantType] = true
+	}
+
+	if seen[GrantTypeAuthorizationCode] && len(p.RedirectURIs) == 0 {
+		return &InvalidFieldError{Field: "redirect_uris", Reason: "at least one is required for the authorization_code grant"}
+	}
+
+	if p.ClientType == ClientTypePublic {
+		if seen[GrantTypeClientCredentials] {
+			return &InvalidFieldError{Field: "grant_types", Reason: "public clients cannot use the client_credentials grant"}
+		}
+		if seen[GrantTypeAuthorizationCode] && !p.RequirePKCE {
+			return &InvalidFieldError{Field: "require_pkce", Reason: "public clients must use PKCE"}
+		}
+	}
+
+	seen = map[string]bool{}
+	for _, scope := range p.Scopes {
+		if !isScopeToken(scope) {
+			return &InvalidFieldError{Field: "scopes", Reason: fmt.Sprintf("%q is not a valid scope", scope)}
+		}
+		if seen[scope] {
+			return &InvalidFieldError{Field: "scopes", Reason: fmt.Sprintf("%s is duplicated", scope)}
+		}
+		seen[scope] = true
+	}
+
+	if p.AccessTokenTTL < 0 || p.AccessTokenTTL > MaxAccessTokenTTL {
+		return &InvalidFieldError{Field: "access_token_ttl", Reason: fmt.Sprintf("must be between 0 and %d seconds", MaxAccessTokenTTL)}
+	}
+
+	if p.RefreshTokenTTL < 0 || p.RefreshTokenTTL > MaxRefreshTokenTTL {
+		return &InvalidFieldError{Field: "refresh_token_ttl", Reason: fmt.Sprintf("must be between 0 and %d seconds", MaxRefreshTokenTTL)}
+	}
+
+	return nil
+}
+
+// MatchRedirectURI tells whether uri is exactly one of the registered redirect URIs
+func (p *ClientProfile) MatchRedirectURI(uri string) bool {
+	for _, registered := range p.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAuthMethod tells whether the application can authenticate with the given token endpoint method
+func (p *ClientProfile) AllowsAuthMethod(method string) bool {
+	if p.TokenEndpointAuthMethod == "" {
+		return method == AuthMethodClientSecretBasic || method == AuthMethodClientSecretPost
+	}
+	return p.TokenEndpointAuthMethod == method
+}
+
+// AllowsGrantType tells whether the application is allowed to use the grant type
+func (p *ClientProfile) AllowsGrantType(grantType string) bool {
+	for _, gt := range p.GrantTypes {
+		if gt == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope tells whether every scope of the space separated scope string is allowed
+func (p *ClientProfile) AllowsScope(scope string) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+
+	allowed := map[string]bool{}
+	for _, s := range p.Scopes {
+		allowed[s] = true
+	}
+
+	for _, s := range strings.Fields(scope) {
+		if !allowed[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateRedirectURI checks that uri can be registered as a redirect URI
+func ValidateRedirectURI(uri string, public bool) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() {
+		return &InvalidFieldError{Field: "redirect_uris", Reason: fmt.Sprintf("%s is not an absolute URI", uri)}
+	}
+
+	if u.Fragment != "" || strings.Contains(uri, "#") {
+		return &InvalidFieldError{Field: "redirect_uris", Reason: fmt.Sprintf("%s must not contain a fragment", uri)}
+	}
+
+	switch u.Scheme {
+	case "https":
+		if u.Host == "" {
+			return &InvalidFieldError{Field: "redirect_uris", Reason: fmt.Sprintf("%s has no host", uri)}
+		}
+	case "http":
+		if !isLoopback(u.Hostname()) {
+			return &InvalidFieldError{Field: "redirect_uris", Reason: fmt.Sprintf("%s must use https", uri)}
+		}
+	default:
+		if !public || !strings.Contains(u.Scheme, ".") {
+			return &InvalidFieldError{Field: "redirect_uris", Reason: fmt.Sprintf("%s uses a scheme that is not allowed", uri)}
+		}
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isScopeToken checks scope syntax as defined in RFC 6749 section 3.3
+func isScopeToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c != 0x21 && (c < 0x23 || c > 0x5B) && (c < 0x5D || c > 0x7E) {
+			return false
+		}
+	}
+	return true
+}
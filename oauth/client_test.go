@@ -0,0 +1,156 @@
+package oauth
+
+import (
+	"testing"
+)
+
+func TestClientProfileValidate(t *testing.T) {
+	confidential := func() *ClientProfile {
+		return &ClientProfile{
+			ApplicationID: "app",
+			ClientType:    ClientTypeConfidential,
+			RedirectURIs:  []string{"https://app.example.com/callback"},
+			GrantTypes:    []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+			Scopes:        []string{"openid", "profile"},
+		}
+	}
+	public := func() *ClientProfile {
+		p := confidential()
+		p.ClientType = ClientTypePublic
+		p.TokenEndpointAuthMethod = AuthMethodNone
+		p.RequirePKCE = true
+		return p
+	}
+
+	tests := []struct {
+		name      string
+		profile   func() *ClientProfile
+		change    func(p *ClientProfile)
+		wantField string
+	}{
+		{name: "confidential", profile: confidential},
+		{name: "public", profile: public},
+		{name: "missing application id", profile: confidential, change: func(p *ClientProfile) { p.ApplicationID = "" }, wantField: "application_id"},
+		{name: "unknown client type", profile: confidential, change: func(p *ClientProfile) { p.ClientType = "other" }, wantField: "client_type"},
+		{name: "unknown auth method", profile: confidential, change: func(p *ClientProfile) { p.TokenEndpointAuthMethod = "magic" }, wantField: "token_endpoint_auth_method"},
+		{name: "confidential without auth", profile: confidential, change: func(p *ClientProfile) { p.TokenEndpointAuthMethod = AuthMethodNone }, wantField: "token_endpoint_auth_method"},
+		{name: "public with secret", profile: public, change: func(p *ClientProfile) { p.TokenEndpointAuthMethod = AuthMethodClientSecretBasic }, wantField: "token_endpoint_auth_method"},
+		{name: "invalid key", profile: confidential, change: func(p *ClientProfile) { p.JWKS = &JWKSet{Keys: []*JWK{{Kty: "oct"}}} }, wantField: "jwks"},
+		{name: "duplicated redirect URI", profile: confidential, change: func(p *ClientProfile) { p.RedirectURIs = append(p.RedirectURIs, p.RedirectURIs[0]) }, wantField: "redirect_uris"},
+		{name: "http redirect URI", profile: confidential, change: func(p *ClientProfile) { p.RedirectURIs = []string{"http://app.example.com/cb"} }, wantField: "redirect_uris"},
+		{name: "unknown grant type", profile: confidential, change: func(p *ClientProfile) { p.GrantTypes = []string{"implicit"} }, wantField: "grant_types"},
+		{name: "duplicated grant type", profile: confidential, change: func(p *ClientProfile) { p.GrantTypes = []string{GrantTypePassword, GrantTypePassword} }, wantField: "grant_types"},
+		{name: "code without redirect URI", profile: confidential, change: func(p *ClientProfile) { p.RedirectURIs = nil }, wantField: "redirect_uris"},
+		{name: "client credentials without redirect URI", profile: confidential, change: func(p *ClientProfile) {
+			p.RedirectURIs = nil
+			p.GrantTypes = []string{GrantTypeClientCredentials}
+		}},
+		{name: "public client credentials", profile: public, change: func(p *ClientProfile) { p.GrantTypes = []string{GrantTypeClientCredentials} }, wantField: "grant_types"},
+		{name: "public without PKCE", profile: public, change: func(p *ClientProfile) { p.RequirePKCE = false }, wantField: "require_pkce"},
+		{name: "invalid scope", profile: confidential, change: func(p *ClientProfile) { p.Scopes = []string{"read write"} }, wantField: "scopes"},
+		{name: "duplicated scope", profile: confidential, change: func(p *ClientProfile) { p.Scopes = []string{"read", "read"} }, wantField: "scopes"},
+		{name: "negative access token TTL", profile: confidential, change: func(p *ClientProfile) { p.AccessTokenTTL = -1 }, wantField: "access_token_ttl"},
+		{name: "long access token TTL", profile: confidential, change: func(p *ClientProfile) { p.AccessTokenTTL = MaxAccessTokenTTL + 1 }, wantField: "access_token_ttl"},
+		{name: "long refresh token TTL", profile: confidential, change: func(p *ClientProfile) { p.RefreshTokenTTL = MaxRefreshTokenTTL + 1 }, wantField: "refresh_token_ttl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.profile()
+			if tt.change != nil {
+				tt.change(p)
+			}
+
+			err := p.Validate()
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+
+			fieldErr, ok := err.(*InvalidFieldError)
+			if !ok {
+				t.Fatalf("Validate() = %v, want an *InvalidFieldError", err)
+			}
+			if fieldErr.Field != tt.wantField {
+				t.Errorf("rejected field is %s, want %s", fieldErr.Field, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		public  bool
+		wantErr bool
+	}{
+		{uri: "https://app.example.com/callback"},
+		{uri: "http://localhost:8080/callback"},
+		{uri: "http://127.0.0.1/callback"},
+		{uri: "http://[::1]/callback"},
+		{uri: "com.example.app:/callback", public: true},
+		{uri: "com.example.app:/callback", wantErr: true},
+		{uri: "myapp:/callback", public: true, wantErr: true},
+		{uri: "http://app.example.com/callback", wantErr: true},
+		{uri: "https:///callback", wantErr: true},
+		{uri: "https://app.example.com/callback#fragment", wantErr: true},
+		{uri: "https://app.example.com/callback#", wantErr: true},
+		{uri: "/callback", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateRedirectURI(tt.uri, tt.public)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRedirectURI(%q, %v) = %v, wantErr %v", tt.uri, tt.public, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClientProfileAllows(t *testing.T) {
+	p := &ClientProfile{
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		GrantTypes:   []string{GrantTypeAuthorizationCode},
+		Scopes:       []string{"read", "write"},
+	}
+
+	tests := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{name: "registered redirect URI", got: p.MatchRedirectURI("https://app.example.com/callback"), want: true},
+		{name: "redirect URI prefix", got: p.MatchRedirectURI("https://app.example.com/callback/x"), want: false},
+		{name: "allowed grant type", got: p.AllowsGrantType(GrantTypeAuthorizationCode), want: true},
+		{name: "other grant type", got: p.AllowsGrantType(GrantTypePassword), want: false},
+		{name: "allowed scopes", got: p.AllowsScope("read  write"), want: true},
+		{name: "other scope", got: p.AllowsScope("read admin"), want: false},
+		{name: "unrestricted scopes", got: (&ClientProfile{}).AllowsScope("admin"), want: true},
+		{name: "default secret basic", got: p.AllowsAuthMethod(AuthMethodClientSecretBasic), want: true},
+		{name: "default secret post", got: p.AllowsAuthMethod(AuthMethodClientSecretPost), want: true},
+		{name: "default private key", got: p.AllowsAuthMethod(AuthMethodPrivateKeyJWT), want: false},
+		{name: "registered method", got: (&ClientProfile{TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT}).AllowsAuthMethod(AuthMethodPrivateKeyJWT), want: true},
+		{name: "other than registered method", got: (&ClientProfile{TokenEndpointAuthMethod: AuthMethodPrivateKeyJWT}).AllowsAuthMethod(AuthMethodClientSecretBasic), want: false},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestDefaultClientProfile(t *testing.T) {
+	p := DefaultClientProfile("app", "https://app.example.com/callback")
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.MatchRedirectURI("https://app.example.com/callback") {
+		t.Error("the callback URL is not a redirect URI")
+	}
+
+	if p := DefaultClientProfile("app", ""); len(p.RedirectURIs) != 0 {
+		t.Errorf("redirect URIs are %v", p.RedirectURIs)
+	}
+}
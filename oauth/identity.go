@@ -0,0 +1,54 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdentityTokenType is the typ header of the application identity tokens issued by the registry
+const IdentityTokenType = "app-identity+jwt"
+
+// IdentityClaims are the claims of an application identity token
+type IdentityClaims struct {
+	Iss        string `json:"iss"`
+	Sub        string `json:"sub"`
+	Iat        int64  `json:"iat"`
+	Exp        int64  `json:"exp"`
+	Jti        string `json:"jti"`
+	Level      string `json:"level"`
+	Activated  bool   `json:"activated"`
+	AuthMethod string `json:"auth_method,omitempty"`
+}
+
+// VerifyIdentityToken checks the token signature with keys and validates its type, issuer and expiration
+func VerifyIdentityToken(token string, issuer string, keys []*JWK) (*IdentityClaims, error) {
+	jws, err := ParseJWS(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if jws.Header.Typ != IdentityTokenType {
+		return nil, errors.New("identity token: unexpected type")
+	}
+
+	_, err = jws.Verify(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := new(IdentityClaims)
+	err = json.Unmarshal(jws.Payload, claims)
+	if err != nil {
+		return nil, errors.New("identity token: malformed claims")
+	}
+
+	if claims.Iss != issuer {
+		return nil, errors.New("identity token: issuer mismatch")
+	}
+
+	if claims.Exp == 0 || time.Now().After(time.Unix(claims.Exp, 0).Add(clockSkew)) {
+		return nil, errors.New("identity token: expired")
+	}
+	return claims, nil
+}
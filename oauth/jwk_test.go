@@ -0,0 +1,93 @@
+package oauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+)
+
+func TestJWKPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey := newECKey(t, elliptic.P384())
+
+	rsaJWK := newJWK(t, "rsa", &rsaKey.PublicKey)
+	ecJWK := newJWK(t, "ec", &ecKey.PublicKey)
+	offCurve := *ecJWK
+	offCurve.Y = ecJWK.X
+
+	tests := []struct {
+		name    string
+		key     *JWK
+		wantErr bool
+	}{
+		{name: "rsa", key: rsaJWK},
+		{name: "ec", key: ecJWK},
+		{name: "short rsa", key: newJWK(t, "short", &shortRSAKey.PublicKey), wantErr: true},
+		{name: "rsa without modulus", key: &JWK{Kty: "RSA", E: rsaJWK.E}, wantErr: true},
+		{name: "rsa exponent 1", key: &JWK{Kty: "RSA", N: rsaJWK.N, E: base64.RawURLEncoding.EncodeToString([]byte{1})}, wantErr: true},
+		{name: "unknown curve", key: &JWK{Kty: "EC", Crv: "P-192", X: ecJWK.X, Y: ecJWK.Y}, wantErr: true},
+		{name: "point off the curve", key: &offCurve, wantErr: true},
+		{name: "bad coordinate encoding", key: &JWK{Kty: "EC", Crv: "P-384", X: "!", Y: ecJWK.Y}, wantErr: true},
+		{name: "symmetric key", key: &JWK{Kty: "oct"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.key.PublicKey()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		key := newECKey(t, curve)
+		pub, err := newJWK(t, "k", &key.PublicKey).PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.PublicKey.Equal(pub) {
+			t.Errorf("%s key changed through its JWK", curve.Params().Name)
+		}
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := newJWK(t, "k", &rsaKey.PublicKey).PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rsaKey.PublicKey.Equal(pub) {
+		t.Error("RSA key changed through its JWK")
+	}
+}
+
+func TestJWKValidate(t *testing.T) {
+	key := newJWK(t, "k", &newECKey(t, elliptic.P256()).PublicKey)
+	if err := key.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	key.Use = "enc"
+	if err := key.Validate(); err == nil {
+		t.Error("an encryption key was accepted")
+	}
+
+	if _, err := NewJWK("k", &ecdsa.PrivateKey{}); err == nil {
+		t.Error("a private key was encoded")
+	}
+}
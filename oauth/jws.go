@@ -0,0 +1,184 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ErrInvalidSignature is returned when a JWS signature does not match any of the candidate keys
+var ErrInvalidSignature = errors.New("jws: invalid signature")
+
+// JWSHeader is the protected header of a compact JWS
+type JWSHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWS is a parsed compact JWS
+type JWS struct {
+	Header       *JWSHeader
+	Payload      []byte
+	signingInput []byte
+	signature    []byte
+}
+
+// ParseJWS decodes a compact serialized JWS without verifying its signature
+func ParseJWS(token string) (*JWS, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jws: malformed token")
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("jws: malformed header")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("jws: malformed payload")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("jws: malformed signature")
+	}
+
+	header := new(JWSHeader)
+	err = json.Unmarshal(headerBytes, header)
+	if err != nil {
+		return nil, errors.New("jws: malformed header")
+	}
+
+	return &JWS{
+		Header:       header,
+		Payload:      payload,
+		signingInput: []byte(parts[0] + "." + parts[1]),
+		signature:    signature,
+	}, nil
+}
+
+// Verify checks the signature with the first key of the set that matches the header kid and algorithm
+func (j *JWS) Verify(keys []*JWK) (*JWK, error) {
+	for _, key := range keys {
+		if j.Header.Kid != "" && key.Kid != j.Header.Kid {
+			continue
+		}
+
+		if key.Alg != "" && key.Alg != j.Header.Alg {
+			continue
+		}
+
+		pub, err := key.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		if verifySignature(j.Header.Alg, pub, j.signingInput, j.signature) == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidSignature
+}
+
+// SignES256 serializes payload as a compact JWS signed with the P-256 key. The header algorithm is set to ES256
+func SignES256(key *ecdsa.PrivateKey, header *JWSHeader, payload []byte) (string, error) {
+	if key.Curve != elliptic.P256() {
+		return "", errors.New("jws: ES256 requires a P-256 key")
+	}
+
+	header.Alg = "ES256"
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerBytes) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := append(padded(r.Bytes(), 32), padded(s.Bytes(), 32)...)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func hashFor(alg string) (crypto.Hash, error) {
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("jws: unsupported algorithm %q", alg)
+	}
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput []byte, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("jws: unsupported algorithm %q", alg)
+	}
+
+	hash, err := hashFor(alg)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		bitSize := pub.Curve.Params().BitSize
+		if alg[2:] == "512" && bitSize != 521 || alg[2:] != "512" && alg[2:] != fmt.Sprint(bitSize) {
+			return ErrInvalidSignature
+		}
+		size := (bitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("jws: unsupported algorithm %q", alg)
+	}
+}
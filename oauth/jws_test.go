@@ -0,0 +1,151 @@
+package oauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newECKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newJWK(t *testing.T, kid string, key crypto.PublicKey) *JWK {
+	jwk, err := NewJWK(kid, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwk
+}
+
+// signRS256 serializes payload as a compact JWS signed with RS256
+func signRS256(t *testing.T, key *rsa.PrivateKey, header *JWSHeader, payload []byte) string {
+	header.Alg = "RS256"
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerBytes) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWSVerify(t *testing.T) {
+	ecKey := newECKey(t, elliptic.P256())
+	otherECKey := newECKey(t, elliptic.P256())
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	es256, err := SignES256(ecKey, &JWSHeader{Kid: "ec"}, []byte(`{"sub":"app"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	es256WithoutKid, err := SignES256(ecKey, &JWSHeader{}, []byte(`{"sub":"app"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs256 := signRS256(t, rsaKey, &JWSHeader{Kid: "rsa"}, []byte(`{"sub":"app"}`))
+
+	ecJWK := newJWK(t, "ec", &ecKey.PublicKey)
+	otherJWK := newJWK(t, "other", &otherECKey.PublicKey)
+	rsaJWK := newJWK(t, "rsa", &rsaKey.PublicKey)
+
+	parts := strings.Split(es256, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"other"}`)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		keys    []*JWK
+		wantKid string
+	}{
+		{name: "es256", token: es256, keys: []*JWK{otherJWK, rsaJWK, ecJWK}, wantKid: "ec"},
+		{name: "rs256", token: rs256, keys: []*JWK{ecJWK, rsaJWK}, wantKid: "rsa"},
+		{name: "without kid", token: es256WithoutKid, keys: []*JWK{otherJWK, ecJWK}, wantKid: "ec"},
+		{name: "kid mismatch", token: es256, keys: []*JWK{{Kty: ecJWK.Kty, Kid: "other", Crv: ecJWK.Crv, X: ecJWK.X, Y: ecJWK.Y}}},
+		{name: "alg mismatch", token: es256, keys: []*JWK{{Kty: ecJWK.Kty, Kid: "ec", Alg: "ES384", Crv: ecJWK.Crv, X: ecJWK.X, Y: ecJWK.Y}}},
+		{name: "other key", token: es256WithoutKid, keys: []*JWK{otherJWK}},
+		{name: "rsa key for es256", token: es256WithoutKid, keys: []*JWK{rsaJWK}},
+		{name: "tampered payload", token: tampered, keys: []*JWK{ecJWK}},
+		{name: "no key", token: es256, keys: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jws, err := ParseJWS(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := jws.Verify(tt.keys)
+			if tt.wantKid == "" {
+				if err != ErrInvalidSignature {
+					t.Errorf("Verify() = %v, want ErrInvalidSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() = %v", err)
+			}
+			if key.Kid != tt.wantKid {
+				t.Errorf("verified with %s, want %s", key.Kid, tt.wantKid)
+			}
+		})
+	}
+}
+
+func TestParseJWS(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"k","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{}`))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid", token: header + "." + payload + ".c2ln"},
+		{name: "two parts", token: header + "." + payload, wantErr: true},
+		{name: "four parts", token: header + "." + payload + ".c2ln.c2ln", wantErr: true},
+		{name: "bad header encoding", token: "!." + payload + ".c2ln", wantErr: true},
+		{name: "header is not JSON", token: payload[:2] + "." + payload + ".c2ln", wantErr: true},
+		{name: "bad payload encoding", token: header + ".!.c2ln", wantErr: true},
+		{name: "bad signature encoding", token: header + "." + payload + ".!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jws, err := ParseJWS(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseJWS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (jws.Header.Alg != "ES256" || jws.Header.Kid != "k" || string(jws.Payload) != "{}") {
+				t.Errorf("ParseJWS() = %+v", jws)
+			}
+		})
+	}
+}
+
+func TestSignES256RequiresP256(t *testing.T) {
+	_, err := SignES256(newECKey(t, elliptic.P384()), &JWSHeader{}, []byte("{}"))
+	if err == nil {
+		t.Error("a P-384 key signed an ES256 token")
+	}
+}
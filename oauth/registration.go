@@ -0,0 +1,119 @@
+package oauth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Token endpoint authentication methods from RFC 7591 section 2
+const (
+	AuthMethodNone              = "none"
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+	AuthMethodPrivateKeyJWT     = "private_key_jwt"
+	AuthMethodTLSClientAuth     = "tls_client_auth"
+)
+
+// Registration error codes from RFC 7591 section 3.2.2
+const (
+	ErrorInvalidRedirectURI    = "invalid_redirect_uri"
+	ErrorInvalidClientMetadata = "invalid_client_metadata"
+)
+
+var knownAuthMethods = map[string]bool{
+	AuthMethodNone:              true,
+	AuthMethodClientSecretBasic: true,
+	AuthMethodClientSecretPost:  true,
+	AuthMethodPrivateKeyJWT:     true,
+	AuthMethodTLSClientAuth:     true,
+}
+
+// ClientMetadata is the client representation exchanged with the dynamic registration endpoints (RFC 7591 and RFC 7592)
+type ClientMetadata struct {
+	ClientID                string   `json:"client_id,omitempty"`
+	ClientSecret            string   `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64    `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   int64    `json:"client_secret_expires_at"`
+	RegistrationAccessToken string   `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string   `json:"registration_client_uri,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	JWKS                    *JWKSet  `json:"jwks,omitempty"`
+}
+
+// ErrorResponse is the OAuth error body returned by the registry endpoints
+type ErrorResponse struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// Profile converts the registration metadata into the client profile of applicationID
+func (m *ClientMetadata) Profile(applicationID string) (*ClientProfile, error) {
+	p := &ClientProfile{
+		ApplicationID:           applicationID,
+		ClientType:              ClientTypeConfidential,
+		RedirectURIs:            m.RedirectURIs,
+		GrantTypes:              m.GrantTypes,
+		Scopes:                  strings.Fields(m.Scope),
+		TokenEndpointAuthMethod: m.TokenEndpointAuthMethod,
+		JWKS:                    m.JWKS,
+	}
+
+	if p.TokenEndpointAuthMethod == "" {
+		p.TokenEndpointAuthMethod = AuthMethodClientSecretBasic
+	}
+
+	if p.TokenEndpointAuthMethod == AuthMethodNone {
+		p.ClientType = ClientTypePublic
+		p.RequirePKCE = true
+	}
+
+	if len(p.GrantTypes) == 0 {
+		p.GrantTypes = []string{GrantTypeAuthorizationCode}
+	}
+
+	for _, responseType := range m.ResponseTypes {
+		if responseType != "code" {
+			return nil, &ErrorResponse{
+				Code:        ErrorInvalidClientMetadata,
+				Description: fmt.Sprintf("response type %s is not supported", responseType),
+			}
+		}
+	}
+
+	err := p.Validate()
+	if err != nil {
+		if fieldErr, ok := err.(*InvalidFieldError); ok {
+			code := ErrorInvalidClientMetadata
+			if fieldErr.Field == "redirect_uris" {
+				code = ErrorInvalidRedirectURI
+			}
+			return nil, &ErrorResponse{Code: code, Description: fieldErr.Error()}
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// FillFromProfile sets the OAuth related metadata from the client profile
+func (m *ClientMetadata) FillFromProfile(p *ClientProfile) {
+	m.ClientID = p.ApplicationID
+	m.RedirectURIs = p.RedirectURIs
+	m.TokenEndpointAuthMethod = p.TokenEndpointAuthMethod
+	m.GrantTypes = p.GrantTypes
+	m.Scope = strings.Join(p.Scopes, " ")
+	m.JWKS = p.JWKS
+	if p.AllowsGrantType(GrantTypeAuthorizationCode) {
+		m.ResponseTypes = []string{"code"}
+	}
+}
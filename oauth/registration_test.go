@@ -0,0 +1,116 @@
+package oauth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientMetadataProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata ClientMetadata
+		wantCode string
+		check    func(t *testing.T, p *ClientProfile)
+	}{
+		{
+			name:     "defaults",
+			metadata: ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb"}},
+			check: func(t *testing.T, p *ClientProfile) {
+				if p.ClientType != ClientTypeConfidential || p.TokenEndpointAuthMethod != AuthMethodClientSecretBasic {
+					t.Errorf("client is %s with %s", p.ClientType, p.TokenEndpointAuthMethod)
+				}
+				if len(p.GrantTypes) != 1 || p.GrantTypes[0] != GrantTypeAuthorizationCode {
+					t.Errorf("grant types are %v", p.GrantTypes)
+				}
+			},
+		},
+		{
+			name: "public client",
+			metadata: ClientMetadata{
+				RedirectURIs:            []string{"com.example.app:/cb"},
+				TokenEndpointAuthMethod: AuthMethodNone,
+				Scope:                   "openid  profile",
+			},
+			check: func(t *testing.T, p *ClientProfile) {
+				if p.ClientType != ClientTypePublic || !p.RequirePKCE {
+					t.Errorf("client is %s, PKCE required: %v", p.ClientType, p.RequirePKCE)
+				}
+				if strings.Join(p.Scopes, ",") != "openid,profile" {
+					t.Errorf("scopes are %v", p.Scopes)
+				}
+			},
+		},
+		{
+			name:     "code response type",
+			metadata: ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb"}, ResponseTypes: []string{"code"}},
+		},
+		{
+			name:     "token response type",
+			metadata: ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb"}, ResponseTypes: []string{"token"}},
+			wantCode: ErrorInvalidClientMetadata,
+		},
+		{
+			name:     "invalid redirect URI",
+			metadata: ClientMetadata{RedirectURIs: []string{"http://app.example.com/cb"}},
+			wantCode: ErrorInvalidRedirectURI,
+		},
+		{
+			name:     "missing redirect URI",
+			metadata: ClientMetadata{},
+			wantCode: ErrorInvalidRedirectURI,
+		},
+		{
+			name:     "unknown grant type",
+			metadata: ClientMetadata{GrantTypes: []string{"implicit"}},
+			wantCode: ErrorInvalidClientMetadata,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.metadata.Profile("app")
+			if tt.wantCode != "" {
+				rsp, ok := err.(*ErrorResponse)
+				if !ok || rsp.Code != tt.wantCode {
+					t.Fatalf("Profile() = %v, want %s", err, tt.wantCode)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Profile() = %v", err)
+			}
+			if p.ApplicationID != "app" {
+				t.Errorf("application id is %s", p.ApplicationID)
+			}
+			if tt.check != nil {
+				tt.check(t, p)
+			}
+		})
+	}
+}
+
+func TestClientMetadataFillFromProfile(t *testing.T) {
+	p := &ClientProfile{
+		ApplicationID:           "app",
+		TokenEndpointAuthMethod: AuthMethodClientSecretPost,
+		RedirectURIs:            []string{"https://app.example.com/cb"},
+		GrantTypes:              []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Scopes:                  []string{"read", "write"},
+	}
+
+	m := &ClientMetadata{}
+	m.FillFromProfile(p)
+	if m.ClientID != "app" || m.Scope != "read write" || m.TokenEndpointAuthMethod != AuthMethodClientSecretPost {
+		t.Errorf("metadata is %+v", m)
+	}
+	if len(m.ResponseTypes) != 1 || m.ResponseTypes[0] != "code" {
+		t.Errorf("response types are %v", m.ResponseTypes)
+	}
+
+	m = &ClientMetadata{}
+	m.FillFromProfile(&ClientProfile{ApplicationID: "app", GrantTypes: []string{GrantTypeClientCredentials}})
+	if len(m.ResponseTypes) != 0 {
+		t.Errorf("response types are %v without the code grant", m.ResponseTypes)
+	}
+}
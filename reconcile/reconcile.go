@@ -0,0 +1,356 @@
+package reconcile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/validation"
+	"github.com/omecodes/bome"
+	"github.com/omecodes/libome"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	// ActionAdopt updates an application created outside of the declarative files and marks it as managed
+	ActionAdopt = "adopt"
+)
+
+// Definition is an application declared in a file
+type Definition struct {
+	Source      string
+	Application *ome.Application
+}
+
+// Stores gives access to the registry content the reconciliation changes
+type Stores struct {
+	Applications dao.ApplicationsDB
+	Managed      dao.ManagedApplicationsDB
+	Clients      dao.ClientProfilesDB
+	Keys         dao.KeysDB
+}
+
+// Load reads the application definitions of the given files and directories
+func Load(paths ...string) ([]*Definition, error) {
+	var files []string
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if path == p || isDefinitionFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+
+	var definitions []*Definition
+	sources := map[string]string{}
+	for _, file := range files {
+		apps, err := loadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reconcile: %s: %s", file, err)
+		}
+
+		for _, app := range apps {
+			err = validation.Application(app, validation.Options{AllowReserved: true})
+			if err != nil {
+				return nil, fmt.Errorf("reconcile: %s: %s", file, err)
+			}
+			if source, found := sources[app.Id]; found {
+				return nil, fmt.Errorf("reconcile: application %s is defined in %s and %s", app.Id, source, file)
+			}
+			sources[app.Id] = file
+			definitions = append(definitions, &Definition{Source: file, Application: app})
+		}
+	}
+	return definitions, nil
+}
+
+func isDefinitionFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+func loadFile(file string) ([]*ome.Application, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext == ".yaml" || ext == ".yml" {
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var apps []*ome.Application
+		err = json.Unmarshal(data, &apps)
+		return apps, err
+	}
+
+	app := new(ome.Application)
+	err = json.Unmarshal(data, app)
+	return []*ome.Application{app}, err
+}
+
+func yamlToJSON(data []byte) ([]byte, error) {
+	var o interface{}
+	err := yaml.Unmarshal(data, &o)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonValue(o))
+}
+
+// jsonValue converts the maps decoded by yaml into maps that can be encoded in JSON
+func jsonValue(o interface{}) interface{} {
+	switch v := o.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Change is a planned modification of an application
+type Change struct {
+	Action        string
+	ApplicationID string
+	Source        string
+	// Fields lists the modified fields of an update
+	Fields []string
+
+	application *ome.Application
+}
+
+// Options adjust the reconciliation rules
+type Options struct {
+	// AdoptPrivileged allows adopting applications with a reserved ID or the Root or Master level
+	AdoptPrivileged bool
+}
+
+// Plan lists the changes that bring the registry in line with the definitions
+type Plan struct {
+	Changes []*Change
+}
+
+// NewPlan compares the definitions with the registry applications
+func NewPlan(stores *Stores, definitions []*Definition, opts Options) (*Plan, error) {
+	managed, err := stores.Managed.GetManaged()
+	if err != nil {
+		return nil, err
+	}
+	isManaged := map[string]bool{}
+	for _, m := range managed {
+		isManaged[m.ID] = true
+	}
+
+	plan := &Plan{}
+	defined := map[string]bool{}
+	for _, d := range definitions {
+		defined[d.Application.Id] = true
+		change := &Change{
+			ApplicationID: d.Application.Id,
+			Source:        d.Source,
+			application:   d.Application,
+		}
+
+		existing, err := stores.Applications.GetApplication(d.Application.Id)
+		if err != nil && !bome.IsNotFound(err) {
+			return nil, err
+		}
+
+		if existing == nil {
+			change.Action = ActionCreate
+		} else {
+			change.application = withStoredValues(d.Application, existing)
+			change.Fields, err = changedFields(existing, change.application)
+			if err != nil {
+				return nil, err
+			}
+
+			if !isManaged[d.Application.Id] {
+				if !opts.AdoptPrivileged && privileged(existing) {
+					return nil, fmt.Errorf("reconcile: %s: application %s is privileged and cannot be adopted without the adopt privileged option", d.Source, existing.Id)
+				}
+				change.Action = ActionAdopt
+			} else if len(change.Fields) > 0 {
+				change.Action = ActionUpdate
+			} else {
+				continue
+			}
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	for _, m := range managed {
+		if !defined[m.ID] {
+			plan.Changes = append(plan.Changes, &Change{
+				Action:        ActionDelete,
+				ApplicationID: m.ID,
+				Source:        m.Source,
+			})
+		}
+	}
+	return plan, nil
+}
+
+func privileged(a *ome.Application) bool {
+	return validation.IsReserved(a.Id) || a.Level == ome.ApplicationLevel_Root || a.Level == ome.ApplicationLevel_Master
+}
+
+func withStoredValues(defined *ome.Application, existing *ome.Application) *ome.Application {
+	a := proto.Clone(defined).(*ome.Application)
+	if a.Secret == "" {
+		a.Secret = existing.Secret
+	}
+	if a.Info != nil && existing.Info != nil {
+		if a.Info.CreatedAt == 0 {
+			a.Info.CreatedAt = existing.Info.CreatedAt
+		}
+		if a.Info.CreatedBy == "" {
+			a.Info.CreatedBy = existing.Info.CreatedBy
+		}
+	}
+	return a
+}
+
+// changedFields lists the modified fields. Secrets are never reported
+func changedFields(existing *ome.Application, defined *ome.Application) ([]string, error) {
+	before, err := jsonFields(existing)
+	if err != nil {
+		return nil, err
+	}
+	after, err := jsonFields(defined)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(before, "secret")
+	delete(after, "secret")
+	for name := range before {
+		if _, found := after[name]; !found {
+			after[name] = nil
+		}
+	}
+
+	var fields []string
+	for name, value := range after {
+		if !reflect.DeepEqual(before[name], value) {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func jsonFields(app *ome.Application) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(app)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	err = json.Unmarshal(encoded, &fields)
+	return fields, err
+}
+
+// Empty tells whether the registry already matches the definitions
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Print writes a human readable description of the plan in w
+func (p *Plan) Print(w io.Writer) {
+	if p.Empty() {
+		_, _ = fmt.Fprintln(w, "no changes, applications are up to date")
+		return
+	}
+
+	symbols := map[string]string{
+		ActionCreate: "+",
+		ActionUpdate: "~",
+		ActionAdopt:  "~",
+		ActionDelete: "-",
+	}
+
+	counts := map[string]int{}
+	for _, c := range p.Changes {
+		counts[c.Action]++
+		line := fmt.Sprintf("%s %s [%s]", symbols[c.Action], c.ApplicationID, c.Source)
+		if c.Action == ActionAdopt {
+			line += " adopted"
+		}
+		if len(c.Fields) > 0 {
+			line += fmt.Sprintf(" %v", c.Fields)
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+
+	_, _ = fmt.Fprintf(w, "\n%d to create, %d to update, %d to adopt, %d to delete\n",
+		counts[ActionCreate], counts[ActionUpdate], counts[ActionAdopt], counts[ActionDelete])
+}
+
+// Apply runs the plan changes against the registry. Applying the same plan twice has no further effect
+func (p *Plan) Apply(stores *Stores) error {
+	for _, c := range p.Changes {
+		var err error
+		switch c.Action {
+		case ActionCreate, ActionUpdate, ActionAdopt:
+			err = stores.Applications.SaveApplication(c.application)
+			if err == nil {
+				err = stores.Managed.SaveManaged(&dao.ManagedApplication{
+					ID:        c.ApplicationID,
+					Source:    c.Source,
+					AppliedAt: time.Now().Unix(),
+				})
+			}
+
+		case ActionDelete:
+			err = stores.Applications.DeleteApplication(c.ApplicationID)
+		}
+
+		if err != nil {
+			return fmt.Errorf("reconcile: could not %s application %s: %s", c.Action, c.ApplicationID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/common/utils/log"
+	"github.com/omecodes/libome"
+)
+
+// OAuth error codes used by the client authentication endpoints (RFC 6749 section 5.2)
+const (
+	errorInvalidRequest = "invalid_request"
+	errorInvalidClient  = "invalid_client"
+)
+
+// authenticatedClient is the result of a successful OAuth client authentication
+type authenticatedClient struct {
+	Method      string
+	Application *ome.Application
+	Profile     *oauth.ClientProfile
+}
+
+type clientAuthError struct {
+	code        string
+	description string
+	basic       bool
+}
+
+func (e *clientAuthError) Error() string {
+	return e.code + ": " + e.description
+}
+
+func invalidClient(description string, basic bool) *clientAuthError {
+	return &clientAuthError{code: errorInvalidClient, description: description, basic: basic}
+}
+
+func writeClientAuthError(w http.ResponseWriter, err error) {
+	authErr, ok := err.(*clientAuthError)
+	if !ok {
+		writeError(w, err)
+		return
+	}
+
+	status := http.StatusBadRequest
+	if authErr.code == errorInvalidClient {
+		status = http.StatusUnauthorized
+		if authErr.basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="app-registry"`)
+		}
+	}
+	httpx.WriteJSON(w, status, &oauth.ErrorResponse{
+		Code:        authErr.code,
+		Description: authErr.description,
+	})
+}
+
+// verifySecret compares secret with the application secret in constant time. Applications without secret never match
+func verifySecret(a *ome.Application, secret string) bool {
+	return a.Secret != "" && secureCompare(a.Secret, secret)
+}
+
+// authenticateClient authenticates the OAuth client of the request
+func (s *Server) authenticateClient(r *http.Request) (*authenticatedClient, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, &clientAuthError{code: errorInvalidRequest, description: "could not parse request form"}
+	}
+
+	var (
+		method   string
+		clientID string
+		secret   string
+	)
+
+	basicID, basicSecret, hasBasic := r.BasicAuth()
+	hasPost := r.PostForm.Get("client_secret") != ""
+	assertion := r.PostForm.Get("client_assertion")
+	hasAssertion := assertion != ""
+
+	// tls_client_auth (RFC 8705 section 2): the client only sends its id and presents a bound certificate
+	var clientCert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		clientCert = r.TLS.VerifiedChains[0][0]
+	}
+	hasCert := clientCert != nil && !hasBasic && !hasPost && !hasAssertion && r.PostForm.Get("client_id") != ""
+
+	used := 0
+	for _, b := range []bool{hasBasic, hasPost, hasAssertion, hasCert} {
+		if b {
+			used++
+		}
+	}
+	if used == 0 {
+		return nil, invalidClient("client authentication is required", true)
+	}
+	if used > 1 {
+		return nil, &clientAuthError{code: errorInvalidRequest, description: "only one client authentication method can be used"}
+	}
+
+	switch {
+	case hasBasic:
+		method = oauth.AuthMethodClientSecretBasic
+		// RFC 6749 section 2.3.1: client id and secret are form-urlencoded before being used as basic credentials
+		clientID, err = url.QueryUnescape(basicID)
+		if err == nil {
+			secret, err = url.QueryUnescape(basicSecret)
+		}
+		if err != nil {
+			return nil, invalidClient("malformed basic credentials", true)
+		}
+
+	case hasPost:
+		method = oauth.AuthMethodClientSecretPost
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+
+	case hasCert:
+		method = oauth.AuthMethodTLSClientAuth
+		clientID = r.PostForm.Get("client_id")
+
+	default:
+		method = oauth.AuthMethodPrivateKeyJWT
+		if r.PostForm.Get("client_assertion_type") != oauth.ClientAssertionType {
+			return nil, &clientAuthError{code: errorInvalidRequest, description: "unsupported client_assertion_type"}
+		}
+
+		clientID = r.PostForm.Get("client_id")
+		if clientID == "" {
+			jws, err := oauth.ParseJWS(assertion)
+			if err != nil {
+				return nil, invalidClient("malformed client assertion", false)
+			}
+			claims := new(oauth.ClientAssertionClaims)
+			if err = json.Unmarshal(jws.Payload, claims); err != nil {
+				return nil, invalidClient("malformed client assertion", false)
+			}
+			clientID = claims.Sub
+		}
+	}
+
+	if clientID == "" {
+		return nil, invalidClient("client_id is required", hasBasic)
+	}
+
+	a, err := s.appsDB.GetApplication(clientID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, invalidClient("unknown client", hasBasic)
+		}
+		return nil, err
+	}
+
+	if !a.Activated {
+		return nil, invalidClient("client is not active", hasBasic)
+	}
+
+	profile, err := s.clientProfile(a)
+	if err != nil {
+		return nil, err
+	}
+
+	if !profile.AllowsAuthMethod(method) {
+		return nil, invalidClient("authentication method not allowed for this client", hasBasic)
+	}
+
+	switch method {
+	case oauth.AuthMethodPrivateKeyJWT:
+		keys, err := s.clientKeys(profile)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = s.assertionVerifier(r).Verify(assertion, clientID, keys)
+		if err != nil {
+			log.Info("client assertion rejected", log.Field("client", clientID), log.Err(err))
+			return nil, invalidClient("invalid client assertion", false)
+		}
+
+	case oauth.AuthMethodTLSClientAuth:
+		bound, err := s.handler.applicationByCertificate(clientCert)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if bound == nil || bound.Id != a.Id {
+			return nil, invalidClient("client certificate is not bound to this client", false)
+		}
+
+	default:
+		if !verifySecret(a, secret) {
+			return nil, invalidClient("invalid client credentials", hasBasic)
+		}
+	}
+
+	return &authenticatedClient{
+		Method:      method,
+		Application: a,
+		Profile:     profile,
+	}, nil
+}
+
+// assertionVerifier accepts the registry issuer and the endpoint URL as client assertion audience
+func (s *Server) assertionVerifier(r *http.Request) *oauth.AssertionVerifier {
+	issuer := strings.TrimSuffix(s.issuer, "/")
+	return &oauth.AssertionVerifier{
+		Audiences: []string{issuer, issuer + r.URL.Path},
+		Replay:    s.assertionsReplay,
+	}
+}
+
+// verifyClient is the client credentials verification endpoint
+func (s *Server) verifyClient(w http.ResponseWriter, r *http.Request) {
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeClientAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"active":                     true,
+		"client_id":                  client.Application.Id,
+		"token_endpoint_auth_method": client.Method,
+		"client_type":                client.Profile.ClientType,
+		"level":                      client.Application.Level.String(),
+		"scope":                      strings.Join(client.Profile.Scopes, " "),
+	})
+}
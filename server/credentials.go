@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+
+	"github.com/omecodes/common/utils/log"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// gRPC headers under which RegisterApplication returns the credentials it generated. They are only returned once
+const (
+	ApplicationIDMetadata     = "x-app-id"
+	ApplicationSecretMetadata = "x-app-secret"
+)
+
+// DefaultMaxApplicationsPerUser is the number of applications a user can register through a master application
+const DefaultMaxApplicationsPerUser = 20
+
+// generateCredentials gives a an ID when it has none and a secret when it has none, and returns the generated values
+func generateCredentials(a *ome.Application) (metadata.MD, error) {
+	md := metadata.MD{}
+	if a.Id == "" {
+		id, err := randomID(16)
+		if err != nil {
+			return nil, err
+		}
+		a.Id = id
+		md.Set(ApplicationIDMetadata, id)
+	}
+
+	if a.Secret == "" {
+		secret, err := randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+		a.Secret = secret
+		md.Set(ApplicationSecretMetadata, secret)
+	}
+
+	if a.Info != nil && a.Info.ApplicationId == "" {
+		a.Info.ApplicationId = a.Id
+	}
+	return md, nil
+}
+
+// sendCredentials returns the generated credentials in the response headers
+func sendCredentials(ctx context.Context, applicationID string, md metadata.MD) {
+	if md.Len() == 0 {
+		return
+	}
+	err := grpc.SetHeader(ctx, md)
+	if err != nil {
+		log.Error("could not send generated credentials", log.Err(err), log.Field("app", applicationID))
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/utils/log"
+	"github.com/omecodes/libome"
+)
+
+// Credentials of the root application created in dev mode
+const (
+	DevApplicationID     = "dev"
+	DevApplicationSecret = "dev-secret"
+)
+
+// DevHost is the loopback address dev mode binds the gateway and the gRPC node to
+const DevHost = "127.0.0.1"
+
+func warnDevMode() {
+	banner := strings.Repeat("*", 72)
+	log.Warning(banner)
+	log.Warning("DEV MODE: plain HTTP and insecure gRPC, no CA, no registry, well-known root credentials")
+	log.Warning("DEV MODE: never run it in production", log.Field("application", DevApplicationID), log.Field("secret", DevApplicationSecret))
+	log.Warning(banner)
+}
+
+// devApplication returns the dev root application, which only exists in memory
+func devApplication() *ome.Application {
+	return &ome.Application{
+		Id:               DevApplicationID,
+		Activated:        true,
+		Level:            ome.ApplicationLevel_Root,
+		Secret:           DevApplicationSecret,
+		OauthCallbackUrl: "http://localhost/callback",
+		Info: &ome.AppInfo{
+			ApplicationId: DevApplicationID,
+			CreatedBy:     "ome",
+			Label:         "Development",
+			Description:   "Root application of the dev mode",
+		},
+	}
+}
+
+// purgeDevApplication deletes the dev root application previous versions saved in the database
+func (s *Server) purgeDevApplication() error {
+	a, err := s.appsDB.GetApplication(DevApplicationID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if a.Level != ome.ApplicationLevel_Root || !verifySecret(a, DevApplicationSecret) {
+		return nil
+	}
+	log.Warning("deleting the stored dev root application", log.Field("application", DevApplicationID))
+	return s.appsDB.DeleteApplication(DevApplicationID)
+}
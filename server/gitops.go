@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/omecodes/app-registry/reconcile"
+	"github.com/omecodes/common/utils/log"
+)
+
+const DefaultAppsSyncInterval = time.Minute
+
+// appsSync periodically reconciles the applications with the definitions of a local directory
+type appsSync struct {
+	dir      string
+	interval time.Duration
+	stores   *reconcile.Stores
+	options  reconcile.Options
+}
+
+func (a *appsSync) run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		err := a.sync()
+		if err != nil {
+			log.Error("apps sync: could not reconcile applications", log.Err(err), log.Field("dir", a.dir))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *appsSync) sync() error {
+	definitions, err := reconcile.Load(a.dir)
+	if err != nil {
+		return err
+	}
+
+	plan, err := reconcile.NewPlan(a.stores, definitions, a.options)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range plan.Changes {
+		log.Info("apps sync", log.Field("action", c.Action), log.Field("application", c.ApplicationID), log.Field("source", c.Source))
+	}
+	return plan.Apply(a.stores)
+}
@@ -2,16 +2,19 @@ package server
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/md5"
-	"crypto/sha256"
 	"encoding/hex"
 	"github.com/gorilla/sessions"
 	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/validation"
 	"github.com/omecodes/bome"
 	"github.com/omecodes/common/errors"
 	"github.com/omecodes/common/grpcx"
+	"github.com/omecodes/common/utils/log"
 	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"time"
 )
 
@@ -19,7 +22,26 @@ type gRPCHandler struct {
 	ome.UnimplementedApplicationsServer
 	cookieStore   *sessions.CookieStore
 	appsDB        dao.ApplicationsDB
+	clientsDB     dao.ClientProfilesDB
+	keysDB        dao.KeysDB
 	translationDB *bome.DoubleMap
+	// verificationsDB is optional, the verified domains are not returned without it
+	verificationsDB dao.VerificationsDB
+	webhooksDB      dao.WebhooksDB
+	identity        *identityIssuer
+	// devMode accepts the in-memory dev root application credentials
+	devMode bool
+	// draining is closed when the server starts shutting down
+	draining <-chan struct{}
+
+	// serviceFingerprint is the fingerprint of the registry own certificate
+	serviceFingerprint string
+	// fingerprintKey keys the application secret fingerprints
+	fingerprintKey []byte
+	// maxAppsPerUser is the number of applications a user can register through a master application
+	maxAppsPerUser int
+	// admins are the users allowed to review the promotion requests through a master application
+	admins []string
 }
 
 func (g *gRPCHandler) userToken(ctx context.Context, required bool) (*ome.JWT, error) {
@@ -49,18 +71,36 @@ func (g *gRPCHandler) userToken(ctx context.Context, required bool) (*ome.JWT, e
 func (g *gRPCHandler) appCredentials(ctx context.Context) (*ome.Application, error) {
 	cred := ome.ProxyCredentialsFromContext(ctx)
 	if cred == nil {
-		return nil, errors.Forbidden
+		cert := peerCertificate(ctx)
+		if cert == nil {
+			return nil, errors.Forbidden
+		}
+
+		a, err := g.applicationByCertificate(cert)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil, errors.Forbidden
+			}
+			return nil, err
+		}
+		return a, nil
 	}
 
-	a, err := g.appsDB.GetApplication(cred.Key)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			return nil, errors.Forbidden
+	var a *ome.Application
+	if g.devMode && cred.Key == DevApplicationID {
+		a = devApplication()
+	} else {
+		var err error
+		a, err = g.appsDB.GetApplication(cred.Key)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil, errors.Forbidden
+			}
+			return nil, err
 		}
-		return nil, err
 	}
 
-	if a.Secret != cred.Secret {
+	if !verifySecret(a, cred.Secret) {
 		return nil, errors.Forbidden
 	}
 
@@ -73,6 +113,14 @@ func (g *gRPCHandler) RegisterApplication(ctx context.Context, in *ome.RegisterA
 		return nil, err
 	}
 
+	if in.Application == nil {
+		return nil, errors.BadInput
+	}
+
+	if a.Level == ome.ApplicationLevel_Root {
+		return g.saveAsRoot(ctx, in.Application)
+	}
+
 	if a.Level != ome.ApplicationLevel_Master {
 		return nil, errors.Unauthorized
 	}
@@ -82,42 +130,131 @@ func (g *gRPCHandler) RegisterApplication(ctx context.Context, in *ome.RegisterA
 		return nil, err
 	}
 
-	if in.Application.Id == "ome" && token.Claims.Sub != "ome" {
-		return nil, errors.Unauthorized
+	application := in.Application
+	if application.Info == nil {
+		application.Info = &ome.AppInfo{}
 	}
 
-	in.Application.Info.CreatedBy = token.Claims.Sub
-	in.Application.Info.CreatedAt = time.Now().Unix()
-	if in.Application.Level != ome.ApplicationLevel_External {
-		in.Application.Level = ome.ApplicationLevel_External
+	var existing *ome.Application
+	if application.Id != "" {
+		existing, err = g.appsDB.GetApplication(application.Id)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
 	}
-	return &ome.RegisterApplicationResponse{}, g.appsDB.SaveApplication(in.Application)
-}
 
-func (g *gRPCHandler) DeRegister(ctx context.Context, in *ome.DeRegisterApplicationRequest) (*ome.DeRegisterApplicationResponse, error) {
-	a, err := g.appCredentials(ctx)
+	if existing != nil {
+		// users only update the applications they created
+		if existing.Info == nil || existing.Info.CreatedBy != token.Claims.Sub {
+			return &ome.RegisterApplicationResponse{IdExists: true}, nil
+		}
+	}
+
+	err = validation.Application(application, validation.Options{AllowReserved: token.Claims.Sub == "ome", StrongSecret: true, GeneratedID: existing == nil})
 	if err != nil {
 		return nil, err
 	}
 
-	if a.Level != ome.ApplicationLevel_Master {
-		return nil, errors.Unauthorized
+	var generated metadata.MD
+	if existing != nil {
+		if application.Secret == "" {
+			application.Secret = existing.Secret
+		}
+		application.Info.CreatedAt = existing.Info.CreatedAt
+		// the level only changes through promotions
+		application.Level = existing.Level
+	} else {
+		generated, err = generateCredentials(application)
+		if err != nil {
+			return nil, err
+		}
+		application.Info.CreatedAt = time.Now().Unix()
+		application.Level = ome.ApplicationLevel_External
 	}
 
-	token, err := g.userToken(ctx, true)
+	application.Info.CreatedBy = token.Claims.Sub
+
+	if existing != nil {
+		err = g.appsDB.SaveApplication(application)
+	} else {
+		err = g.appsDB.CreateUserApplication(application, g.maxAppsPerUser)
+	}
+	if err == dao.ErrApplicationsLimit {
+		return nil, status.Errorf(codes.ResourceExhausted, "a user can register at most %d applications", g.maxAppsPerUser)
+	}
 	if err != nil {
 		return nil, err
 	}
-	targetApp, err := g.appsDB.GetApplication(in.ApplicationId)
+	sendCredentials(ctx, application.Id, generated)
+	return &ome.RegisterApplicationResponse{}, nil
+}
+
+// saveAsRoot saves an application registered by a root application
+func (g *gRPCHandler) saveAsRoot(ctx context.Context, application *ome.Application) (*ome.RegisterApplicationResponse, error) {
+	var (
+		existing *ome.Application
+		err      error
+	)
+	if application.Id != "" {
+		existing, err = g.appsDB.GetApplication(application.Id)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	if application.Info == nil {
+		application.Info = &ome.AppInfo{ApplicationId: application.Id}
+	}
+
+	err = validation.Application(application, validation.Options{AllowReserved: true, StrongSecret: true, GeneratedID: existing == nil})
 	if err != nil {
 		return nil, err
 	}
 
-	if targetApp.Info.CreatedBy != token.Claims.Sub {
-		return nil, errors.Unauthorized
+	var generated metadata.MD
+	if existing != nil {
+		if application.Secret == "" {
+			application.Secret = existing.Secret
+		}
+		if existing.Info != nil {
+			application.Info.CreatedBy = existing.Info.CreatedBy
+			application.Info.CreatedAt = existing.Info.CreatedAt
+		}
+	} else {
+		generated, err = generateCredentials(application)
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := g.userToken(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		if token != nil {
+			application.Info.CreatedBy = token.Claims.Sub
+		}
+		application.Info.CreatedAt = time.Now().Unix()
+	}
+
+	err = g.appsDB.SaveApplication(application)
+	if err != nil {
+		return nil, err
 	}
+	sendCredentials(ctx, application.Id, generated)
+	return &ome.RegisterApplicationResponse{}, nil
+}
 
-	return &ome.DeRegisterApplicationResponse{}, g.appsDB.DeleteApplication(in.ApplicationId)
+func (g *gRPCHandler) DeRegister(ctx context.Context, in *ome.DeRegisterApplicationRequest) (*ome.DeRegisterApplicationResponse, error) {
+	_, err := g.managedApplication(ctx, in.ApplicationId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.appsDB.DeleteApplication(in.ApplicationId)
+	if err != nil {
+		return nil, err
+	}
+	return &ome.DeRegisterApplicationResponse{}, nil
 }
 
 func (g *gRPCHandler) CheckIfExists(ctx context.Context, in *ome.CheckIfExistsRequest) (*ome.CheckIfExistsResponse, error) {
@@ -138,7 +275,7 @@ func (g *gRPCHandler) ListApplications(in *ome.ListApplicationsRequest, stream o
 	}
 
 	if a.Level != ome.ApplicationLevel_Root && a.Level != ome.ApplicationLevel_Master {
-		a.Secret = ""
+		redactApplication(a)
 		return stream.Send(a)
 	}
 
@@ -166,7 +303,7 @@ func (g *gRPCHandler) ListApplications(in *ome.ListApplicationsRequest, stream o
 			return err
 		}
 
-		a.Secret = ""
+		redactApplication(a)
 		err = stream.Send(a)
 		if err != nil {
 			return err
@@ -211,18 +348,37 @@ func (g *gRPCHandler) GetApplication(ctx context.Context, in *ome.GetApplication
 		return nil, err
 	}
 
-	if !isARootApp && !selfDetails && response.Application.Info.CreatedBy != user {
+	createdByUser := user != "" && response.Application.Info != nil && response.Application.Info.CreatedBy == user
+	if !isARootApp && !selfDetails && !createdByUser {
 		return nil, errors.Unauthorized
 	}
 
-	if response.Application.Id != in.ApplicationId {
-		h := md5.Sum([]byte(response.Application.Secret))
-		response.Application.Secret = string(h[:])
-	} else {
-		response.Application.Secret = ""
+	// the fingerprint is only given to the owners: the application itself and the user who created it
+	if selfDetails || createdByUser {
+		fingerprint := secretFingerprint(g.fingerprintKey, response.Application)
+		if fingerprint != "" {
+			err = grpc.SetHeader(ctx, metadata.Pairs(SecretFingerprintMetadata, fingerprint))
+			if err != nil {
+				log.Error("could not send secret fingerprint", log.Err(err), log.Field("app", in.ApplicationId))
+			}
+		}
 	}
 
-	return response, err
+	if g.verificationsDB != nil {
+		domain, err := g.verifiedDomain(response.Application)
+		if err != nil {
+			return nil, err
+		}
+		if domain != "" {
+			err = grpc.SetHeader(ctx, metadata.Pairs(ApplicationVerifiedDomainMetadata, domain))
+			if err != nil {
+				log.Error("could not send verified domain", log.Err(err), log.Field("app", in.ApplicationId))
+			}
+		}
+	}
+
+	redactApplication(response.Application)
+	return response, nil
 }
 
 func (g *gRPCHandler) VerifyAuthenticationChallenge(ctx context.Context, in *ome.VerifyAuthenticationChallengeRequest) (*ome.VerifyAuthenticationChallengeResponse, error) {
@@ -233,29 +389,75 @@ func (g *gRPCHandler) VerifyAuthenticationChallenge(ctx context.Context, in *ome
 		return nil, err
 	}
 
-	h := hmac.New(sha256.New, []byte(a.Secret))
+	if a.Secret == "" {
+		return response, nil
+	}
+
 	nonceBytes, err := hex.DecodeString(in.Nonce)
 	if err != nil {
 		return nil, err
 	}
-	h.Write(nonceBytes)
 
-	calculated := hex.EncodeToString(h.Sum(nil))
-	response.Verified = calculated == in.Challenge
+	calculated := challengeSignature(a.Secret, nonceBytes)
+	response.Verified = secureCompare(calculated, in.Challenge)
+	if response.Verified && g.identity != nil {
+		token, _, err := g.identity.Issue(a, "challenge")
+		if err != nil {
+			log.Error("could not issue identity token", log.Err(err), log.Field("app", a.Id))
+		} else if err = grpc.SetHeader(ctx, metadata.Pairs(identityTokenMetadata, token)); err != nil {
+			log.Error("could not send identity token", log.Err(err), log.Field("app", a.Id))
+		}
+	}
 	return response, nil
 }
 
+// managedApplication loads the application identified by applicationID if the caller is allowed to manage it
+func (g *gRPCHandler) managedApplication(ctx context.Context, applicationID string) (*ome.Application, error) {
+	a, err := g.appCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Level != ome.ApplicationLevel_Root && a.Level != ome.ApplicationLevel_Master {
+		return nil, errors.Unauthorized
+	}
+
+	target, err := g.appsDB.GetApplication(applicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Level == ome.ApplicationLevel_Root {
+		return target, nil
+	}
+
+	token, err := g.userToken(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Info == nil || target.Info.CreatedBy != token.Claims.Sub {
+		return nil, errors.Unauthorized
+	}
+	return target, nil
+}
+
 func (g *gRPCHandler) mustEmbedUnimplementedApplicationsServer() {
 
 }
 
-func NewApplicationServerGRPCHandler(appsDB dao.ApplicationsDB, store *sessions.CookieStore, translationDB *bome.DoubleMap) ome.ApplicationsServer {
-	handler := &gRPCHandler{
+func newGRPCHandler(appsDB dao.ApplicationsDB, clientsDB dao.ClientProfilesDB, keysDB dao.KeysDB, store *sessions.CookieStore, translationDB *bome.DoubleMap) *gRPCHandler {
+	return &gRPCHandler{
 		cookieStore:   store,
 		appsDB:        appsDB,
+		clientsDB:     clientsDB,
+		keysDB:        keysDB,
 		translationDB: translationDB,
 	}
+}
+
+func NewApplicationServerGRPCHandler(appsDB dao.ApplicationsDB, clientsDB dao.ClientProfilesDB, keysDB dao.KeysDB, store *sessions.CookieStore, translationDB *bome.DoubleMap) ome.ApplicationsServer {
 	var o interface{}
-	o = handler
+	o = newGRPCHandler(appsDB, clientsDB, keysDB, store, translationDB)
 	return o.(ome.ApplicationsServer)
 }
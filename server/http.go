@@ -1,30 +1,89 @@
 package server
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/app-registry/validation"
 	"github.com/omecodes/common/errors"
 	"github.com/omecodes/common/httpx"
 	"github.com/omecodes/common/utils/log"
 	"github.com/omecodes/libome"
+	"google.golang.org/grpc/metadata"
 )
 
 const (
-	APIRoute  = "/api/"
-	InfoRoute = "/info"
+	APIRoute           = "/api/"
+	InfoRoute          = "/info"
+	ClientProfileRoute = "/applications/{id}/oauth"
+	KeysRoute          = "/applications/{id}/keys"
+	KeyRoute           = "/applications/{id}/keys/{kid}"
+	EventsRoute        = "/applications/events"
+
+	ApplicationPromotionsRoute = "/applications/{id}/promotions"
+	VerificationRoute          = "/applications/{id}/verification"
+	VerificationCheckRoute     = "/applications/{id}/verification/check"
+	PromotionsRoute            = "/promotions"
+	PromotionApprovalRoute     = "/promotions/{id}/approve"
+	PromotionRejectionRoute    = "/promotions/{id}/reject"
+
+	RegistrationRoute       = "/oauth/register"
+	RegistrationClientRoute = "/oauth/register/{id}"
+	ClientVerificationRoute = "/oauth/client/verify"
+	IdentityTokenRoute      = "/oauth/identity"
+	JWKSRoute               = "/.well-known/jwks.json"
+
+	WebhooksRoute    = "/webhooks"
+	WebhookRoute     = "/webhooks/{id}"
+	DeadLettersRoute = "/webhooks/dead-letters"
+	RedeliveryRoute  = "/webhooks/dead-letters/{id}/redeliver"
 )
 
+// gatewayCookieMetadata is the metadata key under which grpc-gateway forwards the HTTP cookie header
+const gatewayCookieMetadata = "grpcgateway-cookie"
+
 func (s *Server) createRouter(m *runtime.ServeMux) http.Handler {
 	r := mux.NewRouter()
+	r.HandleFunc(EventsRoute, s.streamApplicationEvents).Methods(http.MethodGet)
+	r.HandleFunc(ClientProfileRoute, s.getClientProfile).Methods(http.MethodGet)
+	r.HandleFunc(ClientProfileRoute, s.saveClientProfile).Methods(http.MethodPut)
+	r.HandleFunc(ClientProfileRoute, s.deleteClientProfile).Methods(http.MethodDelete)
+	r.HandleFunc(KeysRoute, s.listApplicationKeys).Methods(http.MethodGet)
+	r.HandleFunc(KeysRoute, s.addApplicationKey).Methods(http.MethodPost)
+	r.HandleFunc(KeyRoute, s.deleteApplicationKey).Methods(http.MethodDelete)
+	r.HandleFunc(RegistrationRoute, s.registerClient).Methods(http.MethodPost)
+	r.HandleFunc(RegistrationClientRoute, s.readRegisteredClient).Methods(http.MethodGet)
+	r.HandleFunc(RegistrationClientRoute, s.updateRegisteredClient).Methods(http.MethodPut)
+	r.HandleFunc(RegistrationClientRoute, s.deleteRegisteredClient).Methods(http.MethodDelete)
+	r.HandleFunc(ClientVerificationRoute, s.verifyClient).Methods(http.MethodPost)
+	r.HandleFunc(IdentityTokenRoute, s.issueIdentityToken).Methods(http.MethodPost)
+	r.HandleFunc(JWKSRoute, s.serveJWKS).Methods(http.MethodGet)
+	r.HandleFunc(ApplicationPromotionsRoute, s.listApplicationPromotions).Methods(http.MethodGet)
+	r.HandleFunc(ApplicationPromotionsRoute, s.requestPromotion).Methods(http.MethodPost)
+	r.HandleFunc(VerificationRoute, s.getVerification).Methods(http.MethodGet)
+	r.HandleFunc(VerificationRoute, s.requestVerification).Methods(http.MethodPost)
+	r.HandleFunc(VerificationCheckRoute, s.checkVerification).Methods(http.MethodPost)
+	r.HandleFunc(PromotionsRoute, s.listPromotions).Methods(http.MethodGet)
+	r.HandleFunc(PromotionApprovalRoute, s.approvePromotion).Methods(http.MethodPost)
+	r.HandleFunc(PromotionRejectionRoute, s.rejectPromotion).Methods(http.MethodPost)
+	r.HandleFunc(WebhooksRoute, s.listWebhooks).Methods(http.MethodGet)
+	r.HandleFunc(WebhooksRoute, s.createWebhook).Methods(http.MethodPost)
+	r.HandleFunc(DeadLettersRoute, s.listDeadLetters).Methods(http.MethodGet)
+	r.HandleFunc(RedeliveryRoute, s.redeliverWebhook).Methods(http.MethodPost)
+	r.HandleFunc(WebhookRoute, s.deleteWebhook).Methods(http.MethodDelete)
 	r.PathPrefix(APIRoute).Handler(m)
 	r.HandleFunc(InfoRoute, s.serveInfo)
+	h := s.drainingMiddleware(r)
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		start := time.Now()
-		r.ServeHTTP(w, req)
+		h.ServeHTTP(w, req)
 		duration := time.Since(start)
 		log.Info(
 			req.Method+" "+req.RequestURI,
@@ -37,6 +96,13 @@ func (s *Server) createRouter(m *runtime.ServeMux) http.Handler {
 
 func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request) {
 	info := &ome.Info{}
+	if s.config.Info != nil || s.config.Box == nil {
+		if s.config.Info != nil {
+			info = s.config.Info
+		}
+		httpx.WriteJSON(w, http.StatusOK, info)
+		return
+	}
 	registry := s.config.Box.Registry()
 
 	address, err := s.config.Box.ServiceAddress("ca")
@@ -102,3 +168,66 @@ func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request) {
 
 	httpx.WriteJSON(w, http.StatusOK, info)
 }
+
+// baseURL returns the scheme and host the request was sent to
+func baseURL(r *http.Request) string {
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// requestContext builds a context carrying the request credentials like the gRPC interceptors do
+func requestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+
+	authorization := r.Header.Get("Proxy-Authorization")
+	if strings.HasPrefix(authorization, "Basic ") {
+		decodedBytes, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+		if err == nil {
+			splits := strings.SplitN(string(decodedBytes), ":", 2)
+			cred := &ome.ProxyCredentials{Key: splits[0]}
+			if len(splits) > 1 {
+				cred.Secret = splits[1]
+			}
+			ctx = ome.ContextWithProxyCredentials(ctx, cred)
+		}
+	}
+
+	md := metadata.MD{}
+	if cookie := r.Header.Get("Cookie"); cookie != "" {
+		md.Set(gatewayCookieMetadata, cookie)
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if fieldErr, ok := err.(*oauth.InvalidFieldError); ok {
+		httpx.WriteJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "invalid_client_metadata",
+			"error_description": fieldErr.Error(),
+		})
+		return
+	}
+
+	if validationErr, ok := err.(*validation.Error); ok {
+		httpx.WriteJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":             "invalid_application",
+			"error_description": validationErr.Error(),
+			"fields":            validationErr.Fields,
+		})
+		return
+	}
+
+	if errors.IsNotFound(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	status := errors.HttpStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Error("request failed", log.Err(err))
+	}
+	w.WriteHeader(status)
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/common/utils/log"
+	"github.com/omecodes/libome"
+)
+
+const (
+	DefaultIdentityTokenTTL  = 5 * time.Minute
+	DefaultKeyRotationPeriod = 24 * time.Hour
+
+	// identityTokenMetadata is the gRPC header under which identity tokens are returned on successful challenge verification
+	identityTokenMetadata = "x-app-identity-token"
+
+	// retirementMargin covers the clock differences between registry instances
+	retirementMargin = time.Minute
+)
+
+// identityIssuer signs application identity tokens with keys stored in the database
+type identityIssuer struct {
+	sync.Mutex
+	issuer   string
+	ttl      time.Duration
+	rotation time.Duration
+	db       dao.SigningKeysDB
+	parsed   map[string]*ecdsa.PrivateKey
+}
+
+func newIdentityIssuer(db dao.SigningKeysDB, issuer string, ttl time.Duration, rotation time.Duration) *identityIssuer {
+	if ttl <= 0 {
+		ttl = DefaultIdentityTokenTTL
+	}
+	if rotation <= 0 {
+		rotation = DefaultKeyRotationPeriod
+	}
+	return &identityIssuer{
+		issuer:   issuer,
+		ttl:      ttl,
+		rotation: rotation,
+		db:       db,
+		parsed:   map[string]*ecdsa.PrivateKey{},
+	}
+}
+
+func (i *identityIssuer) privateKey(key *dao.SigningKey) (*ecdsa.PrivateKey, error) {
+	if pk, found := i.parsed[key.ID]; found {
+		return pk, nil
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, errors.New("malformed signing key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an ECDSA key")
+	}
+	i.parsed[key.ID] = pk
+	return pk, nil
+}
+
+func (i *identityIssuer) generateKey() (*dao.SigningKey, error) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomID(8)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &dao.SigningKey{
+		ID:         id,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  time.Now().Unix(),
+	}
+	err = i.db.SaveSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	i.parsed[key.ID] = pk
+	return key, nil
+}
+
+// keys returns the published keys, newest first, rotating them when needed
+func (i *identityIssuer) keys() ([]*dao.SigningKey, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	keys, err := i.db.GetSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a].CreatedAt > keys[b].CreatedAt
+	})
+
+	now := time.Now()
+	if len(keys) == 0 || now.Sub(time.Unix(keys[0].CreatedAt, 0)) > i.rotation {
+		key, err := i.generateKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append([]*dao.SigningKey{key}, keys...)
+	}
+
+	published := keys[:1]
+	for ind := 1; ind < len(keys); ind++ {
+		replacedAt := time.Unix(keys[ind-1].CreatedAt, 0)
+		if now.Sub(replacedAt) < i.ttl+retirementMargin {
+			published = append(published, keys[ind])
+			continue
+		}
+
+		err = i.db.DeleteSigningKey(keys[ind].ID)
+		if err != nil {
+			log.Error("could not delete retired signing key", log.Err(err), log.Field("kid", keys[ind].ID))
+		}
+		delete(i.parsed, keys[ind].ID)
+	}
+	return published, nil
+}
+
+// JWKS returns the public keys that verify the identity tokens
+func (i *identityIssuer) JWKS() (*oauth.JWKSet, error) {
+	keys, err := i.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	set := &oauth.JWKSet{}
+	for _, key := range keys {
+		i.Lock()
+		pk, err := i.privateKey(key)
+		i.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		jwk, err := oauth.NewJWK(key.ID, pk.Public())
+		if err != nil {
+			return nil, err
+		}
+		jwk.Alg = "ES256"
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// Issue signs an identity token for the application authenticated with the given method
+func (i *identityIssuer) Issue(a *ome.Application, method string) (string, time.Time, error) {
+	keys, err := i.keys()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	i.Lock()
+	pk, err := i.privateKey(keys[0])
+	i.Unlock()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	jti, err := randomID(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	exp := now.Add(i.ttl)
+	payload, err := json.Marshal(&oauth.IdentityClaims{
+		Iss:        i.issuer,
+		Sub:        a.Id,
+		Iat:        now.Unix(),
+		Exp:        exp.Unix(),
+		Jti:        jti,
+		Level:      a.Level.String(),
+		Activated:  a.Activated,
+		AuthMethod: method,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, err := oauth.SignES256(pk, &oauth.JWSHeader{Kid: keys[0].ID, Typ: oauth.IdentityTokenType}, payload)
+	return token, exp, err
+}
+
+func (s *Server) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	set, err := s.handler.identity.JWKS()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	httpx.WriteJSON(w, http.StatusOK, set)
+}
+
+// issueIdentityToken authenticates the OAuth client of the request and returns a signed identity token for it
+func (s *Server) issueIdentityToken(w http.ResponseWriter, r *http.Request) {
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeClientAuthError(w, err)
+		return
+	}
+
+	token, exp, err := s.handler.identity.Issue(client.Application, client.Method)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpx.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"identity_token": token,
+		"expires_in":     int64(time.Until(exp).Seconds()),
+	})
+}
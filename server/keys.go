@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// certificateFingerprint returns the hex encoded SHA-256 digest of the DER encoded certificate
+func certificateFingerprint(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(h[:])
+}
+
+// keyRegistration is the body of a key registration request. Exactly one of the fields must be set
+type keyRegistration struct {
+	JWK         *oauth.JWK `json:"jwk,omitempty"`
+	Certificate string     `json:"certificate,omitempty"`
+}
+
+// applicationByCertificate returns the application bound to the certificate
+func (g *gRPCHandler) applicationByCertificate(cert *x509.Certificate) (*ome.Application, error) {
+	fingerprint := certificateFingerprint(cert)
+	if fingerprint == g.serviceFingerprint {
+		return nil, errors.NotFound
+	}
+
+	key, err := g.keysDB.FindByFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	return g.appsDB.GetApplication(key.ApplicationID)
+}
+
+// peerCertificate returns the verified client certificate of the gRPC connection, if any
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return tlsInfo.State.VerifiedChains[0][0]
+}
+
+// clientKeys returns the keys that can be used to verify the client assertions of the application
+func (s *Server) clientKeys(profile *oauth.ClientProfile) ([]*oauth.JWK, error) {
+	var keys []*oauth.JWK
+	if profile.JWKS != nil {
+		keys = append(keys, profile.JWKS.Keys...)
+	}
+
+	registered, err := s.keysDB.GetKeys(profile.ApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range registered {
+		if key.Type == dao.KeyTypeJWK {
+			keys = append(keys, key.JWK)
+		}
+	}
+	return keys, nil
+}
+
+// clientCertificateRoots returns the CAs the client certificates are verified with, or nil when there is none
+func (s *Server) clientCertificateRoots() *x509.CertPool {
+	if s.config.Box != nil && s.config.Box.CACertificate() != nil {
+		roots := x509.NewCertPool()
+		roots.AddCert(s.config.Box.CACertificate())
+		return roots
+	}
+
+	if s.gatewayCerts != nil {
+		s.gatewayCerts.mutex.RLock()
+		defer s.gatewayCerts.mutex.RUnlock()
+		return s.gatewayCerts.clientCAs
+	}
+	return nil
+}
+
+func (s *Server) newApplicationKey(applicationID string, registration *keyRegistration) (*dao.ApplicationKey, error) {
+	key := &dao.ApplicationKey{
+		ApplicationID: applicationID,
+		CreatedAt:     time.Now().Unix(),
+	}
+
+	if (registration.JWK != nil) == (registration.Certificate != "") {
+		return nil, &oauth.InvalidFieldError{Field: "key", Reason: "exactly one of jwk or certificate is required"}
+	}
+
+	if registration.JWK != nil {
+		err := registration.JWK.Validate()
+		if err != nil {
+			return nil, &oauth.InvalidFieldError{Field: "jwk", Reason: err.Error()}
+		}
+		key.Type = dao.KeyTypeJWK
+		key.JWK = registration.JWK
+	} else {
+		block, _ := pem.Decode([]byte(registration.Certificate))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, &oauth.InvalidFieldError{Field: "certificate", Reason: "must be a PEM encoded certificate"}
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, &oauth.InvalidFieldError{Field: "certificate", Reason: err.Error()}
+		}
+
+		roots := s.clientCertificateRoots()
+		if roots == nil {
+			return nil, &oauth.InvalidFieldError{Field: "certificate", Reason: "no client CA is configured to verify it"}
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return nil, &oauth.InvalidFieldError{Field: "certificate", Reason: "must be issued by the registry or the client CA"}
+		}
+		key.Type = dao.KeyTypeX509
+		key.Fingerprint = certificateFingerprint(cert)
+	}
+
+	if key.Type == dao.KeyTypeX509 && key.Fingerprint == s.handler.serviceFingerprint {
+		return nil, &oauth.InvalidFieldError{Field: "certificate", Reason: "the registry certificate cannot be bound to an application"}
+	}
+
+	if key.Type == dao.KeyTypeJWK && key.JWK.Kid != "" {
+		key.ID = key.JWK.Kid
+	} else {
+		id, err := randomID(8)
+		if err != nil {
+			return nil, err
+		}
+		key.ID = id
+		if key.Type == dao.KeyTypeJWK {
+			key.JWK.Kid = id
+		}
+	}
+
+	_, err := s.keysDB.GetKey(applicationID, key.ID)
+	if err == nil {
+		return nil, errors.Duplicate
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *Server) listApplicationKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	applicationID := mux.Vars(r)["id"]
+
+	caller, err := s.handler.appCredentials(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if caller.Id != applicationID {
+		_, err = s.handler.managedApplication(ctx, applicationID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	keys, err := s.keysDB.GetKeys(applicationID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if keys == nil {
+		keys = []*dao.ApplicationKey{}
+	}
+	httpx.WriteJSON(w, http.StatusOK, keys)
+}
+
+func (s *Server) addApplicationKey(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	applicationID := mux.Vars(r)["id"]
+
+	target, err := s.handler.managedApplication(ctx, applicationID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	registration := new(keyRegistration)
+	err = json.NewDecoder(r.Body).Decode(registration)
+	if err != nil {
+		writeError(w, errors.BadInput)
+		return
+	}
+
+	key, err := s.newApplicationKey(target.Id, registration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.keysDB.SaveKey(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusCreated, key)
+}
+
+func (s *Server) deleteApplicationKey(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	vars := mux.Vars(r)
+
+	target, err := s.handler.managedApplication(ctx, vars["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.keysDB.DeleteKey(target.Id, vars["kid"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
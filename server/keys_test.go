@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/common/errors"
+)
+
+type memKeysDB struct {
+	dao.KeysDB
+	keys map[string]*dao.ApplicationKey
+}
+
+func (m *memKeysDB) GetKey(applicationID string, keyID string) (*dao.ApplicationKey, error) {
+	key, found := m.keys[applicationID+"/"+keyID]
+	if !found {
+		return nil, errors.NotFound
+	}
+	return key, nil
+}
+
+// newTestCertificate returns a PEM encoded certificate signed by parent, or self-signed when parent is nil
+func newTestCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "app"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestNewApplicationKeyCertificate(t *testing.T) {
+	ca, caKey, _ := newTestCertificate(t, nil, nil)
+	_, _, issued := newTestCertificate(t, ca, caKey)
+	_, _, selfSigned := newTestCertificate(t, nil, nil)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	tests := []struct {
+		name         string
+		registration *keyRegistration
+		clientCAs    *x509.CertPool
+		wantErr      bool
+	}{
+		{name: "issued by the client CA", registration: &keyRegistration{Certificate: issued}, clientCAs: clientCAs},
+		{name: "self-signed", registration: &keyRegistration{Certificate: selfSigned}, clientCAs: clientCAs, wantErr: true},
+		{name: "no client CA", registration: &keyRegistration{Certificate: issued}, wantErr: true},
+		{name: "not PEM", registration: &keyRegistration{Certificate: "certificate"}, clientCAs: clientCAs, wantErr: true},
+		{name: "no key", registration: &keyRegistration{}, clientCAs: clientCAs, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				config:       &Config{},
+				handler:      &gRPCHandler{},
+				keysDB:       &memKeysDB{keys: map[string]*dao.ApplicationKey{}},
+				gatewayCerts: &certificateReloader{clientCAs: tt.clientCAs},
+			}
+
+			key, err := s.newApplicationKey("app", tt.registration)
+			if tt.wantErr {
+				if _, ok := err.(*oauth.InvalidFieldError); !ok {
+					t.Errorf("newApplicationKey() = %v, want an invalid field error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Type != dao.KeyTypeX509 || len(key.Fingerprint) != 64 {
+				t.Errorf("key is %+v", key)
+			}
+		})
+	}
+}
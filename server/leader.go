@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/utils/log"
+)
+
+const (
+	leaderLease        = "registry-leader"
+	leaseTTL           = 15 * time.Second
+	leaseRenewInterval = 5 * time.Second
+)
+
+// singletonJob is a background task that must run on a single registry instance
+type singletonJob func(ctx context.Context)
+
+// jobRunner runs the singleton jobs on the instance holding the database lease
+type jobRunner struct {
+	leases dao.LeasesDB
+	holder string
+	jobs   []singletonJob
+	leader int32
+	// flushers complete the work left by the jobs when the leader shuts down
+	flushers []singletonJob
+	// held is set when run returned while leading. The lease is kept until flush
+	held bool
+}
+
+func newJobRunner(leases dao.LeasesDB, holder string, jobs ...singletonJob) *jobRunner {
+	return &jobRunner{
+		leases: leases,
+		holder: holder,
+		jobs:   jobs,
+	}
+}
+
+func (r *jobRunner) IsLeader() bool {
+	return atomic.LoadInt32(&r.leader) == 1
+}
+
+func (r *jobRunner) run(ctx context.Context) {
+	var (
+		wg       sync.WaitGroup
+		stopJobs context.CancelFunc
+	)
+
+	stop := func() {
+		if stopJobs == nil {
+			return
+		}
+		stopJobs()
+		wg.Wait()
+		stopJobs = nil
+		atomic.StoreInt32(&r.leader, 0)
+	}
+
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := r.leases.Acquire(leaderLease, r.holder, leaseTTL)
+		if err != nil {
+			log.Error("could not renew leader lease", log.Err(err))
+		}
+
+		if acquired && stopJobs == nil {
+			log.Info("elected leader, starting singleton jobs", log.Field("instance", r.holder))
+			atomic.StoreInt32(&r.leader, 1)
+
+			var jobsCtx context.Context
+			jobsCtx, stopJobs = context.WithCancel(ctx)
+			for _, job := range r.jobs {
+				wg.Add(1)
+				go func(job singletonJob) {
+					defer wg.Done()
+					job(jobsCtx)
+				}(job)
+			}
+		} else if !acquired && stopJobs != nil {
+			log.Info("lost leadership, stopping singleton jobs", log.Field("instance", r.holder))
+			stop()
+		}
+
+		select {
+		case <-ctx.Done():
+			if stopJobs != nil {
+				stop()
+				r.held = true
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush runs the flushers then releases the lease. It must be called after run returned
+func (r *jobRunner) flush(ctx context.Context) {
+	if !r.held {
+		return
+	}
+
+	for _, flusher := range r.flushers {
+		flusher(ctx)
+	}
+
+	err := r.leases.Release(leaderLease, r.holder)
+	if err != nil {
+		log.Error("could not release leader lease", log.Err(err))
+	}
+	r.held = false
+}
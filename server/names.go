@@ -4,6 +4,7 @@ const (
 	gRPCServiceName          = "ome-grpc"
 	secureGatewayServiceName = "ome-https"
 	gatewayServiceName       = "ome-http"
+	standaloneServiceName    = "Ome"
 
 	sessionName   = "apps-store-session"
 	sessionKeyJWT = "jwt"
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/libome"
+)
+
+// clientProfile returns the saved OAuth profile of the application or the default one derived from its callback URL
+func (s *Server) clientProfile(a *ome.Application) (*oauth.ClientProfile, error) {
+	profile, err := s.clientsDB.GetClientProfile(a.Id)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return oauth.DefaultClientProfile(a.Id, a.OauthCallbackUrl), nil
+		}
+		return nil, err
+	}
+	return profile, nil
+}
+
+func (s *Server) getClientProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	applicationID := mux.Vars(r)["id"]
+
+	caller, err := s.handler.appCredentials(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var target *ome.Application
+	if caller.Id == applicationID {
+		target = caller
+	} else {
+		target, err = s.handler.managedApplication(ctx, applicationID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	profile, err := s.clientProfile(target)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, profile)
+}
+
+func (s *Server) saveClientProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	applicationID := mux.Vars(r)["id"]
+
+	target, err := s.handler.managedApplication(ctx, applicationID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	profile := new(oauth.ClientProfile)
+	err = json.NewDecoder(r.Body).Decode(profile)
+	if err != nil {
+		writeError(w, errors.BadInput)
+		return
+	}
+	profile.ApplicationID = target.Id
+
+	err = profile.Validate()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.clientsDB.SaveClientProfile(profile)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, profile)
+}
+
+func (s *Server) deleteClientProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := requestContext(r)
+	applicationID := mux.Vars(r)["id"]
+
+	target, err := s.handler.managedApplication(ctx, applicationID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.clientsDB.DeleteClientProfile(target.Id)
+	if err != nil && !errors.IsNotFound(err) {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
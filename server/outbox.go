@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/utils/log"
+)
+
+const (
+	outboxPollInterval  = time.Second
+	outboxBatchSize     = 100
+	outboxRetention     = 7 * 24 * time.Hour
+	outboxPruneInterval = time.Hour
+)
+
+// eventPublisher receives the application events relayed from the outbox, in commit order
+type eventPublisher interface {
+	Publish(e *dao.ApplicationEvent) error
+}
+
+// outboxRelay publishes the outbox messages written by the applications store
+type outboxRelay struct {
+	appsDB     dao.ApplicationsDB
+	publishers []eventPublisher
+}
+
+func (r *outboxRelay) run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		err := r.relay(ctx)
+		if err != nil {
+			log.Error("outbox: could not relay messages", log.Err(err))
+		}
+
+		if time.Since(lastPrune) > outboxPruneInterval {
+			lastPrune = time.Now()
+			err = r.appsDB.PruneOutbox(lastPrune.Add(-outboxRetention).Unix())
+			if err != nil {
+				log.Error("outbox: could not prune delivered messages", log.Err(err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush publishes the messages left pending when the relay stopped
+func (r *outboxRelay) flush(ctx context.Context) {
+	err := r.relay(ctx)
+	if err != nil {
+		log.Error("outbox: could not flush pending messages", log.Err(err))
+	}
+}
+
+// relay publishes the pending messages in order
+func (r *outboxRelay) relay(ctx context.Context) error {
+	for {
+		messages, err := r.appsDB.PendingOutbox(outboxBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			for _, publisher := range r.publishers {
+				err = publisher.Publish(message.Event)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = r.appsDB.MarkDelivered(message.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(messages) < outboxBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/app-registry/validation"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// promotionRequest is the body of the promotion requests. Level is the name of the requested level
+type promotionRequest struct {
+	Level         string `json:"level"`
+	Justification string `json:"justification"`
+}
+
+// promotionReview is the body of the approvals and rejections
+type promotionReview struct {
+	Comment string `json:"comment"`
+}
+
+// parsePromotionLevel returns the level named name. Root cannot be requested
+func parsePromotionLevel(name string) (ome.ApplicationLevel, error) {
+	for levelName, value := range ome.ApplicationLevel_value {
+		if strings.EqualFold(levelName, name) && ome.ApplicationLevel(value) != ome.ApplicationLevel_Root {
+			return ome.ApplicationLevel(value), nil
+		}
+	}
+	return 0, &oauth.InvalidFieldError{Field: "level", Reason: "must be master or internal"}
+}
+
+// requester returns the identity a promotion request is made by: the authenticated user, or the caller application
+func (g *gRPCHandler) requester(ctx context.Context, caller *ome.Application) (string, error) {
+	token, err := g.userToken(ctx, false)
+	if err != nil {
+		return "", err
+	}
+	if token != nil {
+		return token.Claims.Sub, nil
+	}
+	return caller.Id, nil
+}
+
+// promotionReviewer checks that the caller can review promotions and returns the reviewer identity
+func (g *gRPCHandler) promotionReviewer(ctx context.Context) (string, error) {
+	a, err := g.appCredentials(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if a.Level == ome.ApplicationLevel_Root {
+		return g.requester(ctx, a)
+	}
+
+	if a.Level != ome.ApplicationLevel_Master {
+		return "", errors.Unauthorized
+	}
+
+	token, err := g.userToken(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	for _, admin := range g.admins {
+		if admin == token.Claims.Sub {
+			return admin, nil
+		}
+	}
+	return "", errors.Unauthorized
+}
+
+// ownedApplication returns the application identified by applicationID if the caller is or manages it
+func (g *gRPCHandler) ownedApplication(ctx context.Context, applicationID string) (*ome.Application, *ome.Application, error) {
+	caller, err := g.appCredentials(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if caller.Id == applicationID {
+		return caller, caller, nil
+	}
+
+	target, err := g.managedApplication(ctx, applicationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return caller, target, nil
+}
+
+func (g *gRPCHandler) requestPromotion(ctx context.Context, applicationID string, params *promotionRequest) (*dao.Promotion, error) {
+	caller, target, err := g.ownedApplication(ctx, applicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := parsePromotionLevel(params.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	if level >= target.Level {
+		return nil, &oauth.InvalidFieldError{Field: "level", Reason: "must be higher than the application level"}
+	}
+
+	justification := strings.TrimSpace(params.Justification)
+	if justification == "" || len(justification) > validation.MaxDescriptionLength {
+		return nil, &oauth.InvalidFieldError{Field: "justification", Reason: fmt.Sprintf("is required and must not exceed %d characters", validation.MaxDescriptionLength)}
+	}
+
+	requestedBy, err := g.requester(ctx, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomID(8)
+	if err != nil {
+		return nil, err
+	}
+
+	promotion := &dao.Promotion{
+		ID:            id,
+		ApplicationID: target.Id,
+		Level:         level,
+		Justification: justification,
+		RequestedBy:   requestedBy,
+	}
+	err = g.appsDB.RequestPromotion(promotion)
+	if err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+func (g *gRPCHandler) applicationPromotions(ctx context.Context, applicationID string) ([]*dao.Promotion, error) {
+	_, target, err := g.ownedApplication(ctx, applicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	promotions, err := g.appsDB.ListPromotions("")
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*dao.Promotion{}
+	for _, promotion := range promotions {
+		if promotion.ApplicationID == target.Id {
+			list = append(list, promotion)
+		}
+	}
+	return list, nil
+}
+
+// listPromotions returns the promotion requests with the given status, pending by default
+func (g *gRPCHandler) listPromotions(ctx context.Context, status string) ([]*dao.Promotion, error) {
+	_, err := g.promotionReviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case "":
+		status = dao.PromotionPending
+	case "all":
+		status = ""
+	case dao.PromotionPending, dao.PromotionApproved, dao.PromotionRejected:
+	default:
+		return nil, errors.BadInput
+	}
+
+	promotions, err := g.appsDB.ListPromotions(status)
+	if err != nil {
+		return nil, err
+	}
+
+	if promotions == nil {
+		promotions = []*dao.Promotion{}
+	}
+	return promotions, nil
+}
+
+// reviewPromotion records the decision on a pending request
+func (g *gRPCHandler) reviewPromotion(ctx context.Context, id string, approved bool, comment string) (*dao.Promotion, error) {
+	reviewer, err := g.promotionReviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	promotion, err := g.appsDB.GetPromotion(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if promotion.RequestedBy == reviewer {
+		return nil, errors.Unauthorized
+	}
+	return g.appsDB.ReviewPromotion(id, approved, reviewer, strings.TrimSpace(comment))
+}
+
+// ownedApplication returns the application identified in the request path if the caller is or manages it
+func (s *Server) ownedApplication(r *http.Request) (*ome.Application, *ome.Application, error) {
+	return s.handler.ownedApplication(requestContext(r), mux.Vars(r)["id"])
+}
+
+func (s *Server) requestPromotion(w http.ResponseWriter, r *http.Request) {
+	params := new(promotionRequest)
+	err := json.NewDecoder(r.Body).Decode(params)
+	if err != nil {
+		writeError(w, errors.BadInput)
+		return
+	}
+
+	promotion, err := s.handler.requestPromotion(requestContext(r), mux.Vars(r)["id"], params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusCreated, promotion)
+}
+
+func (s *Server) listApplicationPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := s.handler.applicationPromotions(requestContext(r), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, promotions)
+}
+
+func (s *Server) listPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions, err := s.handler.listPromotions(requestContext(r), r.URL.Query().Get("status"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, promotions)
+}
+
+func (s *Server) approvePromotion(w http.ResponseWriter, r *http.Request) {
+	s.reviewPromotion(w, r, true)
+}
+
+func (s *Server) rejectPromotion(w http.ResponseWriter, r *http.Request) {
+	s.reviewPromotion(w, r, false)
+}
+
+func (s *Server) reviewPromotion(w http.ResponseWriter, r *http.Request, approved bool) {
+	review := new(promotionReview)
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(review)
+		if err != nil {
+			writeError(w, errors.BadInput)
+			return
+		}
+	}
+
+	promotion, err := s.handler.reviewPromotion(requestContext(r), mux.Vars(r)["id"], approved, review.Comment)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, promotion)
+}
+
+// The promotions service is declared by hand, as the events service
+const (
+	PromotionsServiceName          = "ome.ApplicationPromotions"
+	RequestPromotionRoute          = "/" + PromotionsServiceName + "/RequestPromotion"
+	ListApplicationPromotionsRoute = "/" + PromotionsServiceName + "/ListApplicationPromotions"
+	ListPromotionsRoute            = "/" + PromotionsServiceName + "/ListPromotions"
+	ReviewPromotionRoute           = "/" + PromotionsServiceName + "/ReviewPromotion"
+)
+
+type applicationPromotionsServer interface {
+	RequestPromotion(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	ListApplicationPromotions(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	ListPromotions(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	ReviewPromotion(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+}
+
+var promotionsServiceDesc = grpc.ServiceDesc{
+	ServiceName: PromotionsServiceName,
+	HandlerType: (*applicationPromotionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RequestPromotion",
+			Handler: promotionsMethodHandler(RequestPromotionRoute, func(srv applicationPromotionsServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.RequestPromotion(ctx, in)
+			}),
+		},
+		{
+			MethodName: "ListApplicationPromotions",
+			Handler: promotionsMethodHandler(ListApplicationPromotionsRoute, func(srv applicationPromotionsServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.ListApplicationPromotions(ctx, in)
+			}),
+		},
+		{
+			MethodName: "ListPromotions",
+			Handler: promotionsMethodHandler(ListPromotionsRoute, func(srv applicationPromotionsServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.ListPromotions(ctx, in)
+			}),
+		},
+		{
+			MethodName: "ReviewPromotion",
+			Handler: promotionsMethodHandler(ReviewPromotionRoute, func(srv applicationPromotionsServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.ReviewPromotion(ctx, in)
+			}),
+		},
+	},
+	Metadata: "app-registry/promotions",
+}
+
+// promotionsMethodHandler decodes the request struct and calls the method through the server interceptor
+func promotionsMethodHandler(route string, call func(srv applicationPromotionsServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(applicationPromotionsServer), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: route}
+		return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(applicationPromotionsServer), ctx, req.(*structpb.Struct))
+		})
+	}
+}
+
+type promotionList struct {
+	Promotions []*dao.Promotion `json:"promotions"`
+}
+
+func (g *gRPCHandler) RequestPromotion(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	promotion, err := g.requestPromotion(ctx, in.Fields["application_id"].GetStringValue(), &promotionRequest{
+		Level:         in.Fields["level"].GetStringValue(),
+		Justification: in.Fields["justification"].GetStringValue(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jsonStruct(promotion)
+}
+
+func (g *gRPCHandler) ListApplicationPromotions(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	promotions, err := g.applicationPromotions(ctx, in.Fields["application_id"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+	return jsonStruct(&promotionList{Promotions: promotions})
+}
+
+func (g *gRPCHandler) ListPromotions(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	promotions, err := g.listPromotions(ctx, in.Fields["status"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+	return jsonStruct(&promotionList{Promotions: promotions})
+}
+
+func (g *gRPCHandler) ReviewPromotion(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	promotion, err := g.reviewPromotion(ctx, in.Fields["id"].GetStringValue(), in.Fields["approved"].GetBoolValue(), in.Fields["comment"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+	return jsonStruct(promotion)
+}
+
+// RequestPromotion calls the registry RequestPromotion RPC on cc
+func RequestPromotion(ctx context.Context, cc grpc.ClientConnInterface, applicationID string, level string, justification string, opts ...grpc.CallOption) (*dao.Promotion, error) {
+	promotion := new(dao.Promotion)
+	err := invokeStruct(ctx, cc, RequestPromotionRoute, map[string]interface{}{
+		"application_id": applicationID,
+		"level":          level,
+		"justification":  justification,
+	}, promotion, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+// ListApplicationPromotions calls the registry ListApplicationPromotions RPC on cc
+func ListApplicationPromotions(ctx context.Context, cc grpc.ClientConnInterface, applicationID string, opts ...grpc.CallOption) ([]*dao.Promotion, error) {
+	list := new(promotionList)
+	err := invokeStruct(ctx, cc, ListApplicationPromotionsRoute, map[string]interface{}{"application_id": applicationID}, list, opts...)
+	return list.Promotions, err
+}
+
+// ListPromotions calls the registry ListPromotions RPC on cc. status is empty for the pending requests, or "all"
+func ListPromotions(ctx context.Context, cc grpc.ClientConnInterface, status string, opts ...grpc.CallOption) ([]*dao.Promotion, error) {
+	list := new(promotionList)
+	err := invokeStruct(ctx, cc, ListPromotionsRoute, map[string]interface{}{"status": status}, list, opts...)
+	return list.Promotions, err
+}
+
+// ReviewPromotion calls the registry ReviewPromotion RPC on cc
+func ReviewPromotion(ctx context.Context, cc grpc.ClientConnInterface, id string, approved bool, comment string, opts ...grpc.CallOption) (*dao.Promotion, error) {
+	promotion := new(dao.Promotion)
+	err := invokeStruct(ctx, cc, ReviewPromotionRoute, map[string]interface{}{
+		"id":       id,
+		"approved": approved,
+		"comment":  comment,
+	}, promotion, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return promotion, nil
+}
+
+// invokeStruct calls the RPC at route with a struct of fields and decodes the response struct into out
+func invokeStruct(ctx context.Context, cc grpc.ClientConnInterface, route string, fields map[string]interface{}, out interface{}, opts ...grpc.CallOption) error {
+	in, err := structpb.NewStruct(fields)
+	if err != nil {
+		return err
+	}
+
+	msg := new(structpb.Struct)
+	err = cc.Invoke(ctx, route, in, msg, opts...)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := msg.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, out)
+}
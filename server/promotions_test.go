@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// promotionsRecorder answers the promotions RPCs with the fields it received
+type promotionsRecorder struct {
+	received map[string]*structpb.Struct
+}
+
+func (p *promotionsRecorder) promotion(route string, in *structpb.Struct) (*structpb.Struct, error) {
+	p.received[route] = in
+	return jsonStruct(&dao.Promotion{
+		ID:            "p1",
+		ApplicationID: in.Fields["application_id"].GetStringValue(),
+		Level:         ome.ApplicationLevel_Master,
+		Status:        dao.PromotionPending,
+	})
+}
+
+func (p *promotionsRecorder) list(route string, in *structpb.Struct) (*structpb.Struct, error) {
+	p.received[route] = in
+	return jsonStruct(&promotionList{Promotions: []*dao.Promotion{{ID: "p1"}, {ID: "p2"}}})
+}
+
+func (p *promotionsRecorder) RequestPromotion(_ context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return p.promotion(RequestPromotionRoute, in)
+}
+
+func (p *promotionsRecorder) ListApplicationPromotions(_ context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return p.list(ListApplicationPromotionsRoute, in)
+}
+
+func (p *promotionsRecorder) ListPromotions(_ context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return p.list(ListPromotionsRoute, in)
+}
+
+func (p *promotionsRecorder) ReviewPromotion(_ context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	return p.promotion(ReviewPromotionRoute, in)
+}
+
+func TestPromotionsService(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	recorder := &promotionsRecorder{received: map[string]*structpb.Struct{}}
+
+	intercepted := map[string]bool{}
+	gs := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		intercepted[info.FullMethod] = true
+		return handler(ctx, req)
+	}))
+	gs.RegisterService(&promotionsServiceDesc, recorder)
+	go func() {
+		_ = gs.Serve(listener)
+	}()
+	defer gs.Stop()
+
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cc.Close()
+	}()
+	ctx := context.Background()
+
+	promotion, err := RequestPromotion(ctx, cc, "app", "master", "needs it")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promotion.ID != "p1" || promotion.ApplicationID != "app" || promotion.Level != ome.ApplicationLevel_Master {
+		t.Errorf("RequestPromotion returned %+v", promotion)
+	}
+	in := recorder.received[RequestPromotionRoute]
+	if in.Fields["level"].GetStringValue() != "master" || in.Fields["justification"].GetStringValue() != "needs it" {
+		t.Errorf("RequestPromotion sent %v", in)
+	}
+
+	promotions, err := ListApplicationPromotions(ctx, cc, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promotions) != 2 || recorder.received[ListApplicationPromotionsRoute].Fields["application_id"].GetStringValue() != "app" {
+		t.Errorf("ListApplicationPromotions returned %d promotions", len(promotions))
+	}
+
+	promotions, err = ListPromotions(ctx, cc, "all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promotions) != 2 || recorder.received[ListPromotionsRoute].Fields["status"].GetStringValue() != "all" {
+		t.Errorf("ListPromotions returned %d promotions", len(promotions))
+	}
+
+	_, err = ReviewPromotion(ctx, cc, "p1", true, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in = recorder.received[ReviewPromotionRoute]
+	if in.Fields["id"].GetStringValue() != "p1" || !in.Fields["approved"].GetBoolValue() || in.Fields["comment"].GetStringValue() != "ok" {
+		t.Errorf("ReviewPromotion sent %v", in)
+	}
+
+	for _, route := range []string{RequestPromotionRoute, ListApplicationPromotionsRoute, ListPromotionsRoute, ReviewPromotionRoute} {
+		if !intercepted[route] {
+			t.Errorf("%s was not called through the server interceptor", route)
+		}
+	}
+}
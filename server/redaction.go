@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+)
+
+// SecretFingerprintMetadata is the gRPC header carrying the secret fingerprint of an application
+const SecretFingerprintMetadata = "x-app-secret-fingerprint"
+
+const fingerprintKeySetting = "secret_fingerprint_key"
+
+// redactApplication removes the secret of a before it is returned by the API
+func redactApplication(a *ome.Application) {
+	if a != nil {
+		a.Secret = ""
+	}
+}
+
+// secretFingerprint returns the hex encoded HMAC-SHA256 of the application ID and secret
+func secretFingerprint(key []byte, a *ome.Application) string {
+	if len(key) == 0 || a.Secret == "" {
+		return ""
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(a.Id))
+	h.Write([]byte{0})
+	h.Write([]byte(a.Secret))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fingerprintKey returns the key of the secret fingerprints
+func (s *Server) fingerprintKey() ([]byte, error) {
+	value, err := s.settingsDB.Get(fingerprintKeySetting)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if err != nil {
+		key := make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err = s.settingsDB.SaveIfAbsent(fingerprintKeySetting, base64.StdEncoding.EncodeToString(key))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
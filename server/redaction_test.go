@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// memApplicationsDB serves copies of its applications, so that the handlers cannot change the stored ones
+type memApplicationsDB struct {
+	dao.ApplicationsDB
+	apps []*ome.Application
+}
+
+func (m *memApplicationsDB) GetApplication(id string) (*ome.Application, error) {
+	for _, a := range m.apps {
+		if a.Id == id {
+			return proto.Clone(a).(*ome.Application), nil
+		}
+	}
+	return nil, errors.NotFound
+}
+
+func (m *memApplicationsDB) ListAllApplications(...dao.ApplicationFilter) (dao.AppCursor, error) {
+	return m.cursor(""), nil
+}
+
+func (m *memApplicationsDB) ListApplicationForUser(user string, _ ...dao.ApplicationFilter) (dao.AppCursor, error) {
+	return m.cursor(user), nil
+}
+
+func (m *memApplicationsDB) cursor(user string) *sliceCursor {
+	c := &sliceCursor{}
+	for _, a := range m.apps {
+		if user == "" || a.Info.CreatedBy == user {
+			c.apps = append(c.apps, proto.Clone(a).(*ome.Application))
+		}
+	}
+	return c
+}
+
+type sliceCursor struct {
+	apps []*ome.Application
+}
+
+func (c *sliceCursor) HasNext() bool {
+	return len(c.apps) > 0
+}
+
+func (c *sliceCursor) Next() (*ome.Application, error) {
+	a := c.apps[0]
+	c.apps = c.apps[1:]
+	return a, nil
+}
+
+func (c *sliceCursor) Close() error {
+	return nil
+}
+
+// headerRecorder is the transport stream of the unary calls made without network, it keeps the headers set by the
+// handlers
+type headerRecorder struct {
+	header metadata.MD
+}
+
+func (h *headerRecorder) Method() string { return "" }
+
+func (h *headerRecorder) SetHeader(md metadata.MD) error {
+	h.header = metadata.Join(h.header, md)
+	return nil
+}
+
+func (h *headerRecorder) SendHeader(md metadata.MD) error { return h.SetHeader(md) }
+
+func (h *headerRecorder) SetTrailer(metadata.MD) error { return nil }
+
+// listRecorder is the server stream of ListApplications
+type listRecorder struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*ome.Application
+}
+
+func (l *listRecorder) Context() context.Context { return l.ctx }
+
+func (l *listRecorder) Send(a *ome.Application) error {
+	l.sent = append(l.sent, a)
+	return nil
+}
+
+func newRedactionHandler() *gRPCHandler {
+	app := func(id string, level ome.ApplicationLevel, owner string) *ome.Application {
+		return &ome.Application{Id: id, Secret: id + "-secret", Level: level, Info: &ome.AppInfo{CreatedBy: owner}}
+	}
+	return &gRPCHandler{
+		appsDB: &memApplicationsDB{apps: []*ome.Application{
+			app("root", ome.ApplicationLevel_Root, ""),
+			app("master", ome.ApplicationLevel_Master, ""),
+			app("alice-app", ome.ApplicationLevel_External, "alice"),
+			app("bob-app", ome.ApplicationLevel_External, "bob"),
+		}},
+		fingerprintKey: []byte("fingerprint key"),
+	}
+}
+
+// callerContext authenticates the calls as the application caller, on behalf of user when it is not empty
+func callerContext(caller string, user string) context.Context {
+	ctx := ome.ContextWithProxyCredentials(context.Background(), &ome.ProxyCredentials{Key: caller, Secret: caller + "-secret"})
+	if user != "" {
+		ctx = ome.ContextWithToken(ctx, &ome.JWT{Claims: &ome.Claims{Sub: user}})
+	}
+	return ctx
+}
+
+func TestGetApplicationRedaction(t *testing.T) {
+	g := newRedactionHandler()
+	stored, _ := g.appsDB.GetApplication("alice-app")
+	aliceFingerprint := secretFingerprint(g.fingerprintKey, stored)
+
+	tests := []struct {
+		name            string
+		caller          string
+		user            string
+		id              string
+		wantErr         bool
+		wantFingerprint string
+	}{
+		{name: "self", caller: "alice-app", id: "alice-app", wantFingerprint: aliceFingerprint},
+		{name: "creator through master", caller: "master", user: "alice", id: "alice-app", wantFingerprint: aliceFingerprint},
+		{name: "other user through master", caller: "master", user: "bob", id: "alice-app", wantErr: true},
+		{name: "root", caller: "root", id: "alice-app"},
+		{name: "other application", caller: "bob-app", id: "alice-app", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &headerRecorder{}
+			ctx := grpc.NewContextWithServerTransportStream(callerContext(tt.caller, tt.user), stream)
+
+			rsp, err := g.GetApplication(ctx, &ome.GetApplicationRequest{ApplicationId: tt.id})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetApplication() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if rsp.Application.Secret != "" {
+				t.Error("the secret was returned")
+			}
+			var fingerprint string
+			if values := stream.header.Get(SecretFingerprintMetadata); len(values) > 0 {
+				fingerprint = values[0]
+			}
+			if fingerprint != tt.wantFingerprint {
+				t.Errorf("fingerprint = %q, want %q", fingerprint, tt.wantFingerprint)
+			}
+		})
+	}
+}
+
+func TestListApplicationsRedaction(t *testing.T) {
+	g := newRedactionHandler()
+
+	tests := []struct {
+		name   string
+		caller string
+		user   string
+		want   []string
+	}{
+		{name: "root", caller: "root", want: []string{"root", "master", "alice-app", "bob-app"}},
+		{name: "master", caller: "master", user: "alice", want: []string{"alice-app"}},
+		{name: "external", caller: "bob-app", want: []string{"bob-app"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &listRecorder{ctx: callerContext(tt.caller, tt.user)}
+			err := g.ListApplications(&ome.ListApplicationsRequest{}, stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(stream.sent) != len(tt.want) {
+				t.Fatalf("%d applications listed, want %d", len(stream.sent), len(tt.want))
+			}
+			for i, a := range stream.sent {
+				if a.Id != tt.want[i] {
+					t.Errorf("application %d is %s, want %s", i, a.Id, tt.want[i])
+				}
+				if a.Secret != "" {
+					t.Errorf("the secret of %s was listed", a.Id)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretFingerprint(t *testing.T) {
+	key := []byte("fingerprint key")
+	a := &ome.Application{Id: "a", Secret: "shared"}
+	b := &ome.Application{Id: "b", Secret: "shared"}
+
+	if secretFingerprint(key, a) != secretFingerprint(key, &ome.Application{Id: "a", Secret: "shared"}) {
+		t.Error("the fingerprint is not stable")
+	}
+	if secretFingerprint(key, a) == secretFingerprint(key, b) {
+		t.Error("applications sharing a secret got the same fingerprint")
+	}
+	if secretFingerprint(key, a) == secretFingerprint([]byte("other key"), a) {
+		t.Error("the fingerprint does not depend on the key")
+	}
+	if secretFingerprint(nil, a) != "" || secretFingerprint(key, &ome.Application{Id: "c"}) != "" {
+		t.Error("a fingerprint was returned without key or secret")
+	}
+}
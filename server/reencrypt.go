@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/common/utils/log"
+)
+
+const (
+	DefaultReencryptionInterval = time.Hour
+	reencryptionBatchSize       = 100
+)
+
+// sealedTables are the tables holding values sealed with the master keys
+var sealedTables = []string{"applications", dao.ClientProfilesTable, "signing_keys", "registry_settings", "webhooks"}
+
+// NewSealer returns the sealer of masterKeyFile or kmsDir, or nil when both are empty
+func NewSealer(masterKeyFile string, kmsDir string) (*envelope.Sealer, error) {
+	switch {
+	case masterKeyFile != "" && kmsDir != "":
+		return nil, errors.New("master key file and KMS directory are mutually exclusive")
+
+	case masterKeyFile != "":
+		keys, err := envelope.NewFileKeyring(masterKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return envelope.NewSealer(keys), nil
+
+	case kmsDir != "":
+		keys, err := envelope.NewLocalKMS(kmsDir)
+		if err != nil {
+			return nil, err
+		}
+		return envelope.NewSealer(keys), nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// reencrypter is a store that seals again the values left in plaintext or sealed with an old master key version
+type reencrypter interface {
+	Reencrypt(limit int) (int, error)
+}
+
+// reencryptionJob seals again the secrets left in plaintext or sealed with an old master key version
+type reencryptionJob struct {
+	stores   map[string]reencrypter
+	interval time.Duration
+}
+
+func (j *reencryptionJob) run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		for name, store := range j.stores {
+			j.reencrypt(ctx, name, store)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *reencryptionJob) reencrypt(ctx context.Context, name string, store reencrypter) {
+	for ctx.Err() == nil {
+		count, err := store.Reencrypt(reencryptionBatchSize)
+		if err != nil {
+			log.Error("reencryption: could not re-encrypt "+name, log.Err(err))
+			return
+		}
+		if count == 0 {
+			return
+		}
+		log.Info("reencryption: "+name+" re-encrypted", log.Field("count", count))
+	}
+}
+
+// sealedStores returns the stores holding sealed values, by name
+func (s *Server) sealedStores() map[string]reencrypter {
+	return map[string]reencrypter{
+		"applications":    s.appsDB,
+		"client profiles": s.clientsDB,
+		"signing keys":    s.signingKeysDB,
+		"settings":        s.settingsDB,
+		"webhooks":        s.webhooksDB,
+	}
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/libome"
+)
+
+// Dynamic client registration policies
+const (
+	RegistrationPolicyClosed = "closed"
+	RegistrationPolicyToken  = "token"
+	RegistrationPolicyOpen   = "open"
+)
+
+// dynamicRegistrationCreator prefixes the creator of the applications registered by third parties
+const dynamicRegistrationCreator = "oauth-registration:"
+
+func writeErrorResponse(w http.ResponseWriter, status int, code string, description string) {
+	httpx.WriteJSON(w, status, &oauth.ErrorResponse{
+		Code:        code,
+		Description: description,
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+}
+
+func (s *Server) registrationAllowed(r *http.Request) bool {
+	switch s.config.RegistrationPolicy {
+	case RegistrationPolicyOpen:
+		return true
+
+	case RegistrationPolicyToken:
+		token := bearerToken(r)
+		if token == "" {
+			return false
+		}
+
+		allowed := false
+		for _, initialAccessToken := range s.config.InitialAccessTokens {
+			if secureCompare(token, initialAccessToken) {
+				allowed = true
+			}
+		}
+		return allowed
+
+	default:
+		return false
+	}
+}
+
+// registrationOwner returns the creator of the client registered by r. The clients registered with an initial access
+// token are owned by that token, the others by themselves
+func (s *Server) registrationOwner(r *http.Request, applicationID string) string {
+	if s.config.RegistrationPolicy == RegistrationPolicyToken {
+		return dynamicRegistrationCreator + "token:" + tokenHash(bearerToken(r))[:16]
+	}
+	return dynamicRegistrationCreator + applicationID
+}
+
+// registeredClient authenticates the request with the registration access token of the client identified in the URL
+func (s *Server) registeredClient(r *http.Request) (*ome.Application, error) {
+	applicationID := mux.Vars(r)["id"]
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errors.Unauthorized
+	}
+
+	registration, err := s.registrationsDB.GetRegistration(applicationID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.Unauthorized
+		}
+		return nil, err
+	}
+
+	if !secureCompare(tokenHash(token), registration.TokenHash) {
+		return nil, errors.Unauthorized
+	}
+
+	a, err := s.appsDB.GetApplication(applicationID)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.Unauthorized
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+func registrationClientURI(r *http.Request, applicationID string) string {
+	return baseURL(r) + strings.Replace(RegistrationClientRoute, "{id}", url.PathEscape(applicationID), 1)
+}
+
+func clientMetadata(a *ome.Application, profile *oauth.ClientProfile) *oauth.ClientMetadata {
+	md := new(oauth.ClientMetadata)
+	md.FillFromProfile(profile)
+	if a.Info != nil {
+		md.ClientIDIssuedAt = a.Info.CreatedAt
+		md.ClientName = a.Info.Label
+		md.ClientURI = a.Info.Website
+		md.LogoURI = a.Info.LogoUrl
+	}
+	return md
+}
+
+func checkMetadataURIs(md *oauth.ClientMetadata) error {
+	for name, value := range map[string]string{"client_uri": md.ClientURI, "logo_uri": md.LogoURI} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || !u.IsAbs() || u.Scheme != "https" {
+			return &oauth.ErrorResponse{
+				Code:        oauth.ErrorInvalidClientMetadata,
+				Description: fmt.Sprintf("%s must be an absolute https URL", name),
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) applyClientMetadata(a *ome.Application, md *oauth.ClientMetadata) (*oauth.ClientProfile, error) {
+	err := checkMetadataURIs(md)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := md.Profile(a.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Info == nil {
+		a.Info = &ome.AppInfo{ApplicationId: a.Id}
+	}
+	a.Info.Label = md.ClientName
+	a.Info.Website = md.ClientURI
+	a.Info.LogoUrl = md.LogoURI
+	a.OauthCallbackUrl = ""
+	if len(profile.RedirectURIs) > 0 {
+		a.OauthCallbackUrl = profile.RedirectURIs[0]
+	}
+	return profile, nil
+}
+
+func (s *Server) writeRegistrationFailure(w http.ResponseWriter, err error) {
+	if registrationErr, ok := err.(*oauth.ErrorResponse); ok {
+		httpx.WriteJSON(w, http.StatusBadRequest, registrationErr)
+		return
+	}
+	writeError(w, err)
+}
+
+func (s *Server) registerClient(w http.ResponseWriter, r *http.Request) {
+	if !s.registrationAllowed(r) {
+		if s.config.RegistrationPolicy == RegistrationPolicyToken {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeErrorResponse(w, http.StatusUnauthorized, "invalid_token", "a valid initial access token is required")
+			return
+		}
+		writeErrorResponse(w, http.StatusForbidden, "access_denied", "dynamic client registration is disabled")
+		return
+	}
+
+	md := new(oauth.ClientMetadata)
+	err := json.NewDecoder(r.Body).Decode(md)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, oauth.ErrorInvalidClientMetadata, "request body is not a valid JSON document")
+		return
+	}
+
+	applicationID, err := randomID(16)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	registrationToken, err := randomToken(32)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	now := time.Now().Unix()
+	a := &ome.Application{
+		Id:        applicationID,
+		Activated: true,
+		Level:     ome.ApplicationLevel_External,
+		Secret:    secret,
+		Info: &ome.AppInfo{
+			ApplicationId: applicationID,
+			CreatedBy:     s.registrationOwner(r, applicationID),
+			CreatedAt:     now,
+		},
+	}
+
+	profile, err := s.applyClientMetadata(a, md)
+	if err != nil {
+		s.writeRegistrationFailure(w, err)
+		return
+	}
+
+	err = s.appsDB.CreateRegisteredClient(a, profile, &dao.ClientRegistration{
+		ApplicationID: applicationID,
+		TokenHash:     tokenHash(registrationToken),
+		IssuedAt:      now,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := clientMetadata(a, profile)
+	if profile.ClientType == oauth.ClientTypeConfidential {
+		response.ClientSecret = secret
+	}
+	response.RegistrationAccessToken = registrationToken
+	response.RegistrationClientURI = registrationClientURI(r, applicationID)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	httpx.WriteJSON(w, http.StatusCreated, response)
+}
+
+func (s *Server) readRegisteredClient(w http.ResponseWriter, r *http.Request) {
+	a, err := s.registeredClient(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	profile, err := s.clientProfile(a)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := clientMetadata(a, profile)
+	response.RegistrationClientURI = registrationClientURI(r, a.Id)
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpx.WriteJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) updateRegisteredClient(w http.ResponseWriter, r *http.Request) {
+	a, err := s.registeredClient(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	md := new(oauth.ClientMetadata)
+	err = json.NewDecoder(r.Body).Decode(md)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, oauth.ErrorInvalidClientMetadata, "request body is not a valid JSON document")
+		return
+	}
+
+	if md.ClientID != a.Id {
+		writeErrorResponse(w, http.StatusBadRequest, oauth.ErrorInvalidClientMetadata, "client_id does not match the registered client")
+		return
+	}
+
+	if md.ClientSecret != "" && !secureCompare(md.ClientSecret, a.Secret) {
+		writeErrorResponse(w, http.StatusBadRequest, oauth.ErrorInvalidClientMetadata, "client_secret does not match the registered client")
+		return
+	}
+
+	profile, err := s.applyClientMetadata(a, md)
+	if err != nil {
+		s.writeRegistrationFailure(w, err)
+		return
+	}
+
+	err = s.appsDB.SaveClientApplication(a, profile)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := clientMetadata(a, profile)
+	response.RegistrationClientURI = registrationClientURI(r, a.Id)
+
+	w.Header().Set("Cache-Control", "no-store")
+	httpx.WriteJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) deleteRegisteredClient(w http.ResponseWriter, r *http.Request) {
+	a, err := s.registeredClient(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.appsDB.DeleteApplication(a.Id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
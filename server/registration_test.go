@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/libome"
+)
+
+// registeringApplicationsDB records the clients created by the registration endpoint
+type registeringApplicationsDB struct {
+	dao.ApplicationsDB
+	apps          []*ome.Application
+	profiles      []*oauth.ClientProfile
+	registrations []*dao.ClientRegistration
+}
+
+func (m *registeringApplicationsDB) CreateRegisteredClient(application *ome.Application, profile *oauth.ClientProfile, registration *dao.ClientRegistration) error {
+	m.apps = append(m.apps, application)
+	m.profiles = append(m.profiles, profile)
+	m.registrations = append(m.registrations, registration)
+	return nil
+}
+
+func TestRegisterClientOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		tokens    []string
+		sameOwner bool
+	}{
+		{name: "open registrations", policy: RegistrationPolicyOpen, tokens: []string{"", ""}},
+		{name: "same initial access token", policy: RegistrationPolicyToken, tokens: []string{"token1", "token1"}, sameOwner: true},
+		{name: "different initial access tokens", policy: RegistrationPolicyToken, tokens: []string{"token1", "token2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appsDB := &registeringApplicationsDB{}
+			s := &Server{
+				config: &Config{RegistrationPolicy: tt.policy, InitialAccessTokens: []string{"token1", "token2"}},
+				appsDB: appsDB,
+			}
+
+			for _, token := range tt.tokens {
+				r := httptest.NewRequest(http.MethodPost, RegistrationRoute, strings.NewReader(`{"redirect_uris": ["https://client.example.com/callback"]}`))
+				if token != "" {
+					r.Header.Set("Authorization", "Bearer "+token)
+				}
+				w := httptest.NewRecorder()
+				s.registerClient(w, r)
+				if w.Code != http.StatusCreated {
+					t.Fatalf("registerClient() status = %d: %s", w.Code, w.Body)
+				}
+			}
+
+			if len(appsDB.apps) != 2 {
+				t.Fatalf("%d clients are created", len(appsDB.apps))
+			}
+			for i, a := range appsDB.apps {
+				if appsDB.profiles[i].ApplicationID != a.Id || appsDB.registrations[i].ApplicationID != a.Id {
+					t.Errorf("the profile or registration of %s is not created with it", a.Id)
+				}
+				if !strings.HasPrefix(a.Info.CreatedBy, dynamicRegistrationCreator) {
+					t.Errorf("client %s is created by %q", a.Id, a.Info.CreatedBy)
+				}
+			}
+
+			first, second := appsDB.apps[0].Info.CreatedBy, appsDB.apps[1].Info.CreatedBy
+			if (first == second) != tt.sameOwner {
+				t.Errorf("clients are created by %q and %q", first, second)
+			}
+			for _, token := range tt.tokens {
+				if token != "" && strings.Contains(first+second, token) {
+					t.Errorf("initial access token %q is recorded as creator", token)
+				}
+			}
+		})
+	}
+}
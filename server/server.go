@@ -1,15 +1,24 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"database/sql"
 	"encoding/base64"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/omecodes/app-registry/bootstrap"
 	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/envelope"
+	"github.com/omecodes/app-registry/reconcile"
 	"github.com/omecodes/bome"
 	"github.com/omecodes/common/env/app"
 	"github.com/omecodes/common/errors"
@@ -20,29 +29,107 @@ import (
 )
 
 type Config struct {
+	// MasterKeyFile or KMSDir holds the master keys the application secrets, client profiles, signing keys and
+	// settings are encrypted with. They are stored in plaintext when none is set
+	MasterKeyFile string
+	KMSDir        string
+
+	// Bootstrap describes the master application created on the first start. Its secret is written to
+	// BootstrapSecretFile, or printed once on the standard error when PrintBootstrapSecret is set
+	Bootstrap            *bootstrap.Settings
+	BootstrapSecretFile  string
+	PrintBootstrapSecret bool
+
+	// DevMode serves plain HTTP and insecure gRPC on loopback, in standalone mode. Never use it in production
+	DevMode bool
+
+	// Standalone runs the registry without service box and discovery registry. The listeners are bound to BindIP,
+	// and /info serves Info
+	Standalone bool
+	Domain     string
+	BindIP     string
+	Info       *ome.Info
+
+	// TLSCertFilename and TLSKeyFilename are the certificate and key the HTTPS gateway serves when ACME is not
+	// enabled. They are reloaded when the files change
 	TLSCertFilename string
 	TLSKeyFilename  string
-	DSN             string
-	Box             *service.Box
-	Application     *app.App
-	WebPort         int
-	GRPCPort        int
+	// TLSClientCAFilename contains the CAs the gateway verifies the client certificates with, according to TLSClientAuth
+	TLSClientCAFilename string
+	TLSClientAuth       string
+
+	DSN                 string
+	Box                 *service.Box
+	Application         *app.App
+	WebPort             int
+	GRPCPort            int
+	RegistrationPolicy  string
+	InitialAccessTokens []string
+
+	// Admins are the users allowed to review the promotion requests through a master application, as root applications do
+	Admins []string
+
+	// MaxApplicationsPerUser is the number of applications a user can register through a master application. It defaults
+	// to DefaultMaxApplicationsPerUser, a negative value removes the limit
+	MaxApplicationsPerUser int
+
+	// Issuer is the iss claim of the identity tokens. It defaults to the box domain URL
+	Issuer            string
+	IdentityTokenTTL  time.Duration
+	KeyRotationPeriod time.Duration
+	ShutdownTimeout   time.Duration
+
+	// AppsDir is a directory of declarative application definitions the leader reconciles the registry with
+	AppsDir          string
+	AppsSyncInterval time.Duration
+	// AppsAdoptPrivileged lets the definitions take over existing Root, Master and reserved applications
+	AppsAdoptPrivileged bool
 }
 
 type Server struct {
-	config        *Config
-	gRPCHandler   ome.ApplicationsServer
-	appsDB        dao.ApplicationsDB
-	translationDB *bome.DoubleMap
-
-	certsCacheDir string
-	cookieStore   *sessions.CookieStore
-	initialized   bool
+	config          *Config
+	gRPCHandler     ome.ApplicationsServer
+	handler         *gRPCHandler
+	appsDB          dao.ApplicationsDB
+	clientsDB       dao.ClientProfilesDB
+	registrationsDB dao.RegistrationsDB
+	keysDB          dao.KeysDB
+	signingKeysDB   dao.SigningKeysDB
+	webhooksDB      dao.WebhooksDB
+	leasesDB        dao.LeasesDB
+	settingsDB      dao.SettingsDB
+	managedDB       dao.ManagedApplicationsDB
+	verificationsDB dao.VerificationsDB
+	verifier        *domainVerifier
+	translationDB   *bome.DoubleMap
+
+	certsCacheDir    string
+	gatewayCerts     *certificateReloader
+	gateway          *http.Server
+	sealer           *envelope.Sealer
+	cookieStore      *sessions.CookieStore
+	assertionsReplay dao.UsedAssertionsDB
+	issuer           string
+	initialized      bool
+	stopBackground   context.CancelFunc
+	backgroundDone   chan struct{}
+	jobs             *jobRunner
+
+	db         *sql.DB
+	grpcServer *grpc.Server
+	draining   chan struct{}
+	drainOnce  sync.Once
+	drainLock  sync.Mutex
+	inFlight   sync.WaitGroup
 }
 
 func New(cfg *Config) *Server {
+	if cfg.DevMode {
+		cfg.Standalone = true
+	}
 	return &Server{
-		config: cfg,
+		config:   cfg,
+		draining: make(chan struct{}),
 	}
 }
 
@@ -56,88 +143,307 @@ func (s *Server) init() error {
 
 	a := s.config.Application
 
-	s.certsCacheDir = filepath.Join(a.DataDir(), "certs")
-	err = os.MkdirAll(s.certsCacheDir, os.ModePerm)
+	secretFiles := []string{
+		s.config.TLSKeyFilename,
+		s.config.MasterKeyFile,
+		s.bootstrapSecretFile(),
+		filepath.Join(a.DataDir(), "cookies.key"),
+	}
+	if s.config.KMSDir != "" {
+		kmsKeys, err := filepath.Glob(filepath.Join(s.config.KMSDir, "*.key"))
+		if err != nil {
+			return err
+		}
+		secretFiles = append(append(secretFiles, s.config.KMSDir), kmsKeys...)
+	}
+
+	err = bootstrap.CheckPermissions(secretFiles...)
 	if err != nil {
 		return err
 	}
 
-	db, err := sql.Open(bome.MySQL, s.config.DSN)
+	s.certsCacheDir = filepath.Join(a.DataDir(), "certs")
+	err = os.MkdirAll(s.certsCacheDir, 0700)
 	if err != nil {
 		return err
 	}
 
-	s.appsDB, err = dao.NewSQLApplicationsDB(db, bome.MySQL, "applications")
+	db, err := sql.Open(bome.MySQL, s.config.DSN)
 	if err != nil {
 		return err
 	}
+	s.db = db
 
-	s.translationDB, err = bome.NewDoubleMap(db, bome.MySQL, "attr_translations")
+	s.sealer, err = NewSealer(s.config.MasterKeyFile, s.config.KMSDir)
 	if err != nil {
 		return err
 	}
 
-	cookiesKeyFilename := filepath.Join(s.config.Application.DataDir(), "cookies.key")
-	cookiesKey, err := ioutil.ReadFile(cookiesKeyFilename)
-	if err != nil {
-		cookiesKey = make([]byte, 64)
-		_, err = rand.Read(cookiesKey)
+	if s.sealer == nil {
+		sealed, err := dao.HasSealedValues(db, bome.MySQL, sealedTables...)
 		if err != nil {
-			log.Error("could not generate secret key for web cookies", log.Err(err))
 			return err
 		}
-		err = ioutil.WriteFile(cookiesKeyFilename, cookiesKey, os.ModePerm)
-		if err != nil {
-			log.Info("could not save secret key for web cookies", log.Field("error", err))
+		if sealed {
+			return errors.New("the database holds encrypted values and no master key is configured")
 		}
 	}
+
+	s.appsDB, err = dao.NewSealedSQLApplicationsDB(db, bome.MySQL, "applications", s.sealer)
+	if err != nil {
+		return err
+	}
+
+	s.clientsDB, err = dao.NewSealedSQLClientProfilesDB(db, bome.MySQL, dao.ClientProfilesTable, s.sealer)
+	if err != nil {
+		return err
+	}
+
+	s.registrationsDB, err = dao.NewSQLRegistrationsDB(db, bome.MySQL, dao.RegistrationsTable)
+	if err != nil {
+		return err
+	}
+
+	s.keysDB, err = dao.NewSQLKeysDB(db, bome.MySQL, dao.KeysTable)
+	if err != nil {
+		return err
+	}
+
+	s.signingKeysDB, err = dao.NewSealedSQLSigningKeysDB(db, bome.MySQL, "signing_keys", s.sealer)
+	if err != nil {
+		return err
+	}
+
+	s.webhooksDB, err = dao.NewSealedSQLWebhooksDB(db, bome.MySQL, "webhooks", s.sealer)
+	if err != nil {
+		return err
+	}
+
+	s.leasesDB, err = dao.NewSQLLeasesDB(db, bome.MySQL, "leases")
+	if err != nil {
+		return err
+	}
+
+	s.managedDB, err = dao.NewSQLManagedApplicationsDB(db, bome.MySQL, dao.ManagedApplicationsTable)
+	if err != nil {
+		return err
+	}
+
+	s.verificationsDB, err = dao.NewSQLVerificationsDB(db, bome.MySQL, dao.VerificationsTable)
+	if err != nil {
+		return err
+	}
+
+	s.settingsDB, err = dao.NewSealedSQLSettingsDB(db, bome.MySQL, "registry_settings", s.sealer)
+	if err != nil {
+		return err
+	}
+
+	s.translationDB, err = bome.NewDoubleMap(db, bome.MySQL, "attr_translations")
+	if err != nil {
+		return err
+	}
+
+	s.assertionsReplay, err = dao.NewSQLUsedAssertionsDB(db, bome.MySQL, "used_client_assertions")
+	if err != nil {
+		return err
+	}
+
+	cookiesKey, err := s.cookiesKey()
+	if err != nil {
+		log.Error("could not load secret key for web cookies", log.Err(err))
+		return err
+	}
 	s.cookieStore = sessions.NewCookieStore(cookiesKey)
 
-	application, err := s.appsDB.GetApplication("ome")
-	if err != nil && !errors.IsNotFound(err) {
+	err = s.bootstrap()
+	if err != nil {
 		return err
 	}
 
-	if application == nil {
-		secretBytes := make([]byte, 8)
-		_, err := rand.Read(secretBytes)
-		if err != nil {
-			return err
+	s.handler = newGRPCHandler(s.appsDB, s.clientsDB, s.keysDB, s.cookieStore, s.translationDB)
+	s.handler.draining = s.draining
+	s.handler.verificationsDB = s.verificationsDB
+	s.handler.webhooksDB = s.webhooksDB
+	s.verifier = &domainVerifier{allowLoopback: s.config.DevMode}
+	s.handler.fingerprintKey, err = s.fingerprintKey()
+	if err != nil {
+		return err
+	}
+	s.handler.admins = s.config.Admins
+	s.handler.maxAppsPerUser = s.config.MaxApplicationsPerUser
+	if s.handler.maxAppsPerUser == 0 {
+		s.handler.maxAppsPerUser = DefaultMaxApplicationsPerUser
+	}
+	if s.config.Box != nil && s.config.Box.ServiceCert() != nil {
+		s.handler.serviceFingerprint = certificateFingerprint(s.config.Box.ServiceCert())
+	}
+
+	s.issuer = s.config.Issuer
+	if s.issuer == "" {
+		switch {
+		case s.config.DevMode:
+			s.issuer = fmt.Sprintf("http://%s:%d", DevHost, s.config.WebPort)
+		case s.config.Standalone:
+			s.issuer = "https://" + s.config.Domain
+		case s.config.Box != nil:
+			s.issuer = "https://" + s.config.Box.Domain()
 		}
-		application = new(ome.Application)
-		application.Id = "ome"
-		application.Activated = true
-		application.OauthCallbackUrl = "https://accounts.ome.ci"
-		application.Level = ome.ApplicationLevel_Master
-		application.Secret = base64.RawStdEncoding.EncodeToString(secretBytes)
-		application.Info = &ome.AppInfo{
-			ApplicationId: "ome",
-			CreatedBy:     "ome",
-			CreatedAt:     0,
-			Label:         "Accounts Application",
-			LogoUrl:       "https://ca.ome.ci/public/logo/accounts.png",
-			Description:   "Accounts management application",
-			Website:       "https://ca.ome.ci",
+	}
+	s.handler.identity = newIdentityIssuer(s.signingKeysDB, s.issuer, s.config.IdentityTokenTTL, s.config.KeyRotationPeriod)
+	s.gRPCHandler = s.handler
+	return nil
+}
+
+// cookiesKey returns the key of the web cookies, sealed in the settings
+func (s *Server) cookiesKey() ([]byte, error) {
+	value, err := s.settingsDB.Get("cookies_key")
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if err != nil {
+		cookiesKeyFilename := filepath.Join(s.config.Application.DataDir(), "cookies.key")
+		key, err := ioutil.ReadFile(cookiesKeyFilename)
+		if err != nil {
+			key = make([]byte, 64)
+			_, err = rand.Read(key)
+			if err != nil {
+				return nil, err
+			}
 		}
-		err = s.appsDB.SaveApplication(application)
+
+		value, err = s.settingsDB.SaveIfAbsent("cookies_key", base64.StdEncoding.EncodeToString(key))
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		secretFilename := filepath.Join(s.config.Application.DataDir(), "ome-app.secret")
-		_ = ioutil.WriteFile(secretFilename, []byte(application.Secret), os.ModePerm)
+		// the migrated key is now sealed in the settings, the plaintext copy must not stay on disk
+		err = os.Remove(cookiesKeyFilename)
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("could not remove the migrated cookies key file", log.Err(err), log.Field("file", cookiesKeyFilename))
+		}
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// bootstrap creates the master application on the first start
+func (s *Server) bootstrap() error {
+	settings := s.bootstrapSettings()
+	secret, err := bootstrap.Ensure(s.appsDB, settings)
+	if err != nil || secret == "" {
+		return err
+	}
+
+	if s.config.PrintBootstrapSecret {
+		_, err = fmt.Fprintf(os.Stderr, "Secret of the %s application, printed only once: %s\n", settings.ApplicationID, secret)
+		return err
 	}
-	s.gRPCHandler = NewApplicationServerGRPCHandler(s.appsDB, s.cookieStore, s.translationDB)
+
+	filename := s.bootstrapSecretFile()
+	err = bootstrap.WriteSecret(filename, secret)
+	if err != nil {
+		// the secret would be lost, the application is deleted so that the next start creates it again
+		deleteErr := s.appsDB.DeleteApplication(settings.ApplicationID)
+		if deleteErr != nil {
+			log.Error("bootstrap: could not delete the application after the secret file failure", log.Err(deleteErr), log.Field("id", settings.ApplicationID))
+		}
+		return err
+	}
+	log.Info("bootstrap: application created", log.Field("id", settings.ApplicationID), log.Field("secret-file", filename))
 	return nil
 }
 
+func (s *Server) bootstrapSettings() *bootstrap.Settings {
+	if s.config.Bootstrap != nil {
+		return s.config.Bootstrap
+	}
+	return &bootstrap.Settings{ApplicationID: bootstrap.DefaultApplicationID}
+}
+
+func (s *Server) bootstrapSecretFile() string {
+	if s.config.BootstrapSecretFile != "" {
+		return s.config.BootstrapSecretFile
+	}
+	return s.bootstrapSettings().SecretFilename(s.config.Application.DataDir())
+}
+
 func (s *Server) Start() error {
 	err := s.init()
 	if err != nil {
 		return err
 	}
 
-	err = s.config.Box.StartCAService(func(cred *ome.ProxyCredentials) (bool, error) {
+	err = s.purgeDevApplication()
+	if err != nil {
+		return err
+	}
+	if s.config.DevMode {
+		warnDevMode()
+		s.handler.devMode = true
+	}
+
+	if s.config.Standalone {
+		err = s.startStandalone()
+	} else {
+		err = s.startBoxNodes()
+	}
+	if err != nil {
+		return err
+	}
+
+	var ctx context.Context
+	ctx, s.stopBackground = context.WithCancel(context.Background())
+	webhooks := newWebhookDispatcher(s.webhooksDB)
+	relay := &outboxRelay{
+		appsDB:     s.appsDB,
+		publishers: []eventPublisher{webhooks},
+	}
+
+	instanceID, err := randomID(8)
+	if err != nil {
+		return err
+	}
+	jobs := []singletonJob{webhooks.run, relay.run}
+	if s.sealer != nil {
+		reencryption := &reencryptionJob{stores: s.sealedStores(), interval: DefaultReencryptionInterval}
+		jobs = append(jobs, reencryption.run)
+	}
+	if s.config.AppsDir != "" {
+		interval := s.config.AppsSyncInterval
+		if interval <= 0 {
+			interval = DefaultAppsSyncInterval
+		}
+		syncer := &appsSync{
+			dir:      s.config.AppsDir,
+			interval: interval,
+			stores: &reconcile.Stores{
+				Applications: s.appsDB,
+				Managed:      s.managedDB,
+				Clients:      s.clientsDB,
+				Keys:         s.keysDB,
+			},
+			options: reconcile.Options{AdoptPrivileged: s.config.AppsAdoptPrivileged},
+		}
+		jobs = append(jobs, syncer.run)
+	}
+	s.jobs = newJobRunner(s.leasesDB, s.name()+"-"+instanceID, jobs...)
+	s.jobs.flushers = []singletonJob{relay.flush, webhooks.flush}
+	if s.gatewayCerts != nil {
+		go s.gatewayCerts.watch(ctx, DefaultCertificateReloadInterval)
+	}
+
+	s.backgroundDone = make(chan struct{})
+	go func() {
+		s.jobs.run(ctx)
+		close(s.backgroundDone)
+	}()
+	return nil
+}
+
+// startBoxNodes starts the box CA service, the gRPC node and the HTTPS gateway
+func (s *Server) startBoxNodes() error {
+	err := s.config.Box.StartCAService(func(cred *ome.ProxyCredentials) (bool, error) {
 		if cred == nil {
 			return false, errors.Unauthorized
 		}
@@ -150,18 +456,28 @@ func (s *Server) Start() error {
 			}
 			return false, errors.Internal
 		}
-		return a.Secret == cred.Secret, nil
+		return verifySecret(a, cred.Secret), nil
 	})
 	if err != nil {
 		return err
 	}
 
+	var gatewayTLS *tls.Config
+	if s.config.TLSCertFilename != "" && !s.config.Box.AcmeEnabled() {
+		s.gatewayCerts, err = newCertificateReloader(s.config)
+		if err != nil {
+			return err
+		}
+		gatewayTLS = s.gatewayCerts.serverTLS()
+	}
+
 	registry := s.config.Box.Registry()
 	var registryID string
 	registryID = registry.RegisterEventHandler(ome.EventHandlerFunc(func(event *ome.RegistryEvent) {
 		if event.ServiceId == s.config.Box.Name() && (event.Type == ome.RegistryEventType_Register || event.Type == ome.RegistryEventType_Update) {
 			registry.DeregisterEventHandler(registryID)
 
+			var err error
 			if s.config.Box.AcmeEnabled() {
 				err = s.config.Box.StartAcmeServiceGatewayMapping(&service.ACMEServiceGatewayParams{
 					ForceRegister:  true,
@@ -179,6 +495,7 @@ func (s *Server) Start() error {
 					NodeName:       secureGatewayServiceName,
 					Port:           s.config.WebPort,
 					Security:       ome.Security_Tls,
+					Tls:            gatewayTLS,
 					Binder:         ome.RegisterApplicationsHandlerFromEndpoint,
 					MuxWrapper:     s.createRouter,
 				})
@@ -192,7 +509,11 @@ func (s *Server) Start() error {
 	err = s.config.Box.StartGrpcNode(&service.GrpcNodeParams{
 		ForceRegister: true,
 		RegisterHandlerFunc: func(gs *grpc.Server) {
+			s.grpcServer = gs
 			ome.RegisterApplicationsServer(gs, s.gRPCHandler)
+			gs.RegisterService(&eventsServiceDesc, s.handler)
+			gs.RegisterService(&promotionsServiceDesc, s.handler)
+			gs.RegisterService(&webhooksServiceDesc, s.handler)
 		},
 		ServiceType: ome.AppRegistryServiceType,
 		Port:        s.config.GRPCPort,
@@ -210,6 +531,26 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// name is the service name, used to identify the instance
+func (s *Server) name() string {
+	if s.config.Box != nil {
+		return s.config.Box.Name()
+	}
+	return standaloneServiceName
+}
+
+// Stop drains the server within the configured shutdown timeout
 func (s *Server) Stop() {
-	s.config.Box.Stop()
+	timeout := s.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if err != nil {
+		log.Error("server shutdown", log.Err(err))
+	}
 }
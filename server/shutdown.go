@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/omecodes/common/utils/log"
+)
+
+const DefaultShutdownTimeout = 30 * time.Second
+
+// drainingMiddleware rejects the requests received once draining started and keeps count of the in-flight ones
+func (s *Server) drainingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// draining starts under the same lock, so that no request is counted once Wait was called
+		s.drainLock.Lock()
+		select {
+		case <-s.draining:
+			s.drainLock.Unlock()
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		s.inFlight.Add(1)
+		s.drainLock.Unlock()
+
+		defer s.inFlight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Shutdown drains the server, stops the background jobs and flushes the pending deliveries
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.drainOnce.Do(func() {
+		err = s.shutdown(ctx)
+	})
+	return err
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	log.Info("draining server")
+
+	if s.config.Box != nil && s.config.Box.Registry() != nil {
+		err := s.config.Box.Registry().DeregisterService(s.config.Box.Name())
+		if err != nil {
+			log.Error("could not deregister service", log.Err(err))
+		}
+	}
+	s.drainLock.Lock()
+	close(s.draining)
+	s.drainLock.Unlock()
+
+	httpDone := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(httpDone)
+	}()
+
+	grpcDone := make(chan struct{})
+	go func() {
+		if s.grpcServer != nil {
+			s.grpcServer.GracefulStop()
+		}
+		close(grpcDone)
+	}()
+
+	for _, done := range []chan struct{}{httpDone, grpcDone} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			log.Info("shutdown timeout reached, closing remaining connections")
+		}
+	}
+
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+
+	if s.stopBackground != nil {
+		s.stopBackground()
+		select {
+		case <-s.backgroundDone:
+			log.Info("flushing event outbox and webhook deliveries")
+			s.jobs.flush(ctx)
+		case <-ctx.Done():
+		}
+	}
+
+	if s.gateway != nil {
+		_ = s.gateway.Close()
+	}
+	if s.config.Box != nil {
+		s.config.Box.Stop()
+	}
+
+	if s.db != nil {
+		return s.db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/errors"
+)
+
+type memLeasesDB struct {
+	holders map[string]string
+}
+
+func (m *memLeasesDB) Acquire(name string, holder string, _ time.Duration) (bool, error) {
+	current, found := m.holders[name]
+	if found && current != holder {
+		return false, nil
+	}
+	m.holders[name] = holder
+	return true, nil
+}
+
+func (m *memLeasesDB) Release(name string, holder string) error {
+	if m.holders[name] == holder {
+		delete(m.holders, name)
+	}
+	return nil
+}
+
+func (m *memLeasesDB) GetLease(name string) (*dao.Lease, error) {
+	holder, found := m.holders[name]
+	if !found {
+		return nil, errors.NotFound
+	}
+	return &dao.Lease{Name: name, Holder: holder}, nil
+}
+
+func TestDrainingMiddleware(t *testing.T) {
+	s := &Server{draining: make(chan struct{})}
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := s.drainingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	inFlight := httptest.NewRecorder()
+	go handler.ServeHTTP(inFlight, httptest.NewRequest(http.MethodGet, "/", nil))
+	<-entered
+
+	s.drainLock.Lock()
+	close(s.draining)
+	s.drainLock.Unlock()
+
+	rejected := httptest.NewRecorder()
+	handler.ServeHTTP(rejected, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rejected.Code != http.StatusServiceUnavailable {
+		t.Errorf("request received while draining got %d", rejected.Code)
+	}
+
+	close(release)
+	s.inFlight.Wait()
+	if inFlight.Code != http.StatusOK {
+		t.Errorf("in-flight request got %d", inFlight.Code)
+	}
+}
+
+func TestJobRunnerFlush(t *testing.T) {
+	leases := &memLeasesDB{holders: map[string]string{}}
+	runner := newJobRunner(leases, "instance", func(ctx context.Context) {
+		<-ctx.Done()
+	})
+
+	var flushed []string
+	runner.flushers = []singletonJob{
+		func(context.Context) {
+			if leases.holders[leaderLease] != "instance" {
+				t.Error("flushed after the lease was released")
+			}
+			flushed = append(flushed, "outbox")
+		},
+		func(context.Context) {
+			flushed = append(flushed, "webhooks")
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runner.run(ctx)
+		close(done)
+	}()
+
+	for !runner.IsLeader() {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	runner.flush(context.Background())
+	if len(flushed) != 2 || flushed[0] != "outbox" || flushed[1] != "webhooks" {
+		t.Errorf("flushers ran as %v", flushed)
+	}
+	if _, found := leases.holders[leaderLease]; found {
+		t.Error("lease not released after flush")
+	}
+
+	runner.flush(context.Background())
+	if len(flushed) != 2 {
+		t.Error("flushers ran twice")
+	}
+}
+
+func TestJobRunnerFlushNotLeader(t *testing.T) {
+	leases := &memLeasesDB{holders: map[string]string{leaderLease: "other"}}
+	runner := newJobRunner(leases, "instance")
+
+	flushed := false
+	runner.flushers = []singletonJob{func(context.Context) { flushed = true }}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runner.run(ctx)
+	runner.flush(context.Background())
+	if flushed {
+		t.Error("a follower flushed the leader jobs")
+	}
+	if leases.holders[leaderLease] != "other" {
+		t.Error("a follower released the leader lease")
+	}
+}
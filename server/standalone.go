@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/omecodes/common/utils/log"
+	"github.com/omecodes/libome"
+	"github.com/omecodes/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// startStandalone serves the gRPC API and the gateway without service box
+func (s *Server) startStandalone() error {
+	host := s.config.BindIP
+	if s.config.DevMode {
+		host = DevHost
+	}
+
+	var grpcOpts []grpc.ServerOption
+	dialOpts := []grpc.DialOption{grpc.WithInsecure()}
+	var gatewayTLS *tls.Config
+	if !s.config.DevMode {
+		var err error
+		s.gatewayCerts, err = newCertificateReloader(s.config)
+		if err != nil {
+			return err
+		}
+		gatewayTLS = s.gatewayCerts.serverTLS()
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(s.gatewayCerts.grpcTLS())))
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(s.gatewayCerts.pinnedTLS()))}
+	}
+
+	grpcListener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(s.config.GRPCPort)))
+	if err != nil {
+		return err
+	}
+
+	interceptors := service.NewInterceptorsChain(service.NewProxyBasicInterceptor())
+	grpcOpts = append(grpcOpts,
+		grpc.UnaryInterceptor(interceptors.InterceptUnary),
+		grpc.StreamInterceptor(interceptors.InterceptStream),
+	)
+	s.grpcServer = grpc.NewServer(grpcOpts...)
+	ome.RegisterApplicationsServer(s.grpcServer, s.gRPCHandler)
+	s.grpcServer.RegisterService(&eventsServiceDesc, s.handler)
+	s.grpcServer.RegisterService(&promotionsServiceDesc, s.handler)
+	s.grpcServer.RegisterService(&webhooksServiceDesc, s.handler)
+
+	log.Info("starting gRPC server", log.Field("service", gRPCServiceName), log.Field("address", grpcListener.Addr().String()))
+	go func() {
+		err := s.grpcServer.Serve(grpcListener)
+		if err != nil && err != grpc.ErrServerStopped {
+			log.Error("grpc server stopped", log.Err(err))
+		}
+	}()
+
+	mux := runtime.NewServeMux()
+	err = ome.RegisterApplicationsHandlerFromEndpoint(context.Background(), mux, grpcListener.Addr().String(), dialOpts)
+	if err != nil {
+		return err
+	}
+
+	gatewayListener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(s.config.WebPort)))
+	if err != nil {
+		return err
+	}
+
+	name := gatewayServiceName
+	if gatewayTLS != nil {
+		name = secureGatewayServiceName
+		gatewayListener = tls.NewListener(gatewayListener, gatewayTLS)
+	}
+
+	s.gateway = &http.Server{Handler: s.createRouter(mux)}
+	log.Info("starting HTTP server", log.Field("service-gateway", name), log.Field("address", gatewayListener.Addr().String()))
+	go func() {
+		err := s.gateway.Serve(gatewayListener)
+		if err != http.ErrServerClosed {
+			log.Error("http server stopped", log.Err(err))
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/omecodes/common/utils/log"
+)
+
+// Client certificate policies of the HTTPS gateway
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+const DefaultCertificateReloadInterval = 30 * time.Second
+
+// certificateReloader serves the configured certificate and client CAs, and reloads them when their files change
+type certificateReloader struct {
+	certFilename     string
+	keyFilename      string
+	clientCAFilename string
+	clientAuth       tls.ClientAuthType
+
+	mutex     sync.RWMutex
+	modTimes  []time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newCertificateReloader(cfg *Config) (*certificateReloader, error) {
+	r := &certificateReloader{
+		certFilename:     cfg.TLSCertFilename,
+		keyFilename:      cfg.TLSKeyFilename,
+		clientCAFilename: cfg.TLSClientCAFilename,
+	}
+
+	switch cfg.TLSClientAuth {
+	case "", ClientAuthNone:
+		r.clientAuth = tls.NoClientCert
+	case ClientAuthOptional:
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("tls: unknown client auth policy %q", cfg.TLSClientAuth)
+	}
+
+	if r.clientAuth != tls.NoClientCert && r.clientCAFilename == "" {
+		return nil, fmt.Errorf("tls: client auth policy %s requires a client CA file", cfg.TLSClientAuth)
+	}
+	return r, r.reload()
+}
+
+func (r *certificateReloader) files() []string {
+	files := []string{r.certFilename, r.keyFilename}
+	if r.clientCAFilename != "" {
+		files = append(files, r.clientCAFilename)
+	}
+	return files
+}
+
+func (r *certificateReloader) changed() (bool, []time.Time, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	changed := r.modTimes == nil
+	var modTimes []time.Time
+	for i, filename := range r.files() {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return false, nil, err
+		}
+		modTimes = append(modTimes, info.ModTime())
+		if !changed && !info.ModTime().Equal(r.modTimes[i]) {
+			changed = true
+		}
+	}
+	return changed, modTimes, nil
+}
+
+// reload loads the files if one of them changed. The previous certificate is kept when loading fails
+func (r *certificateReloader) reload() error {
+	changed, modTimes, err := r.changed()
+	if err != nil || !changed {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFilename, r.keyFilename)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFilename != "" {
+		caBytes, err := ioutil.ReadFile(r.clientCAFilename)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBytes) {
+			return fmt.Errorf("tls: no certificate found in %s", r.clientCAFilename)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *certificateReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := r.reload()
+		if err != nil {
+			log.Error("tls: could not reload gateway certificate", log.Err(err), log.Field("cert", r.certFilename))
+		}
+	}
+}
+
+// serverTLS returns the HTTPS listener configuration. Every handshake uses the last loaded certificate and client CAs
+func (r *certificateReloader) serverTLS() *tls.Config {
+	return r.config(r.clientAuth, nil)
+}
+
+// grpcTLS returns the gRPC listener configuration
+func (r *certificateReloader) grpcTLS() *tls.Config {
+	clientAuth := tls.NoClientCert
+	if r.clientCAFilename != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	return r.config(clientAuth, []string{"h2"})
+}
+
+func (r *certificateReloader) config(clientAuth tls.ClientAuthType, nextProtos []string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    r.clientCAs,
+			}, nil
+		},
+	}
+}
+
+// pinnedTLS is the configuration of the local connections to the served certificate
+func (r *certificateReloader) pinnedTLS() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], r.cert.Certificate[0]) {
+				return errors.New("tls: peer certificate is not the served certificate")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/app-registry/validation"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/common/utils/log"
+	"github.com/omecodes/libome"
+)
+
+// VerificationTokenPath is where publishers serve the verification token on the domain of their application
+const VerificationTokenPath = "/.well-known/ome-app-verification.txt"
+
+// ApplicationVerifiedDomainMetadata is the gRPC header carrying the verified domain of the application
+const ApplicationVerifiedDomainMetadata = "x-app-verified-domain"
+
+const (
+	verificationTimeout      = 10 * time.Second
+	verificationMaxTokenSize = 1024
+)
+
+// verificationChallenge is returned to the owner when a verification is requested
+type verificationChallenge struct {
+	*dao.Verification
+	URL string `json:"url"`
+}
+
+// applicationDomain returns the host of the application website
+func applicationDomain(a *ome.Application) (string, error) {
+	if a.Info == nil || a.Info.Website == "" {
+		return "", &oauth.InvalidFieldError{Field: "info.website", Reason: "is required to verify the domain"}
+	}
+
+	website, err := url.Parse(a.Info.Website)
+	if err != nil || website.Hostname() == "" {
+		return "", &oauth.InvalidFieldError{Field: "info.website", Reason: "must be an absolute URL"}
+	}
+	domain := strings.ToLower(website.Hostname())
+
+	if a.OauthCallbackUrl != "" {
+		callback, err := url.Parse(a.OauthCallbackUrl)
+		if err != nil {
+			return "", &oauth.InvalidFieldError{Field: "oauth_callback_url", Reason: "must be an absolute URL"}
+		}
+
+		host := strings.ToLower(callback.Hostname())
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			return "", &oauth.InvalidFieldError{Field: "oauth_callback_url", Reason: fmt.Sprintf("must be served by %s or one of its subdomains", domain)}
+		}
+	}
+	return domain, nil
+}
+
+// domainVerifier fetches the verification tokens from the application domains
+type domainVerifier struct {
+	allowLoopback bool
+}
+
+var (
+	errVerificationFailed = errors.New("verification token not found")
+
+	// refusedNetworks are the private, shared and local ranges the registry never sends verification requests to
+	refusedNetworks = parseCIDRs(
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+	)
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func (d *domainVerifier) allowed(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return d.allowLoopback
+	}
+	for _, network := range refusedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// url returns the token URL on the default HTTPS port of the website host
+func (d *domainVerifier) url(a *ome.Application) string {
+	website, _ := url.Parse(a.Info.Website)
+	if d.allowLoopback && validation.IsLoopback(website.Hostname()) {
+		return fmt.Sprintf("%s://%s%s", website.Scheme, website.Host, VerificationTokenPath)
+	}
+	return fmt.Sprintf("https://%s%s", website.Hostname(), VerificationTokenPath)
+}
+
+// verify checks that the application domain serves the token of v
+func (d *domainVerifier) verify(ctx context.Context, a *ome.Application, v *dao.Verification) error {
+	dialer := &net.Dialer{
+		Timeout: verificationTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !d.allowed(ip) {
+				return fmt.Errorf("address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+
+	client := &http.Client{
+		Timeout:   verificationTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	rawURL := d.url(a)
+	token, err := fetchVerificationToken(ctx, client, rawURL)
+	if err != nil {
+		log.Info("could not get verification token", log.Err(err), log.Field("app", a.Id), log.Field("url", rawURL))
+		return errVerificationFailed
+	}
+
+	if v.Token == "" || !secureCompare(token, v.Token) {
+		return errVerificationFailed
+	}
+	return nil
+}
+
+func fetchVerificationToken(ctx context.Context, client *http.Client, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, rsp.Body)
+		_ = rsp.Body.Close()
+	}()
+
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status %d", rsp.StatusCode)
+	}
+
+	token, err := ioutil.ReadAll(io.LimitReader(rsp.Body, verificationMaxTokenSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// currentVerification returns the verification of a, or nil if none was requested or it was revoked
+func (g *gRPCHandler) currentVerification(a *ome.Application) (*dao.Verification, error) {
+	v, err := g.verificationsDB.GetVerification(a.Id)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if v.Status == dao.VerificationRevoked {
+		return v, nil
+	}
+
+	domain, err := applicationDomain(a)
+	if err == nil && domain == v.Domain {
+		return v, nil
+	}
+
+	if v.Status == dao.VerificationVerified {
+		log.Info("application domain changed, verification revoked", log.Field("app", a.Id), log.Field("domain", v.Domain))
+	}
+	v.Status = dao.VerificationRevoked
+	v.Token = ""
+	v.RevokedAt = time.Now().Unix()
+	v.RevokeReason = "domain changed"
+	return v, g.verificationsDB.SaveVerification(v)
+}
+
+// verifiedDomain returns the verified domain of a, or an empty string
+func (g *gRPCHandler) verifiedDomain(a *ome.Application) (string, error) {
+	v, err := g.currentVerification(a)
+	if err != nil || v == nil || v.Status != dao.VerificationVerified {
+		return "", err
+	}
+	return v.Domain, nil
+}
+
+// getVerification returns the verification status of an application
+func (s *Server) getVerification(w http.ResponseWriter, r *http.Request) {
+	_, err := s.handler.appCredentials(requestContext(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	a, err := s.appsDB.GetApplication(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	v, err := s.handler.currentVerification(a)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if v == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	v.Token = ""
+	httpx.WriteJSON(w, http.StatusOK, v)
+}
+
+// requestVerification gives the owner a token to publish at VerificationTokenPath on the application domain
+func (s *Server) requestVerification(w http.ResponseWriter, r *http.Request) {
+	_, a, err := s.ownedApplication(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	domain, err := applicationDomain(a)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	v, err := s.handler.currentVerification(a)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if v != nil && v.Status == dao.VerificationVerified {
+		v.Token = ""
+		httpx.WriteJSON(w, http.StatusOK, v)
+		return
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	v = &dao.Verification{
+		ApplicationID: a.Id,
+		Domain:        domain,
+		Token:         token,
+		Status:        dao.VerificationPending,
+		CreatedAt:     time.Now().Unix(),
+	}
+	err = s.verificationsDB.SaveVerification(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusCreated, &verificationChallenge{Verification: v, URL: s.verifier.url(a)})
+}
+
+// checkVerification fetches the token from the application domain and marks the domain verified when it matches
+func (s *Server) checkVerification(w http.ResponseWriter, r *http.Request) {
+	_, a, err := s.ownedApplication(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	v, err := s.handler.currentVerification(a)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if v == nil || v.Status == dao.VerificationRevoked {
+		writeVerificationFailure(w, "no verification requested for the current domain")
+		return
+	}
+
+	if v.Status == dao.VerificationVerified {
+		v.Token = ""
+		httpx.WriteJSON(w, http.StatusOK, v)
+		return
+	}
+
+	err = s.verifier.verify(r.Context(), a, v)
+	if err != nil {
+		writeVerificationFailure(w, fmt.Sprintf("%s does not serve the verification token", s.verifier.url(a)))
+		return
+	}
+
+	v.Status = dao.VerificationVerified
+	v.Token = ""
+	v.VerifiedAt = time.Now().Unix()
+	err = s.verificationsDB.SaveVerification(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	log.Info("application domain verified", log.Field("app", a.Id), log.Field("domain", v.Domain))
+	httpx.WriteJSON(w, http.StatusOK, v)
+}
+
+func writeVerificationFailure(w http.ResponseWriter, description string) {
+	httpx.WriteJSON(w, http.StatusUnprocessableEntity, map[string]string{
+		"error":             "verification_failed",
+		"error_description": description,
+	})
+}
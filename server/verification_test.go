@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+)
+
+type memVerificationsDB struct {
+	verifications map[string]*dao.Verification
+}
+
+func (m *memVerificationsDB) SaveVerification(v *dao.Verification) error {
+	copied := *v
+	m.verifications[v.ApplicationID] = &copied
+	return nil
+}
+
+func (m *memVerificationsDB) GetVerification(applicationID string) (*dao.Verification, error) {
+	v, found := m.verifications[applicationID]
+	if !found {
+		return nil, errors.NotFound
+	}
+	copied := *v
+	return &copied, nil
+}
+
+func (m *memVerificationsDB) DeleteVerification(applicationID string) error {
+	delete(m.verifications, applicationID)
+	return nil
+}
+
+func tokenServer(token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != VerificationTokenPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprintln(w, token)
+	}))
+}
+
+func websiteApplication(website string) *ome.Application {
+	return &ome.Application{Id: "app", Info: &ome.AppInfo{Website: website}}
+}
+
+func TestDomainVerifierVerify(t *testing.T) {
+	srv := tokenServer("token")
+	defer srv.Close()
+
+	redirecting := httptest.NewServer(http.RedirectHandler(srv.URL+VerificationTokenPath, http.StatusFound))
+	defer redirecting.Close()
+
+	tests := []struct {
+		name          string
+		website       string
+		token         string
+		allowLoopback bool
+		verified      bool
+	}{
+		{"matching token", srv.URL, "token", true, true},
+		{"wrong token", srv.URL, "other", true, false},
+		{"empty token", srv.URL, "", true, false},
+		{"redirect", redirecting.URL, "token", true, false},
+		{"loopback outside dev mode", srv.URL, "token", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := &domainVerifier{allowLoopback: test.allowLoopback}
+			err := verifier.verify(context.Background(), websiteApplication(test.website), &dao.Verification{Token: test.token})
+			if test.verified && err != nil {
+				t.Fatalf("verification failed: %s", err)
+			}
+			if !test.verified && err == nil {
+				t.Fatal("verification succeeded")
+			}
+		})
+	}
+}
+
+func TestDomainVerifierURL(t *testing.T) {
+	tests := []struct {
+		website       string
+		allowLoopback bool
+		url           string
+	}{
+		{"https://example.com:8443/home", false, "https://example.com" + VerificationTokenPath},
+		{"http://example.com", true, "https://example.com" + VerificationTokenPath},
+		{"http://127.0.0.1:8080", false, "https://127.0.0.1" + VerificationTokenPath},
+		{"http://127.0.0.1:8080", true, "http://127.0.0.1:8080" + VerificationTokenPath},
+	}
+
+	for _, test := range tests {
+		verifier := &domainVerifier{allowLoopback: test.allowLoopback}
+		if u := verifier.url(websiteApplication(test.website)); u != test.url {
+			t.Errorf("%s: got %s, expected %s", test.website, u, test.url)
+		}
+	}
+}
+
+func TestDomainVerifierAllowed(t *testing.T) {
+	verifier := &domainVerifier{}
+	for _, ip := range []string{"10.1.2.3", "172.20.0.1", "192.168.1.1", "100.64.0.1", "169.254.169.254", "224.0.0.1", "127.0.0.1", "::1", "fd00::1", "ff02::1", "0.0.0.0"} {
+		if verifier.allowed(parseIP(t, ip)) {
+			t.Errorf("%s is allowed", ip)
+		}
+	}
+	for _, ip := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"} {
+		if !verifier.allowed(parseIP(t, ip)) {
+			t.Errorf("%s is refused", ip)
+		}
+	}
+}
+
+func TestApplicationDomain(t *testing.T) {
+	tests := []struct {
+		website  string
+		callback string
+		domain   string
+	}{
+		{"https://Example.com", "", "example.com"},
+		{"https://example.com", "https://auth.example.com/callback", "example.com"},
+		{"https://example.com", "https://example.org/callback", ""},
+		{"https://example.com", "https://badexample.com/callback", ""},
+		{"", "", ""},
+	}
+
+	for _, test := range tests {
+		a := websiteApplication(test.website)
+		a.OauthCallbackUrl = test.callback
+		domain, err := applicationDomain(a)
+		if domain != test.domain || (test.domain == "") != (err != nil) {
+			t.Errorf("%s %s: got %q, %v", test.website, test.callback, domain, err)
+		}
+	}
+}
+
+func TestVerificationRevokedOnDomainChange(t *testing.T) {
+	db := &memVerificationsDB{verifications: map[string]*dao.Verification{}}
+	_ = db.SaveVerification(&dao.Verification{ApplicationID: "app", Domain: "example.com", Status: dao.VerificationVerified})
+	g := &gRPCHandler{verificationsDB: db}
+
+	domain, err := g.verifiedDomain(websiteApplication("https://www.example.com"))
+	if err != nil || domain != "" {
+		t.Fatalf("other host is verified: %q, %v", domain, err)
+	}
+
+	stored, _ := db.GetVerification("app")
+	if stored.Status != dao.VerificationRevoked {
+		t.Fatalf("verification is %s", stored.Status)
+	}
+
+	domain, _ = g.verifiedDomain(websiteApplication("https://example.com"))
+	if domain != "" {
+		t.Fatal("revoked verification is restored")
+	}
+}
+
+func TestVerifiedDomain(t *testing.T) {
+	db := &memVerificationsDB{verifications: map[string]*dao.Verification{}}
+	_ = db.SaveVerification(&dao.Verification{ApplicationID: "app", Domain: "example.com", Status: dao.VerificationVerified})
+	g := &gRPCHandler{verificationsDB: db}
+
+	domain, err := g.verifiedDomain(websiteApplication("https://example.com/about"))
+	if err != nil || domain != "example.com" {
+		t.Fatalf("got %q, %v", domain, err)
+	}
+}
+
+func parseIP(t *testing.T, s string) net.IP {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		t.Fatalf("invalid ip %s", s)
+	}
+	return ip
+}
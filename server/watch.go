@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	watchPollInterval = time.Second
+	watchBatchSize    = 100
+	// latestRevision starts a watch after the last recorded event
+	latestRevision int64 = -1
+)
+
+// The applications events service is declared by hand as the Applications service definition is shared
+const (
+	EventsServiceName      = "ome.ApplicationEvents"
+	WatchApplicationsRoute = "/" + EventsServiceName + "/WatchApplications"
+)
+
+type applicationEventsServer interface {
+	WatchApplications(in *structpb.Struct, stream grpc.ServerStream) error
+}
+
+var eventsServiceDesc = grpc.ServiceDesc{
+	ServiceName: EventsServiceName,
+	HandlerType: (*applicationEventsServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchApplications",
+			Handler:       watchApplicationsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "app-registry/events",
+}
+
+func watchApplicationsHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(structpb.Struct)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(applicationEventsServer).WatchApplications(in, stream)
+}
+
+func (g *gRPCHandler) WatchApplications(in *structpb.Struct, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	filter, err := g.eventsFilter(ctx)
+	if err != nil {
+		return err
+	}
+
+	revision := latestRevision
+	if v, found := in.Fields["revision"]; found {
+		if _, isString := v.Kind.(*structpb.Value_StringValue); !isString {
+			return errors.BadInput
+		}
+		revision, err = parseRevision(v.GetStringValue())
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.watch(ctx, revision, filter, func(e *dao.ApplicationEvent) error {
+		msg, err := jsonStruct(e)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg(msg)
+	})
+}
+
+// eventsFilter applies the ListApplications visibility rules to the events
+func (g *gRPCHandler) eventsFilter(ctx context.Context) (func(e *dao.ApplicationEvent) bool, error) {
+	a, err := g.appCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch a.Level {
+	case ome.ApplicationLevel_Root:
+		return func(e *dao.ApplicationEvent) bool {
+			return true
+		}, nil
+
+	case ome.ApplicationLevel_Master:
+		token, err := g.userToken(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		user := token.Claims.Sub
+		return func(e *dao.ApplicationEvent) bool {
+			return e.CreatedBy() == user
+		}, nil
+
+	default:
+		return func(e *dao.ApplicationEvent) bool {
+			return e.ApplicationID == a.Id
+		}, nil
+	}
+}
+
+// watch polls the events recorded after revision and sends the ones accepted by filter
+func (g *gRPCHandler) watch(ctx context.Context, revision int64, filter func(e *dao.ApplicationEvent) bool, send func(e *dao.ApplicationEvent) error) error {
+	var err error
+	if revision == latestRevision {
+		revision, err = g.appsDB.LatestRevision()
+		if err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		events, err := g.appsDB.GetEvents(revision, watchBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			revision = e.Revision
+			if !filter(e) {
+				continue
+			}
+
+			err = send(e)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(events) == watchBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-g.draining:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseRevision parses the revision a watch resumes from. 0 replays every event
+func parseRevision(value string) (int64, error) {
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision < 0 {
+		return 0, errors.BadInput
+	}
+	return revision, nil
+}
+
+// jsonStruct returns the struct with the JSON fields of v
+func jsonStruct(v interface{}) (*structpb.Struct, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	err = json.Unmarshal(encoded, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+// WatchApplications passes the events received from the registry on cc to handle. A negative revision only
+// streams the changes made after the call
+func WatchApplications(ctx context.Context, cc grpc.ClientConnInterface, revision int64, handle func(e *dao.ApplicationEvent) error, opts ...grpc.CallOption) error {
+	stream, err := cc.NewStream(ctx, &eventsServiceDesc.Streams[0], WatchApplicationsRoute, opts...)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{}
+	if revision >= 0 {
+		fields["revision"] = strconv.FormatInt(revision, 10)
+	}
+	in, err := structpb.NewStruct(fields)
+	if err != nil {
+		return err
+	}
+
+	if err = stream.SendMsg(in); err != nil {
+		return err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		msg := new(structpb.Struct)
+		err = stream.RecvMsg(msg)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := msg.MarshalJSON()
+		if err != nil {
+			return err
+		}
+
+		e := new(dao.ApplicationEvent)
+		err = json.Unmarshal(encoded, e)
+		if err != nil {
+			return err
+		}
+
+		err = handle(e)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// streamApplicationEvents is the server-sent events equivalent of WatchApplications
+func (s *Server) streamApplicationEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, errors.NotSupported)
+		return
+	}
+
+	revision := latestRevision
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("revision")
+	}
+	if cursor != "" {
+		var err error
+		revision, err = parseRevision(cursor)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	ctx := requestContext(r)
+	filter, err := s.handler.eventsFilter(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	_ = s.handler.watch(ctx, revision, filter, func(e *dao.ApplicationEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Revision, e.Type, data)
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
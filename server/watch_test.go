@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// eventsApplicationsDB serves the events of its applications
+type eventsApplicationsDB struct {
+	*memApplicationsDB
+	events []*dao.ApplicationEvent
+}
+
+func (m *eventsApplicationsDB) LatestRevision() (int64, error) {
+	if len(m.events) == 0 {
+		return 0, nil
+	}
+	return m.events[len(m.events)-1].Revision, nil
+}
+
+func (m *eventsApplicationsDB) GetEvents(afterRevision int64, limit int) ([]*dao.ApplicationEvent, error) {
+	var events []*dao.ApplicationEvent
+	for _, e := range m.events {
+		if e.Revision > afterRevision && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// eventsRecorder is the server stream of WatchApplications
+type eventsRecorder struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*structpb.Struct
+}
+
+func (e *eventsRecorder) Context() context.Context { return e.ctx }
+
+func (e *eventsRecorder) SendMsg(m interface{}) error {
+	e.sent = append(e.sent, m.(*structpb.Struct))
+	return nil
+}
+
+func TestWatchApplicationsRevision(t *testing.T) {
+	g := newRedactionHandler()
+	g.appsDB = &eventsApplicationsDB{
+		memApplicationsDB: g.appsDB.(*memApplicationsDB),
+		events: []*dao.ApplicationEvent{
+			{Revision: 1, Type: dao.EventCreate, ApplicationID: "a"},
+			{Revision: 2, Type: dao.EventUpdate, ApplicationID: "a"},
+			{Revision: 3, Type: dao.EventDelete, ApplicationID: "a"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		fields  map[string]interface{}
+		want    []float64
+		wantErr bool
+	}{
+		{name: "latest", fields: map[string]interface{}{}},
+		{name: "from the beginning", fields: map[string]interface{}{"revision": "0"}, want: []float64{1, 2, 3}},
+		{name: "resumed", fields: map[string]interface{}{"revision": "2"}, want: []float64{3}},
+		{name: "number", fields: map[string]interface{}{"revision": 2}, wantErr: true},
+		{name: "negative", fields: map[string]interface{}{"revision": "-1"}, wantErr: true},
+		{name: "not a number", fields: map[string]interface{}{"revision": "two"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := structpb.NewStruct(tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(callerContext("root", ""), 50*time.Millisecond)
+			defer cancel()
+
+			stream := &eventsRecorder{ctx: ctx}
+			err = g.WatchApplications(in, stream)
+			if tt.wantErr {
+				if err != errors.BadInput {
+					t.Errorf("WatchApplications() = %v, want errors.BadInput", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var revisions []float64
+			for _, msg := range stream.sent {
+				revisions = append(revisions, msg.Fields["revision"].GetNumberValue())
+			}
+			if len(revisions) != len(tt.want) {
+				t.Fatalf("revisions sent are %v, want %v", revisions, tt.want)
+			}
+			for i := range revisions {
+				if revisions[i] != tt.want[i] {
+					t.Errorf("revisions sent are %v, want %v", revisions, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRevision(t *testing.T) {
+	revision, err := parseRevision("9007199254740993")
+	if err != nil || revision != 9007199254740993 {
+		t.Errorf("parseRevision() = %d, %v", revision, err)
+	}
+}
@@ -0,0 +1,593 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/app-registry/oauth"
+	"github.com/omecodes/common/errors"
+	"github.com/omecodes/common/httpx"
+	"github.com/omecodes/common/utils/log"
+	"github.com/omecodes/libome"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Webhook request headers
+const (
+	WebhookDeliveryHeader  = "X-Ome-Webhook-Delivery"
+	WebhookEventHeader     = "X-Ome-Webhook-Event"
+	WebhookTimestampHeader = "X-Ome-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Ome-Webhook-Signature"
+)
+
+const (
+	webhookPollInterval = time.Second
+	webhookBatchSize    = 50
+	webhookTimeout      = 10 * time.Second
+	webhookMaxAttempts  = 8
+	webhookBaseBackoff  = 10 * time.Second
+	webhookMaxBackoff   = time.Hour
+)
+
+var webhookEventTypes = map[string]bool{
+	dao.EventCreate:     true,
+	dao.EventUpdate:     true,
+	dao.EventDelete:     true,
+	dao.EventActivate:   true,
+	dao.EventDeactivate: true,
+
+	dao.EventPromotionRequested: true,
+	dao.EventPromotionApproved:  true,
+	dao.EventPromotionRejected:  true,
+}
+
+// WebhookPayload is the body of the webhook requests
+type WebhookPayload struct {
+	DeliveryID string                `json:"delivery_id"`
+	Event      *dao.ApplicationEvent `json:"event"`
+}
+
+// WebhookSignature signs the timestamp header value, a dot and the webhook body
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	return challengeSignature(secret, append([]byte(timestamp+"."), body...))
+}
+
+// VerifyWebhookSignature checks the signature of a webhook request body
+func VerifyWebhookSignature(secret string, timestamp string, body []byte, signature string) bool {
+	return secret != "" && secureCompare(WebhookSignature(secret, timestamp, body), signature)
+}
+
+// NewWebhookSubscription validates the subscription parameters. A secret is generated when none is given
+func NewWebhookSubscription(rawURL string, eventTypes []string, secret string, createdBy string) (*dao.WebhookSubscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, &oauth.InvalidFieldError{Field: "url", Reason: "must be an absolute http or https URL"}
+	}
+
+	for _, t := range eventTypes {
+		if !webhookEventTypes[t] {
+			return nil, &oauth.InvalidFieldError{Field: "event_types", Reason: fmt.Sprintf("unknown event type %s", t)}
+		}
+	}
+
+	if secret == "" {
+		secret, err = randomToken(32)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := randomID(8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dao.WebhookSubscription{
+		ID:         id,
+		URL:        rawURL,
+		EventTypes: eventTypes,
+		Secret:     secret,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now().Unix(),
+	}, nil
+}
+
+// Redeliver moves the dead letter back to the delivery queue for an immediate attempt
+func Redeliver(db dao.WebhooksDB, deliveryID string) error {
+	delivery, err := db.GetDeadLetter(deliveryID)
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts = 0
+	delivery.LastError = ""
+	delivery.NextAttempt = time.Now().Unix()
+	return db.Revive(delivery)
+}
+
+// webhookBackoff returns the delay before the next delivery attempt
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+// webhookDispatcher turns the application events into deliveries and sends the due deliveries
+type webhookDispatcher struct {
+	db     dao.WebhooksDB
+	client *http.Client
+}
+
+func newWebhookDispatcher(db dao.WebhooksDB) *webhookDispatcher {
+	return &webhookDispatcher{
+		db:     db,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (d *webhookDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := d.deliver(ctx)
+		if err != nil {
+			log.Error("webhooks: could not send deliveries", log.Err(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush sends the due deliveries once
+func (d *webhookDispatcher) flush(ctx context.Context) {
+	err := d.deliver(ctx)
+	if err != nil {
+		log.Error("webhooks: could not flush deliveries", log.Err(err))
+	}
+}
+
+// Publish enqueues a delivery of the event per interested subscription
+func (d *webhookDispatcher) Publish(e *dao.ApplicationEvent) error {
+	subscriptions, err := d.db.GetSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.Accepts(e.Type) {
+			continue
+		}
+
+		err = d.db.Enqueue(&dao.WebhookDelivery{
+			ID:             fmt.Sprintf("%s-%d", subscription.ID, e.Revision),
+			SubscriptionID: subscription.ID,
+			Event:          e,
+			NextAttempt:    e.Time,
+			CreatedAt:      time.Now().Unix(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *webhookDispatcher) deliver(ctx context.Context) error {
+	deliveries, err := d.db.DueDeliveries(time.Now().Unix(), webhookBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		subscription, err := d.db.GetSubscription(delivery.SubscriptionID)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				err = d.db.DeleteDelivery(delivery.ID)
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = d.send(ctx, subscription, delivery)
+		if err == nil {
+			err = d.db.DeleteDelivery(delivery.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= webhookMaxAttempts {
+			log.Info("webhooks: delivery moved to dead letters", log.Field("delivery", delivery.ID), log.Err(err))
+			err = d.db.Kill(delivery)
+		} else {
+			delivery.NextAttempt = time.Now().Add(webhookBackoff(delivery.Attempts)).Unix()
+			err = d.db.UpdateDelivery(delivery)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *webhookDispatcher) send(ctx context.Context, subscription *dao.WebhookSubscription, delivery *dao.WebhookDelivery) error {
+	body, err := json.Marshal(&WebhookPayload{
+		DeliveryID: delivery.ID,
+		Event:      delivery.Event,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookEventHeader, delivery.Event.Type)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(subscription.Secret, timestamp, body))
+
+	rsp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, rsp.Body)
+		_ = rsp.Body.Close()
+	}()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %d", rsp.StatusCode)
+	}
+	return nil
+}
+
+// rootApplication checks that the caller is a root application
+func (g *gRPCHandler) rootApplication(ctx context.Context) (*ome.Application, error) {
+	a, err := g.appCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Level != ome.ApplicationLevel_Root {
+		return nil, errors.Unauthorized
+	}
+	return a, nil
+}
+
+// listWebhooks returns the subscriptions without their secret
+func (g *gRPCHandler) listWebhooks(ctx context.Context) ([]*dao.WebhookSubscription, error) {
+	_, err := g.rootApplication(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions, err := g.webhooksDB.GetSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if subscriptions == nil {
+		subscriptions = []*dao.WebhookSubscription{}
+	}
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+	return subscriptions, nil
+}
+
+// createWebhook registers a subscription. The secret is only returned on creation
+func (g *gRPCHandler) createWebhook(ctx context.Context, params *dao.WebhookSubscription) (*dao.WebhookSubscription, error) {
+	a, err := g.rootApplication(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := NewWebhookSubscription(params.URL, params.EventTypes, params.Secret, a.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.webhooksDB.SaveSubscription(subscription)
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (g *gRPCHandler) deleteWebhook(ctx context.Context, id string) error {
+	_, err := g.rootApplication(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.webhooksDB.GetSubscription(id)
+	if err != nil {
+		return err
+	}
+	return g.webhooksDB.DeleteSubscription(id)
+}
+
+func (g *gRPCHandler) listDeadLetters(ctx context.Context) ([]*dao.WebhookDelivery, error) {
+	_, err := g.rootApplication(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := g.webhooksDB.GetDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	if deliveries == nil {
+		deliveries = []*dao.WebhookDelivery{}
+	}
+	return deliveries, nil
+}
+
+func (g *gRPCHandler) redeliverWebhook(ctx context.Context, id string) error {
+	_, err := g.rootApplication(ctx)
+	if err != nil {
+		return err
+	}
+	return Redeliver(g.webhooksDB, id)
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := s.handler.listWebhooks(requestContext(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, subscriptions)
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	params := new(dao.WebhookSubscription)
+	err := json.NewDecoder(r.Body).Decode(params)
+	if err != nil {
+		writeError(w, errors.BadInput)
+		return
+	}
+
+	subscription, err := s.handler.createWebhook(requestContext(r), params)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusCreated, subscription)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	err := s.handler.deleteWebhook(requestContext(r), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deliveries, err := s.handler.listDeadLetters(requestContext(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	httpx.WriteJSON(w, http.StatusOK, deliveries)
+}
+
+func (s *Server) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	err := s.handler.redeliverWebhook(requestContext(r), mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// The webhooks service is declared by hand, as the promotions service
+const (
+	WebhooksServiceName   = "ome.ApplicationWebhooks"
+	ListWebhooksRoute     = "/" + WebhooksServiceName + "/ListWebhooks"
+	CreateWebhookRoute    = "/" + WebhooksServiceName + "/CreateWebhook"
+	DeleteWebhookRoute    = "/" + WebhooksServiceName + "/DeleteWebhook"
+	ListDeadLettersRoute  = "/" + WebhooksServiceName + "/ListDeadLetters"
+	RedeliverWebhookRoute = "/" + WebhooksServiceName + "/RedeliverWebhook"
+)
+
+type applicationWebhooksServer interface {
+	ListWebhooks(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	CreateWebhook(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	DeleteWebhook(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	ListDeadLetters(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+	RedeliverWebhook(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)
+}
+
+var webhooksServiceDesc = grpc.ServiceDesc{
+	ServiceName: WebhooksServiceName,
+	HandlerType: (*applicationWebhooksServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListWebhooks",
+			Handler: webhooksMethodHandler(ListWebhooksRoute, func(srv applicationWebhooksServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.ListWebhooks(ctx, in)
+			}),
+		},
+		{
+			MethodName: "CreateWebhook",
+			Handler: webhooksMethodHandler(CreateWebhookRoute, func(srv applicationWebhooksServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.CreateWebhook(ctx, in)
+			}),
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler: webhooksMethodHandler(DeleteWebhookRoute, func(srv applicationWebhooksServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.DeleteWebhook(ctx, in)
+			}),
+		},
+		{
+			MethodName: "ListDeadLetters",
+			Handler: webhooksMethodHandler(ListDeadLettersRoute, func(srv applicationWebhooksServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.ListDeadLetters(ctx, in)
+			}),
+		},
+		{
+			MethodName: "RedeliverWebhook",
+			Handler: webhooksMethodHandler(RedeliverWebhookRoute, func(srv applicationWebhooksServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+				return srv.RedeliverWebhook(ctx, in)
+			}),
+		},
+	},
+	Metadata: "app-registry/webhooks",
+}
+
+// webhooksMethodHandler decodes the request struct and calls the method through the server interceptor
+func webhooksMethodHandler(route string, call func(srv applicationWebhooksServer, ctx context.Context, in *structpb.Struct) (*structpb.Struct, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(applicationWebhooksServer), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: route}
+		return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(applicationWebhooksServer), ctx, req.(*structpb.Struct))
+		})
+	}
+}
+
+type webhookList struct {
+	Webhooks []*dao.WebhookSubscription `json:"webhooks"`
+}
+
+type deadLetterList struct {
+	DeadLetters []*dao.WebhookDelivery `json:"dead_letters"`
+}
+
+func (g *gRPCHandler) ListWebhooks(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	subscriptions, err := g.listWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return jsonStruct(&webhookList{Webhooks: subscriptions})
+}
+
+func (g *gRPCHandler) CreateWebhook(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	params := &dao.WebhookSubscription{
+		URL:    in.Fields["url"].GetStringValue(),
+		Secret: in.Fields["secret"].GetStringValue(),
+	}
+	for _, v := range in.Fields["event_types"].GetListValue().GetValues() {
+		params.EventTypes = append(params.EventTypes, v.GetStringValue())
+	}
+
+	subscription, err := g.createWebhook(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return jsonStruct(subscription)
+}
+
+func (g *gRPCHandler) DeleteWebhook(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	err := g.deleteWebhook(ctx, in.Fields["id"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+	return &structpb.Struct{}, nil
+}
+
+func (g *gRPCHandler) ListDeadLetters(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	deliveries, err := g.listDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return jsonStruct(&deadLetterList{DeadLetters: deliveries})
+}
+
+func (g *gRPCHandler) RedeliverWebhook(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	err := g.redeliverWebhook(ctx, in.Fields["id"].GetStringValue())
+	if err != nil {
+		return nil, err
+	}
+	return &structpb.Struct{}, nil
+}
+
+// ListWebhooks calls the registry ListWebhooks RPC on cc
+func ListWebhooks(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) ([]*dao.WebhookSubscription, error) {
+	list := new(webhookList)
+	err := invokeStruct(ctx, cc, ListWebhooksRoute, nil, list, opts...)
+	return list.Webhooks, err
+}
+
+// CreateWebhook calls the registry CreateWebhook RPC on cc. The returned subscription holds the secret
+func CreateWebhook(ctx context.Context, cc grpc.ClientConnInterface, url string, eventTypes []string, secret string, opts ...grpc.CallOption) (*dao.WebhookSubscription, error) {
+	types := make([]interface{}, len(eventTypes))
+	for i, t := range eventTypes {
+		types[i] = t
+	}
+
+	subscription := new(dao.WebhookSubscription)
+	err := invokeStruct(ctx, cc, CreateWebhookRoute, map[string]interface{}{
+		"url":         url,
+		"event_types": types,
+		"secret":      secret,
+	}, subscription, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// DeleteWebhook calls the registry DeleteWebhook RPC on cc
+func DeleteWebhook(ctx context.Context, cc grpc.ClientConnInterface, id string, opts ...grpc.CallOption) error {
+	return invokeStruct(ctx, cc, DeleteWebhookRoute, map[string]interface{}{"id": id}, &struct{}{}, opts...)
+}
+
+// ListDeadLetters calls the registry ListDeadLetters RPC on cc
+func ListDeadLetters(ctx context.Context, cc grpc.ClientConnInterface, opts ...grpc.CallOption) ([]*dao.WebhookDelivery, error) {
+	list := new(deadLetterList)
+	err := invokeStruct(ctx, cc, ListDeadLettersRoute, nil, list, opts...)
+	return list.DeadLetters, err
+}
+
+// RedeliverWebhook calls the registry RedeliverWebhook RPC on cc
+func RedeliverWebhook(ctx context.Context, cc grpc.ClientConnInterface, deliveryID string, opts ...grpc.CallOption) error {
+	return invokeStruct(ctx, cc, RedeliverWebhookRoute, map[string]interface{}{"id": deliveryID}, &struct{}{}, opts...)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/omecodes/app-registry/dao"
+	"github.com/omecodes/common/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type memWebhooksDB struct {
+	dao.WebhooksDB
+	subscriptions map[string]*dao.WebhookSubscription
+	deadLetters   map[string]*dao.WebhookDelivery
+	revived       []string
+}
+
+func (m *memWebhooksDB) SaveSubscription(subscription *dao.WebhookSubscription) error {
+	copied := *subscription
+	m.subscriptions[subscription.ID] = &copied
+	return nil
+}
+
+func (m *memWebhooksDB) GetSubscription(id string) (*dao.WebhookSubscription, error) {
+	subscription, found := m.subscriptions[id]
+	if !found {
+		return nil, errors.NotFound
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (m *memWebhooksDB) GetSubscriptions() ([]*dao.WebhookSubscription, error) {
+	var subscriptions []*dao.WebhookSubscription
+	for id := range m.subscriptions {
+		subscription, _ := m.GetSubscription(id)
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (m *memWebhooksDB) DeleteSubscription(id string) error {
+	delete(m.subscriptions, id)
+	return nil
+}
+
+func (m *memWebhooksDB) GetDeadLetters() ([]*dao.WebhookDelivery, error) {
+	var deliveries []*dao.WebhookDelivery
+	for _, delivery := range m.deadLetters {
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (m *memWebhooksDB) GetDeadLetter(id string) (*dao.WebhookDelivery, error) {
+	delivery, found := m.deadLetters[id]
+	if !found {
+		return nil, errors.NotFound
+	}
+	return delivery, nil
+}
+
+func (m *memWebhooksDB) Revive(delivery *dao.WebhookDelivery) error {
+	delete(m.deadLetters, delivery.ID)
+	m.revived = append(m.revived, delivery.ID)
+	return nil
+}
+
+func TestWebhooksService(t *testing.T) {
+	webhooks := &memWebhooksDB{
+		subscriptions: map[string]*dao.WebhookSubscription{},
+		deadLetters:   map[string]*dao.WebhookDelivery{"d1": {ID: "d1", SubscriptionID: "w", Attempts: 8}},
+	}
+	g := newRedactionHandler()
+	g.webhooksDB = webhooks
+
+	listener := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(callerContext("root", ""), req)
+	}))
+	gs.RegisterService(&webhooksServiceDesc, g)
+	go func() {
+		_ = gs.Serve(listener)
+	}()
+	defer gs.Stop()
+
+	cc, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cc.Close()
+	}()
+	ctx := context.Background()
+
+	created, err := CreateWebhook(ctx, cc, "https://hooks.example.com", []string{dao.EventCreate}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret == "" || created.CreatedBy != "root" || len(created.EventTypes) != 1 {
+		t.Errorf("CreateWebhook returned %+v", created)
+	}
+
+	subscriptions, err := ListWebhooks(ctx, cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscriptions) != 1 || subscriptions[0].ID != created.ID || subscriptions[0].Secret != "" {
+		t.Errorf("ListWebhooks returned %+v", subscriptions)
+	}
+
+	deliveries, err := ListDeadLetters(ctx, cc)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("ListDeadLetters returned %d deliveries, %v", len(deliveries), err)
+	}
+	if err := RedeliverWebhook(ctx, cc, "d1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks.revived) != 1 || webhooks.revived[0] != "d1" {
+		t.Errorf("revived deliveries are %v", webhooks.revived)
+	}
+
+	if err := DeleteWebhook(ctx, cc, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks.subscriptions) != 0 {
+		t.Error("the subscription was not deleted")
+	}
+	if err := DeleteWebhook(ctx, cc, created.ID); err == nil {
+		t.Error("an unknown subscription was deleted")
+	}
+}
+
+func TestWebhooksRequireRoot(t *testing.T) {
+	g := newRedactionHandler()
+	g.webhooksDB = &memWebhooksDB{subscriptions: map[string]*dao.WebhookSubscription{}}
+
+	for _, caller := range []string{"master", "alice-app"} {
+		if _, err := g.ListWebhooks(callerContext(caller, ""), nil); err != errors.Unauthorized {
+			t.Errorf("ListWebhooks() = %v for %s", err, caller)
+		}
+		if _, err := g.CreateWebhook(callerContext(caller, ""), &structpb.Struct{}); err != errors.Unauthorized {
+			t.Errorf("CreateWebhook() = %v for %s", err, caller)
+		}
+	}
+	if _, err := g.ListWebhooks(callerContext("root", ""), nil); err != nil {
+		t.Errorf("ListWebhooks() = %v for root", err)
+	}
+}
@@ -0,0 +1,212 @@
+package validation
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/omecodes/libome"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	MinIDLength          = 2
+	MaxIDLength          = 64
+	MaxLabelLength       = 64
+	MaxDescriptionLength = 1024
+	MaxURLLength         = 2048
+	MaxSecretLength      = 256
+	// MinSecretLength and MinSecretCharacters are the length and the number of distinct characters of a strong secret
+	MinSecretLength     = 32
+	MinSecretCharacters = 12
+)
+
+var idPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// ReservedIDs are the application IDs only the registry operators can register
+var ReservedIDs = []string{"ome", "registry", "admin", "root"}
+
+// IsReserved tells whether id is one of the ReservedIDs
+func IsReserved(id string) bool {
+	for _, reserved := range ReservedIDs {
+		if strings.EqualFold(id, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldError describes why a field value is rejected
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Error lists the rejected fields of an application definition
+type Error struct {
+	Fields []*FieldError
+}
+
+func (e *Error) Error() string {
+	var parts []string
+	for _, f := range e.Fields {
+		parts = append(parts, fmt.Sprintf("%s: %s", f.Field, f.Reason))
+	}
+	return "invalid application: " + strings.Join(parts, "; ")
+}
+
+// GRPCStatus makes the gRPC handlers return an InvalidArgument status detailing the field violations
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(codes.InvalidArgument, e.Error())
+
+	badRequest := &errdetails.BadRequest{}
+	for _, f := range e.Fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       f.Field,
+			Description: f.Reason,
+		})
+	}
+
+	detailed, err := st.WithDetails(badRequest)
+	if err != nil {
+		return st
+	}
+	return detailed
+}
+
+func (e *Error) add(field string, reason string, args ...interface{}) {
+	e.Fields = append(e.Fields, &FieldError{Field: field, Reason: fmt.Sprintf(reason, args...)})
+}
+
+// Options adjust the application rules
+type Options struct {
+	// AllowReserved accepts the reserved IDs
+	AllowReserved bool
+	// GeneratedID accepts an empty ID, the registry generates one
+	GeneratedID bool
+	// StrongSecret rejects the secrets that are too short or made of too few distinct characters
+	StrongSecret bool
+}
+
+// Application checks an application definition. It returns an *Error listing all the rejected fields
+func Application(a *ome.Application, opts Options) error {
+	e := new(Error)
+	if a == nil {
+		e.add("application", "is required")
+		return e
+	}
+
+	checkID(e, a.Id, opts)
+
+	if _, found := ome.ApplicationLevel_name[int32(a.Level)]; !found {
+		e.add("level", "unknown level %d", a.Level)
+	}
+
+	for _, c := range a.Categories {
+		if _, found := ome.Category_name[int32(c)]; !found {
+			e.add("categories", "unknown category %d", c)
+		}
+	}
+
+	if len(a.Secret) > MaxSecretLength {
+		e.add("secret", "must not exceed %d characters", MaxSecretLength)
+	} else if opts.StrongSecret && a.Secret != "" {
+		checkSecret(e, a.Secret)
+	}
+
+	if a.OauthCallbackUrl != "" {
+		checkURL(e, "oauth_callback_url", a.OauthCallbackUrl, "https")
+	}
+
+	if a.Info == nil {
+		e.add("info", "is required")
+	} else {
+		if a.Info.ApplicationId != "" && a.Info.ApplicationId != a.Id {
+			e.add("info.application_id", "must match the application id")
+		}
+		if len(a.Info.Label) > MaxLabelLength {
+			e.add("info.label", "must not exceed %d characters", MaxLabelLength)
+		}
+		if len(a.Info.Description) > MaxDescriptionLength {
+			e.add("info.description", "must not exceed %d characters", MaxDescriptionLength)
+		}
+		if a.Info.LogoUrl != "" {
+			checkURL(e, "info.logo_url", a.Info.LogoUrl, "https")
+		}
+		if a.Info.Website != "" {
+			checkURL(e, "info.website", a.Info.Website, "http", "https")
+		}
+	}
+
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+func checkID(e *Error, id string, opts Options) {
+	switch {
+	case id == "" && opts.GeneratedID:
+	case id == "":
+		e.add("id", "is required")
+	case len(id) < MinIDLength || len(id) > MaxIDLength:
+		e.add("id", "must be %d to %d characters long", MinIDLength, MaxIDLength)
+	case !idPattern.MatchString(id):
+		e.add("id", "must only contain lower case letters, digits, '.', '_' and '-', and start and end with a letter or a digit")
+	case !opts.AllowReserved && IsReserved(id):
+		e.add("id", "%s is reserved", id)
+	}
+}
+
+func checkSecret(e *Error, secret string) {
+	characters := map[rune]bool{}
+	for _, c := range secret {
+		characters[c] = true
+	}
+
+	if len(secret) < MinSecretLength || len(characters) < MinSecretCharacters {
+		e.add("secret", "must be at least %d characters long with %d distinct characters. Leave it empty to have one generated", MinSecretLength, MinSecretCharacters)
+	}
+}
+
+// checkURL accepts absolute URLs of the given schemes
+func checkURL(e *Error, field string, rawURL string, schemes ...string) {
+	if len(rawURL) > MaxURLLength {
+		e.add(field, "must not exceed %d characters", MaxURLLength)
+		return
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		e.add(field, "must be an absolute URL")
+		return
+	}
+
+	if u.Fragment != "" {
+		e.add(field, "must not contain a fragment")
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+
+	if u.Scheme == "http" && IsLoopback(u.Hostname()) {
+		return
+	}
+	e.add(field, "scheme must be %s", strings.Join(schemes, " or "))
+}
+
+// IsLoopback tells whether host is localhost or a loopback IP address
+func IsLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,157 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/omecodes/libome"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func validApplication() *ome.Application {
+	return &ome.Application{
+		Id:               "my-app",
+		Level:            ome.ApplicationLevel_External,
+		OauthCallbackUrl: "https://app.example.com/callback",
+		Info: &ome.AppInfo{
+			ApplicationId: "my-app",
+			Label:         "My app",
+			LogoUrl:       "https://app.example.com/logo.png",
+			Website:       "https://app.example.com",
+		},
+	}
+}
+
+func TestApplication(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func(a *ome.Application)
+		opts       Options
+		wantFields []string
+	}{
+		{name: "valid"},
+		{name: "missing id", change: func(a *ome.Application) { a.Id = ""; a.Info.ApplicationId = "" }, wantFields: []string{"id"}},
+		{name: "generated id", change: func(a *ome.Application) { a.Id = ""; a.Info.ApplicationId = "" }, opts: Options{GeneratedID: true}},
+		{name: "short id", change: func(a *ome.Application) { a.Id = "a"; a.Info.ApplicationId = "" }, wantFields: []string{"id"}},
+		{name: "long id", change: func(a *ome.Application) { a.Id = strings.Repeat("a", MaxIDLength+1); a.Info.ApplicationId = "" }, wantFields: []string{"id"}},
+		{name: "upper case id", change: func(a *ome.Application) { a.Id = "My-App"; a.Info.ApplicationId = "" }, wantFields: []string{"id"}},
+		{name: "id ending with a dot", change: func(a *ome.Application) { a.Id = "my-app."; a.Info.ApplicationId = "" }, wantFields: []string{"id"}},
+		{name: "reserved id", change: func(a *ome.Application) { a.Id = "admin"; a.Info.ApplicationId = "" }, wantFields: []string{"id"}},
+		{name: "allowed reserved id", change: func(a *ome.Application) { a.Id = "admin"; a.Info.ApplicationId = "" }, opts: Options{AllowReserved: true}},
+		{name: "unknown level", change: func(a *ome.Application) { a.Level = 42 }, wantFields: []string{"level"}},
+		{name: "unknown category", change: func(a *ome.Application) { a.Categories = []ome.Category{42} }, wantFields: []string{"categories"}},
+		{name: "long secret", change: func(a *ome.Application) { a.Secret = strings.Repeat("s", MaxSecretLength+1) }, wantFields: []string{"secret"}},
+		{name: "weak secret", change: func(a *ome.Application) { a.Secret = strings.Repeat("ab", 20) }, opts: Options{StrongSecret: true}, wantFields: []string{"secret"}},
+		{name: "weak secret accepted", change: func(a *ome.Application) { a.Secret = strings.Repeat("ab", 20) }},
+		{name: "strong secret", change: func(a *ome.Application) { a.Secret = "abcdefghijklmnopqrstuvwxyz012345" }, opts: Options{StrongSecret: true}},
+		{name: "relative callback", change: func(a *ome.Application) { a.OauthCallbackUrl = "/callback" }, wantFields: []string{"oauth_callback_url"}},
+		{name: "callback with fragment", change: func(a *ome.Application) { a.OauthCallbackUrl = "https://app.example.com/cb#x" }, wantFields: []string{"oauth_callback_url"}},
+		{name: "ftp callback", change: func(a *ome.Application) { a.OauthCallbackUrl = "ftp://app.example.com/cb" }, wantFields: []string{"oauth_callback_url"}},
+		{name: "http callback", change: func(a *ome.Application) { a.OauthCallbackUrl = "http://example.com/cb" }, wantFields: []string{"oauth_callback_url"}},
+		{name: "http loopback callback", change: func(a *ome.Application) { a.OauthCallbackUrl = "http://127.0.0.1:8080/cb" }},
+		{name: "missing info", change: func(a *ome.Application) { a.Info = nil }, wantFields: []string{"info"}},
+		{name: "other info id", change: func(a *ome.Application) { a.Info.ApplicationId = "other" }, wantFields: []string{"info.application_id"}},
+		{name: "long label", change: func(a *ome.Application) { a.Info.Label = strings.Repeat("l", MaxLabelLength+1) }, wantFields: []string{"info.label"}},
+		{name: "long description", change: func(a *ome.Application) { a.Info.Description = strings.Repeat("d", MaxDescriptionLength+1) }, wantFields: []string{"info.description"}},
+		{name: "http logo", change: func(a *ome.Application) { a.Info.LogoUrl = "http://app.example.com/logo.png" }, wantFields: []string{"info.logo_url"}},
+		{name: "loopback http logo", change: func(a *ome.Application) { a.Info.LogoUrl = "http://localhost:8080/logo.png" }},
+		{name: "long website", change: func(a *ome.Application) {
+			a.Info.Website = "https://app.example.com/" + strings.Repeat("w", MaxURLLength)
+		}, wantFields: []string{"info.website"}},
+		{
+			name: "several fields",
+			change: func(a *ome.Application) {
+				a.Id = "admin"
+				a.Info.ApplicationId = ""
+				a.Level = 42
+				a.Info.Website = "website"
+			},
+			wantFields: []string{"id", "level", "info.website"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := validApplication()
+			if tt.change != nil {
+				tt.change(a)
+			}
+
+			err := Application(a, tt.opts)
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("Application() = %v", err)
+				}
+				return
+			}
+
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("Application() = %v, want an *Error", err)
+			}
+			var fields []string
+			for _, f := range e.Fields {
+				fields = append(fields, f.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("rejected fields are %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestApplicationNil(t *testing.T) {
+	if err := Application(nil, Options{}); err == nil {
+		t.Error("a nil application was accepted")
+	}
+}
+
+func TestErrorGRPCStatus(t *testing.T) {
+	err := Application(&ome.Application{Id: "x", Info: &ome.AppInfo{}}, Options{})
+
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("status code is %s", st.Code())
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("status has %d details", len(st.Details()))
+	}
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	if !ok || len(badRequest.FieldViolations) != 1 || badRequest.FieldViolations[0].Field != "id" {
+		t.Errorf("status details are %v", st.Details())
+	}
+}
+
+func TestIsReserved(t *testing.T) {
+	tests := map[string]bool{
+		"ome":      true,
+		"Registry": true,
+		"ROOT":     true,
+		"omega":    false,
+		"my-admin": false,
+	}
+	for id, want := range tests {
+		if got := IsReserved(id); got != want {
+			t.Errorf("IsReserved(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"localhost":   true,
+		"127.0.0.1":   true,
+		"127.1.2.3":   true,
+		"::1":         true,
+		"example.com": false,
+		"10.0.0.1":    false,
+		"":            false,
+	}
+	for host, want := range tests {
+		if got := IsLoopback(host); got != want {
+			t.Errorf("IsLoopback(%q) = %v, want %v", host, got, want)
+		}
+	}
+}